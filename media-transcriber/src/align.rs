@@ -0,0 +1,237 @@
+use anyhow::Result;
+use log::{debug, info};
+use std::path::{Path, PathBuf};
+
+use crate::captions::{self, Cue, InputEncoding, Word};
+use crate::resegment;
+use crate::utils;
+
+/// Extra diagonal width searched beyond the length difference of the two texts
+/// 
+/// Edited transcripts stay close to the original, so the alignment only looks
+/// this far off the diagonal, which keeps memory linear in the transcript length.
+const ALIGNMENT_BAND: usize = 200;
+
+/// Longest cue in characters (two lines of a typical subtitle)
+const MAX_CUE_CHARS: usize = 84;
+
+/// Longest cue in seconds
+const MAX_CUE_SECONDS: f64 = 6.0;
+
+/// One step of the alignment between edited and original words
+/// 
+/// Variants are ordered by preference when alignments cost the same.
+#[derive(Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum Step {
+    /// Edited word i matches (or replaces) original word j
+    Match,
+    /// Edited word has no original counterpart
+    Insert,
+    /// Original word was removed in the edit
+    Delete,
+}
+
+/// Re-time an edited plain-text transcript using the original word timestamps
+/// 
+/// Writes SRT or VTT depending on the output extension; the default is
+/// `<name>.srt` next to the edited transcript.
+pub fn run(
+    text_file: &Path,
+    words_file: &Path,
+    output: Option<PathBuf>,
+    encoding: Option<InputEncoding>,
+) -> Result<PathBuf> {
+    let edited_text = captions::read_caption_file(text_file, encoding)?;
+    let edited: Vec<&str> = edited_text.split_whitespace().collect();
+    
+    let words = captions::parse_verbose_json(&captions::read_caption_file(words_file, None)?)?.words;
+    if words.is_empty() {
+        return Err(anyhow::anyhow!(
+            "{:?} has no word timestamps; it must be a verbose_json transcript requested with word granularity",
+            words_file
+        ));
+    }
+    
+    let output = output.unwrap_or_else(|| text_file.with_extension("srt"));
+    let extension = output.extension().and_then(|ext| ext.to_str()).unwrap_or("").to_lowercase();
+    if extension != "srt" && extension != "vtt" {
+        return Err(anyhow::anyhow!("Unsupported output format {:?}: expected .srt or .vtt", output));
+    }
+    
+    let timings = align_words(&edited, &words);
+    let cues = group_into_cues(&edited, &timings);
+    
+    let rendered = if extension == "vtt" {
+        captions::write_vtt(&cues)
+    } else {
+        captions::write_srt(&cues)
+    };
+    utils::write_atomic(&output, rendered)?;
+    
+    info!("Aligned {} edited words to {} original words, wrote {} cues to {:?}", edited.len(), words.len(), cues.len(), output);
+    Ok(output)
+}
+
+/// Normalize a word for comparison, ignoring case and punctuation
+fn normalize(word: &str) -> String {
+    word.chars().filter(|c| c.is_alphanumeric()).flat_map(char::to_lowercase).collect()
+}
+
+/// Find a (start, end) time for every edited word
+/// 
+/// Words are aligned with a banded edit-distance alignment. Matched and
+/// substituted words take the original word's timing; inserted words are
+/// spread across the gap between their timed neighbours.
+fn align_words(edited: &[&str], words: &[Word]) -> Vec<(f64, f64)> {
+    let a: Vec<String> = edited.iter().map(|word| normalize(word)).collect();
+    let b: Vec<String> = words.iter().map(|word| normalize(&word.word)).collect();
+    let (n, m) = (a.len(), b.len());
+    
+    // Only cells within `band` of the (scaled) diagonal are considered
+    let band = n.abs_diff(m) + ALIGNMENT_BAND;
+    let width = 2 * band + 1;
+    let column_offset = |i: usize| (i * m / n.max(1)).saturating_sub(band);
+    
+    const UNREACHABLE: u32 = u32::MAX / 2;
+    let mut cost = vec![UNREACHABLE; (n + 1) * width];
+    let mut steps = vec![Step::Match; (n + 1) * width];
+    let cell = |i: usize, j: usize| -> Option<usize> {
+        let offset = column_offset(i);
+        (j >= offset && j - offset < width && j <= m).then(|| i * width + j - offset)
+    };
+    
+    for i in 0..=n {
+        let offset = column_offset(i);
+        for j in offset..=(offset + width - 1).min(m) {
+            let index = cell(i, j).unwrap();
+            if i == 0 && j == 0 {
+                cost[index] = 0;
+                continue;
+            }
+            
+            let mut best = (UNREACHABLE, Step::Match);
+            if i > 0 && j > 0 {
+                if let Some(previous) = cell(i - 1, j - 1) {
+                    let substitution = if a[i - 1] == b[j - 1] { 0 } else { 1 };
+                    best = best.min((cost[previous] + substitution, Step::Match));
+                }
+            }
+            if i > 0 {
+                if let Some(previous) = cell(i - 1, j) {
+                    best = best.min((cost[previous] + 1, Step::Insert));
+                }
+            }
+            if j > 0 {
+                if let Some(previous) = cell(i, j - 1) {
+                    best = best.min((cost[previous] + 1, Step::Delete));
+                }
+            }
+            
+            cost[index] = best.0;
+            steps[index] = best.1;
+        }
+    }
+    
+    // Walk back from the end, recording which original word each edited word maps to
+    let mut matched: Vec<Option<usize>> = vec![None; n];
+    let (mut i, mut j) = (n, m);
+    let mut edits = 0;
+    while i > 0 || j > 0 {
+        let step = match cell(i, j) {
+            Some(index) if cost[index] < UNREACHABLE => steps[index],
+            // Outside the band; only reachable when one side is exhausted
+            _ if i > 0 => Step::Insert,
+            _ => Step::Delete,
+        };
+        match step {
+            Step::Match if i > 0 && j > 0 => {
+                matched[i - 1] = Some(j - 1);
+                if a[i - 1] != b[j - 1] {
+                    edits += 1;
+                }
+                i -= 1;
+                j -= 1;
+            }
+            Step::Insert | Step::Match if i > 0 => {
+                edits += 1;
+                i -= 1;
+            }
+            _ => {
+                edits += 1;
+                j -= 1;
+            }
+        }
+    }
+    debug!("Alignment needed {} word edits", edits);
+    
+    interpolate_timings(&matched, words)
+}
+
+/// Assign timings from matched original words, spreading unmatched words across gaps
+fn interpolate_timings(matched: &[Option<usize>], words: &[Word]) -> Vec<(f64, f64)> {
+    let mut timings = vec![(0.0, 0.0); matched.len()];
+    let mut i = 0;
+    
+    while i < matched.len() {
+        if let Some(j) = matched[i] {
+            timings[i] = (words[j].start, words[j].end);
+            i += 1;
+            continue;
+        }
+        
+        // A run of inserted words fills the gap between the surrounding matches
+        let run_start = i;
+        while i < matched.len() && matched[i].is_none() {
+            i += 1;
+        }
+        let gap_start = run_start
+            .checked_sub(1)
+            .and_then(|previous| matched[previous])
+            .map_or(words[0].start, |j| words[j].end);
+        let gap_end = matched.get(i).copied().flatten().map_or(words[words.len() - 1].end, |j| words[j].start);
+        
+        let step = (gap_end - gap_start).max(0.0) / (i - run_start) as f64;
+        for (k, timing) in timings[run_start..i].iter_mut().enumerate() {
+            let start = gap_start + step * k as f64;
+            *timing = (start, start + step);
+        }
+    }
+    
+    timings
+}
+
+/// Group timed words into subtitle-sized cues, preferring to break at sentence ends
+fn group_into_cues(edited: &[&str], timings: &[(f64, f64)]) -> Vec<Cue> {
+    let mut cues = Vec::new();
+    let mut current: Vec<usize> = Vec::new();
+    
+    let flush = |current: &mut Vec<usize>, cues: &mut Vec<Cue>| {
+        if let (Some(&first), Some(&last)) = (current.first(), current.last()) {
+            cues.push(Cue {
+                start: timings[first].0,
+                end: timings[last].1.max(timings[first].0),
+                text: current.iter().map(|&i| edited[i]).collect::<Vec<_>>().join(" "),
+            });
+        }
+        current.clear();
+    };
+    
+    for i in 0..edited.len() {
+        // Start a new cue if this word would make the current one too long
+        if let Some(&first) = current.first() {
+            let chars: usize = current.iter().map(|&k| edited[k].len() + 1).sum::<usize>() + edited[i].len();
+            if chars > MAX_CUE_CHARS || timings[i].1 - timings[first].0 > MAX_CUE_SECONDS {
+                flush(&mut current, &mut cues);
+            }
+        }
+        
+        current.push(i);
+        
+        if resegment::ends_sentence(edited[i]) {
+            flush(&mut current, &mut cues);
+        }
+    }
+    
+    flush(&mut current, &mut cues);
+    cues
+}
@@ -0,0 +1,472 @@
+use anyhow::Result;
+use log::{debug, info};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+use crate::captions::{Cue, Word};
+use crate::transcription::TranscriptionError;
+use crate::utils;
+
+/// Seconds between checks on a queued transcript when --poll-interval isn't given
+pub const DEFAULT_POLL_INTERVAL_SECS: u64 = 3;
+
+/// Settings for transcribing a file with AssemblyAI
+pub struct AssemblyAi<'a> {
+    /// Client the upload, job and polls are sent with
+    pub client: &'a reqwest::Client,
+    /// API root, e.g. https://api.assemblyai.com/v2
+    pub api_base: &'a str,
+    /// Key sent in the authorization header
+    pub api_key: &'a str,
+    /// Speech model ("best" or "nano")
+    pub model: &'a str,
+    /// Time between checks on the transcript job
+    pub poll_interval: Duration,
+    /// Label each utterance with its speaker
+    pub diarize: bool,
+    /// Show upload progress and a spinner while the job runs
+    pub progress: bool,
+    /// Limits on waiting for and reading each response
+    pub timeouts: utils::HttpTimeouts,
+    /// Where uploads and started jobs are remembered, so a rerun after a dropped
+    /// connection doesn't upload again (None to always start over)
+    pub resume_dir: Option<&'a Path>,
+}
+
+/// A finished AssemblyAI transcript, shaped like a Whisper verbose_json response
+pub struct Transcript {
+    /// Full text; one "Speaker A: ..." paragraph per utterance when diarized
+    pub text: String,
+    /// Detected (or requested) language code
+    pub language: Option<String>,
+    /// Length of the audio in seconds
+    pub duration: Option<f64>,
+    /// Utterances (speaker-tagged when diarized), or sentences of words otherwise
+    pub segments: Vec<Cue>,
+    /// Word timings
+    pub words: Vec<Word>,
+}
+
+/// Body of `POST /upload`
+#[derive(Debug, Deserialize)]
+struct UploadResponse {
+    upload_url: String,
+}
+
+/// Body of `POST /transcript`
+#[derive(Debug, Serialize)]
+struct TranscriptRequest<'a> {
+    audio_url: &'a str,
+    speech_model: &'a str,
+    speaker_labels: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    language_code: Option<&'a str>,
+    /// Detect the language when none is given (AssemblyAI assumes English otherwise)
+    language_detection: bool,
+}
+
+/// A transcript job, as returned on creation and by each poll
+#[derive(Debug, Deserialize)]
+struct TranscriptJob {
+    id: String,
+    /// "queued", "processing", "completed" or "error"
+    status: String,
+    #[serde(default)]
+    error: Option<String>,
+    #[serde(default)]
+    text: Option<String>,
+    #[serde(default)]
+    language_code: Option<String>,
+    /// Length of the audio in seconds
+    #[serde(default)]
+    audio_duration: Option<f64>,
+    #[serde(default)]
+    words: Vec<TimedText>,
+    /// Speaker turns (speaker_labels only)
+    #[serde(default)]
+    utterances: Option<Vec<TimedText>>,
+}
+
+/// What an earlier run got done for a file, saved in `resume_dir`
+#[derive(Debug, Default, Serialize, Deserialize)]
+struct ResumeState {
+    /// Where the audio was uploaded to
+    upload_url: Option<String>,
+    /// Transcript job started on the upload
+    job: Option<StartedJob>,
+}
+
+/// A transcript job and the settings it was started with
+#[derive(Debug, Serialize, Deserialize)]
+struct StartedJob {
+    id: String,
+    settings: String,
+}
+
+/// A word or utterance, timed in milliseconds
+#[derive(Debug, Deserialize)]
+struct TimedText {
+    text: String,
+    start: u64,
+    end: u64,
+    #[serde(default)]
+    speaker: Option<String>,
+}
+
+impl AssemblyAi<'_> {
+    /// Transcribe a file: upload it, start a transcript job and poll it until it's done
+    /// 
+    /// A `None` language turns on AssemblyAI's language detection. With
+    /// `diarize`, the text and segments carry "Speaker A"-style labels.
+    /// 
+    /// With a `resume_dir`, a run cut off after the upload picks up from
+    /// there: the next run reuses the upload (AssemblyAI keeps it for a while)
+    /// and goes back to polling a job that was already started with the same
+    /// settings. An interrupted upload itself starts over, since AssemblyAI
+    /// takes each upload in one request.
+    pub async fn transcribe(&self, audio_file: &Path, language: Option<&str>) -> Result<Transcript> {
+        let settings = format!("{} {} {:?}", self.model, self.diarize, language);
+        let state_file = self.resume_dir.map(|dir| resume_state_file(dir, audio_file, self.api_base, self.api_key)).transpose()?;
+        let mut state: ResumeState = state_file.as_deref().and_then(load_resume_state).unwrap_or_default();
+        
+        // Go back to a job an earlier run started, unless it failed
+        let resumed = match state.job.take().filter(|job| job.settings == settings) {
+            Some(started) => self.fetch_job(&started.id).await.ok().filter(|job| job.status != "error"),
+            None => None,
+        };
+        let job = match resumed {
+            Some(job) => {
+                info!("Resuming AssemblyAI transcript {} for {:?} from an earlier run", job.id, audio_file);
+                job
+            }
+            None => {
+                let job = self.start_job(audio_file, language, &mut state, state_file.as_deref()).await?;
+                info!("AssemblyAI transcript {} queued for {:?}", job.id, audio_file);
+                job
+            }
+        };
+        state.job = Some(StartedJob { id: job.id.clone(), settings });
+        save_resume_state(state_file.as_deref(), &state);
+        
+        let progress = utils::spinner(
+            self.progress,
+            format!("Transcribing {} with AssemblyAI", audio_file.file_name().and_then(|name| name.to_str()).unwrap_or("audio")),
+        );
+        let job = self.wait_for(job).await;
+        progress.finish_and_clear();
+        let job = job?;
+        
+        // The job is over either way; only the upload is worth keeping after a failure
+        state.job = None;
+        if job.status != "completed" {
+            save_resume_state(state_file.as_deref(), &state);
+            return Err(anyhow::anyhow!(
+                "AssemblyAI couldn't transcribe {:?}: {}",
+                audio_file, job.error.as_deref().unwrap_or(&job.status)
+            ));
+        }
+        if let Some(state_file) = &state_file {
+            let _ = fs::remove_file(state_file);
+        }
+        
+        Ok(self.into_transcript(job))
+    }
+    
+    /// Start a transcript job, on an earlier run's upload if AssemblyAI still accepts it
+    async fn start_job(
+        &self,
+        audio_file: &Path,
+        language: Option<&str>,
+        state: &mut ResumeState,
+        state_file: Option<&Path>,
+    ) -> Result<TranscriptJob> {
+        if let Some(upload_url) = &state.upload_url {
+            match self.create_job(upload_url, language).await {
+                Ok(job) => {
+                    info!("Reusing the upload of {:?} from an earlier run", audio_file);
+                    return Ok(job);
+                }
+                // Uploads expire, so one that's refused is sent again
+                Err(e) if is_client_error(&e) => debug!("Earlier upload of {:?} was refused: {}", audio_file, e),
+                Err(e) => return Err(e),
+            }
+        }
+        
+        let upload_url = self.upload(audio_file).await?;
+        state.upload_url = Some(upload_url.clone());
+        save_resume_state(state_file, state);
+        
+        self.create_job(&upload_url, language).await
+    }
+    
+    /// Ask AssemblyAI to transcribe uploaded audio
+    async fn create_job(&self, upload_url: &str, language: Option<&str>) -> Result<TranscriptJob> {
+        let request = TranscriptRequest {
+            audio_url: upload_url,
+            speech_model: self.model,
+            speaker_labels: self.diarize,
+            language_code: language,
+            language_detection: language.is_none(),
+        };
+        let request = self.client
+            .post(format!("{}/transcript", self.api_base))
+            .header("authorization", self.api_key)
+            .json(&request);
+        let response = utils::send_request(request, None, &self.timeouts).await?;
+        parse_response(response, &self.timeouts).await
+    }
+    
+    /// Current state of a transcript job
+    async fn fetch_job(&self, id: &str) -> Result<TranscriptJob> {
+        let request = self.client
+            .get(format!("{}/transcript/{}", self.api_base, id))
+            .header("authorization", self.api_key);
+        let response = utils::send_request(request, None, &self.timeouts).await?;
+        parse_response(response, &self.timeouts).await
+    }
+    
+    /// Poll a transcript job every `poll_interval` until it completes or fails
+    async fn wait_for(&self, mut job: TranscriptJob) -> Result<TranscriptJob> {
+        while job.status == "queued" || job.status == "processing" {
+            tokio::time::sleep(self.poll_interval).await;
+            
+            job = self.fetch_job(&job.id).await?;
+            debug!("AssemblyAI transcript {} is {}", job.id, job.status);
+        }
+        
+        Ok(job)
+    }
+    
+    /// Upload the audio to AssemblyAI's storage, returning the URL a job can read it from
+    async fn upload(&self, audio_file: &Path) -> Result<String> {
+        let audio = tokio::fs::read(audio_file).await?;
+        let label = audio_file.file_name().and_then(|name| name.to_str()).unwrap_or("audio");
+        let (body, progress) = utils::upload_body(self.progress, audio, label).await;
+        
+        let request = self.client
+            .post(format!("{}/upload", self.api_base))
+            .header("authorization", self.api_key)
+            .body(body);
+        let response = utils::send_request(request, Some(&progress), &self.timeouts).await;
+        progress.finish_and_clear();
+        
+        let upload: UploadResponse = parse_response(response?, &self.timeouts).await?;
+        Ok(upload.upload_url)
+    }
+    
+    /// Convert a completed job, labelling speakers when diarizing
+    fn into_transcript(&self, job: TranscriptJob) -> Transcript {
+        let words: Vec<Word> = job.words.iter()
+            .map(|word| Word { word: word.text.clone(), start: seconds(word.start), end: seconds(word.end) })
+            .collect();
+        
+        let (text, segments) = match job.utterances.filter(|_| self.diarize) {
+            Some(utterances) => {
+                let segments: Vec<Cue> = utterances.iter()
+                    .map(|utterance| Cue {
+                        start: seconds(utterance.start),
+                        end: seconds(utterance.end),
+                        text: speaker_tagged(utterance.speaker.as_deref(), &utterance.text),
+                    })
+                    .collect();
+                let text = segments.iter().map(|segment| segment.text.as_str()).collect::<Vec<_>>().join("\n\n");
+                (text, segments)
+            }
+            None => (job.text.unwrap_or_default(), sentences(&job.words)),
+        };
+        
+        Transcript {
+            text,
+            language: job.language_code,
+            duration: job.audio_duration,
+            segments,
+            words,
+        }
+    }
+}
+
+/// Read a JSON body, turning an error status into a `TranscriptionError::Api`
+async fn parse_response<T: serde::de::DeserializeOwned>(response: reqwest::Response, timeouts: &utils::HttpTimeouts) -> Result<T> {
+    let status = response.status();
+    let body = String::from_utf8_lossy(&utils::read_body(response, timeouts).await?).into_owned();
+    
+    if !status.is_success() {
+        // AssemblyAI returns {"error": "..."}
+        let message = serde_json::from_str::<serde_json::Value>(&body)
+            .ok()
+            .and_then(|json| json["error"].as_str().map(str::to_string))
+            .unwrap_or_else(|| body.trim().to_string());
+        return Err(TranscriptionError::Api { status, message }.into());
+    }
+    
+    serde_json::from_str(&body)
+        .map_err(|e| anyhow::anyhow!("Unexpected response from AssemblyAI: {}", e))
+}
+
+/// Whether AssemblyAI refused a request as invalid (4xx), rather than it failing on the way
+fn is_client_error(error: &anyhow::Error) -> bool {
+    matches!(error.downcast_ref::<TranscriptionError>(), Some(TranscriptionError::Api { status, .. }) if status.is_client_error())
+}
+
+/// File remembering an upload of this audio, by its contents and the account it was uploaded with
+fn resume_state_file(dir: &Path, audio_file: &Path, api_base: &str, api_key: &str) -> Result<PathBuf> {
+    let mut hasher = Sha256::new();
+    hasher.update(utils::hash_file(audio_file)?);
+    hasher.update(api_base);
+    hasher.update([0]);
+    hasher.update(api_key);
+    
+    Ok(dir.join(format!("{}.json", hex::encode(hasher.finalize()))))
+}
+
+/// Read a saved resume state; a missing or unreadable one means starting over
+fn load_resume_state(state_file: &Path) -> Option<ResumeState> {
+    serde_json::from_str(&fs::read_to_string(state_file).ok()?).ok()
+}
+
+/// Save a resume state; failing to only costs a re-upload next time
+fn save_resume_state(state_file: Option<&Path>, state: &ResumeState) {
+    let Some(state_file) = state_file else {
+        return;
+    };
+    
+    let saved = state_file.parent()
+        .map_or(Ok(()), fs::create_dir_all)
+        .map_err(anyhow::Error::from)
+        .and_then(|_| utils::write_atomic(state_file, serde_json::to_string(state)?));
+    if let Err(e) = saved {
+        debug!("Failed to save AssemblyAI resume state {:?}: {}", state_file, e);
+    }
+}
+
+/// Prefix utterance text with its speaker, e.g. "Speaker A: Welcome back"
+fn speaker_tagged(speaker: Option<&str>, text: &str) -> String {
+    match speaker {
+        Some(speaker) => format!("Speaker {}: {}", speaker, text.trim()),
+        None => text.trim().to_string(),
+    }
+}
+
+/// Group words into sentence segments, since AssemblyAI only returns segments as utterances
+fn sentences(words: &[TimedText]) -> Vec<Cue> {
+    let mut segments: Vec<Cue> = Vec::new();
+    let mut current: Option<Cue> = None;
+    
+    for word in words {
+        let cue = current.get_or_insert_with(|| Cue { start: seconds(word.start), end: 0.0, text: String::new() });
+        if !cue.text.is_empty() {
+            cue.text.push(' ');
+        }
+        cue.text.push_str(&word.text);
+        cue.end = seconds(word.end);
+        
+        if word.text.ends_with(['.', '?', '!']) {
+            segments.extend(current.take());
+        }
+    }
+    segments.extend(current);
+    
+    segments
+}
+
+/// Milliseconds to seconds
+fn seconds(milliseconds: u64) -> f64 {
+    milliseconds as f64 / 1000.0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::stub_server::{self, Reply};
+    
+    const COMPLETED: &str = r#"{"id": "t1", "status": "completed", "text": "Welcome back.", "language_code": "en"}"#;
+    
+    /// An AssemblyAI client against `api_base`, remembering uploads in `resume_dir`
+    fn client<'a>(http: &'a reqwest::Client, api_base: &'a str, resume_dir: &'a Path) -> AssemblyAi<'a> {
+        AssemblyAi {
+            client: http,
+            api_base,
+            api_key: "aai-test",
+            model: "best",
+            poll_interval: Duration::from_millis(10),
+            diarize: false,
+            progress: false,
+            timeouts: utils::HttpTimeouts::default(),
+            resume_dir: Some(resume_dir),
+        }
+    }
+    
+    /// Audio to transcribe, and where its resume state goes for `api_base`
+    fn fixture(dir: &Path, api_base: &str) -> (PathBuf, PathBuf) {
+        let audio = dir.join("episode.mp3");
+        fs::write(&audio, b"ID3\x03\x00\x00\x00\x00\x00\x00audio").unwrap();
+        let state_file = resume_state_file(&dir.join("uploads"), &audio, api_base, "aai-test").unwrap();
+        (audio, state_file)
+    }
+    
+    fn save(state_file: &Path, state: &ResumeState) {
+        fs::create_dir_all(state_file.parent().unwrap()).unwrap();
+        fs::write(state_file, serde_json::to_string(state).unwrap()).unwrap();
+    }
+    
+    #[tokio::test]
+    async fn an_earlier_upload_is_reused() {
+        let (url, server) = stub_server::serve_each(vec![Reply::ok("application/json", COMPLETED)]).await;
+        let dir = tempfile::tempdir().unwrap();
+        let (audio, state_file) = fixture(dir.path(), &url);
+        save(&state_file, &ResumeState { upload_url: Some("https://cdn.example/u1".to_string()), job: None });
+        
+        let http = reqwest::Client::new();
+        let uploads = dir.path().join("uploads");
+        let transcript = client(&http, &url, &uploads).transcribe(&audio, Some("en")).await.unwrap();
+        
+        assert_eq!(transcript.text, "Welcome back.");
+        let requests = server.await.unwrap();
+        assert_eq!(requests[0].request_line(), "POST /transcript HTTP/1.1");
+        assert!(String::from_utf8_lossy(&requests[0].body).contains("https://cdn.example/u1"));
+        // Done with, so the next run starts over
+        assert!(!state_file.exists());
+    }
+    
+    #[tokio::test]
+    async fn a_refused_upload_is_sent_again() {
+        let mut refused = Reply::ok("application/json", r#"{"error": "Upload not found"}"#);
+        refused.status = 400;
+        let (url, server) = stub_server::serve_each(vec![
+            refused,
+            Reply::ok("application/json", r#"{"upload_url": "https://cdn.example/u2"}"#),
+            Reply::ok("application/json", COMPLETED),
+        ]).await;
+        let dir = tempfile::tempdir().unwrap();
+        let (audio, state_file) = fixture(dir.path(), &url);
+        save(&state_file, &ResumeState { upload_url: Some("https://cdn.example/u1".to_string()), job: None });
+        
+        let http = reqwest::Client::new();
+        let uploads = dir.path().join("uploads");
+        client(&http, &url, &uploads).transcribe(&audio, Some("en")).await.unwrap();
+        
+        let requests = server.await.unwrap();
+        assert_eq!(requests[1].request_line(), "POST /upload HTTP/1.1");
+        assert!(String::from_utf8_lossy(&requests[2].body).contains("https://cdn.example/u2"));
+    }
+    
+    #[tokio::test]
+    async fn a_started_job_is_polled_instead_of_restarted() {
+        let (url, server) = stub_server::serve_each(vec![Reply::ok("application/json", COMPLETED)]).await;
+        let dir = tempfile::tempdir().unwrap();
+        let (audio, state_file) = fixture(dir.path(), &url);
+        let job = StartedJob { id: "t1".to_string(), settings: format!("best false {:?}", Some("en")) };
+        save(&state_file, &ResumeState { upload_url: Some("https://cdn.example/u1".to_string()), job: Some(job) });
+        
+        let http = reqwest::Client::new();
+        let uploads = dir.path().join("uploads");
+        let transcript = client(&http, &url, &uploads).transcribe(&audio, Some("en")).await.unwrap();
+        
+        assert_eq!(transcript.language.as_deref(), Some("en"));
+        let requests = server.await.unwrap();
+        assert_eq!(requests[0].request_line(), "GET /transcript/t1 HTTP/1.1");
+    }
+}
@@ -0,0 +1,353 @@
+use anyhow::Result;
+use colored::Colorize;
+use futures::stream::{self, StreamExt};
+use log::{error, info};
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::config::{Config, OutputFormat};
+use crate::job::JobState;
+use crate::report::RunReport;
+use crate::transcription::TranscriptionService;
+use crate::utils::AUDIO_EXTENSIONS;
+
+/// Placeholders an --output-template can use
+const TEMPLATE_PLACEHOLDERS: &[&str] = &["dir", "name", "ext", "format", "lang"];
+
+/// Where --output-template puts each batch transcript, e.g. `transcripts/{name}.{lang}.{format}`
+#[derive(Debug)]
+pub struct OutputTemplate {
+    template: String,
+    /// Value of `{lang}`
+    language: Option<String>,
+}
+
+impl OutputTemplate {
+    /// Check a template before any file is transcribed
+    /// 
+    /// Placeholders must be known and closed. `{format}` may only be the
+    /// final extension, since SRT, VTT and JSON files are named by swapping the
+    /// transcript's extension, and `{lang}` needs --language.
+    pub fn parse(template: &str, language: Option<&str>) -> Result<Self> {
+        let mut rest = template;
+        let mut placeholders = Vec::new();
+        while let Some(open) = rest.find('{') {
+            let close = rest[open..].find('}')
+                .ok_or_else(|| anyhow::anyhow!("Unclosed '{{' in --output-template {:?}", template))?;
+            let name = &rest[open + 1..open + close];
+            if !TEMPLATE_PLACEHOLDERS.contains(&name) {
+                return Err(anyhow::anyhow!(
+                    "Unknown placeholder {{{}}} in --output-template {:?}; use {}",
+                    name, template,
+                    TEMPLATE_PLACEHOLDERS.iter().map(|name| format!("{{{}}}", name)).collect::<Vec<_>>().join(", ")
+                ));
+            }
+            placeholders.push(name);
+            rest = &rest[open + close + 1..];
+        }
+        
+        let format_count = placeholders.iter().filter(|name| **name == "format").count();
+        if format_count > 1 || (format_count == 1 && !template.ends_with(".{format}")) {
+            return Err(anyhow::anyhow!("{{format}} can only be the final extension in --output-template, e.g. \"{{name}}.{{format}}\""));
+        }
+        if placeholders.contains(&"lang") && language.is_none() {
+            return Err(anyhow::anyhow!("{{lang}} in --output-template needs --language"));
+        }
+        if template.ends_with(['/', std::path::MAIN_SEPARATOR]) {
+            return Err(anyhow::anyhow!("--output-template {:?} names a directory, not a file", template));
+        }
+        
+        Ok(Self { template: template.to_string(), language: language.map(str::to_string) })
+    }
+    
+    /// Transcript path for an audio file (`{format}` is txt; other formats swap the extension)
+    pub fn render(&self, audio_file: &Path) -> PathBuf {
+        let dir = audio_file.parent().filter(|dir| !dir.as_os_str().is_empty()).unwrap_or(Path::new("."));
+        let field = |value: Option<&std::ffi::OsStr>| value.and_then(|value| value.to_str()).unwrap_or("").to_string();
+        
+        PathBuf::from(
+            self.template
+                .replace("{dir}", &dir.to_string_lossy())
+                .replace("{name}", &field(audio_file.file_stem()))
+                .replace("{ext}", &field(audio_file.extension()))
+                .replace("{format}", OutputFormat::Text.extension())
+                .replace("{lang}", self.language.as_deref().unwrap_or("")),
+        )
+    }
+    
+    /// The template as given, recorded in --resume state files
+    pub fn as_str(&self) -> &str {
+        &self.template
+    }
+}
+
+/// Transcribe every audio file in a directory, or matching a `*`/`?` file name pattern
+/// 
+/// Each transcript is written next to its audio file, named after the file's
+/// stem plus `suffix` (e.g. `interview.mp3` -> `interview.txt`), or wherever
+/// `template` puts it, creating directories as needed. Up to
+/// `concurrency` files are transcribed at once. A failed file doesn't stop the
+/// batch, but the run returns the first failure once every file has been
+/// tried; a per-file summary is printed at the end, in file order. With a
+/// `resume` state file, each result is saved as it arrives and files that
+/// succeeded in an earlier run are skipped.
+pub async fn run(
+    input: &str,
+    suffix: &str,
+    template: Option<&OutputTemplate>,
+    concurrency: usize,
+    resume: Option<&Path>,
+    config: &Config,
+    report: &mut RunReport,
+) -> Result<()> {
+    let files = find_audio_files(input)?;
+    if files.is_empty() {
+        return Err(anyhow::anyhow!("No audio files found for {:?}", input));
+    }
+    
+    // Name every transcript up front, so a template that maps two files to one path fails before any work
+    let transcript_files: Vec<PathBuf> = files.iter()
+        .map(|audio_file| match template {
+            Some(template) => template.render(audio_file),
+            None => {
+                let stem = audio_file.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+                audio_file.with_file_name(format!("{}{}", stem, suffix))
+            }
+        })
+        .collect();
+    let mut seen = HashMap::new();
+    for (audio_file, transcript_file) in files.iter().zip(&transcript_files) {
+        if let Some(other) = seen.insert(transcript_file, audio_file) {
+            return Err(anyhow::anyhow!(
+                "{:?} and {:?} would both be transcribed to {:?}; add {{name}} to --output-template",
+                other, audio_file, transcript_file
+            ));
+        }
+    }
+    
+    let naming = template.map_or(suffix, OutputTemplate::as_str);
+    let mut job = resume
+        .map(|path| JobState::load_or_create(path, &files, config, naming))
+        .transpose()?;
+    let completed = job.as_ref().map(JobState::completed).unwrap_or_default();
+    
+    info!("Found {} audio files to transcribe ({} at a time)", files.len(), concurrency);
+    
+    let transcription_service = TranscriptionService::new(config);
+    let total = files.len();
+    
+    // Run up to `concurrency` files at once; `buffered` yields results in file order
+    let mut results = stream::iter(files.iter().zip(transcript_files).enumerate())
+        .map(|(i, (audio_file, transcript_file))| {
+            let transcription_service = &transcription_service;
+            let completed = &completed;
+            async move {
+                if completed.contains(audio_file) {
+                    info!("Skipping file finished in an earlier run: {:?}", audio_file);
+                    return (audio_file, transcript_file, None);
+                }
+                
+                if config.skip_existing && transcript_file.exists() {
+                    info!("Skipping already transcribed file: {:?}", audio_file);
+                    return (audio_file, transcript_file, None);
+                }
+                
+                if let Some(parent) = transcript_file.parent().filter(|parent| !parent.as_os_str().is_empty()) {
+                    if let Err(e) = fs::create_dir_all(parent) {
+                        let error = anyhow::anyhow!("Failed to create output directory {:?}: {}", parent, e);
+                        return (audio_file, transcript_file, Some(Err(error)));
+                    }
+                }
+                
+                info!("Transcribing file {}/{}: {:?}", i + 1, total, audio_file);
+                let result = transcription_service.transcribe_file(audio_file, &transcript_file).await;
+                (audio_file, transcript_file, Some(result))
+            }
+        })
+        .buffered(concurrency.max(1));
+    
+    let mut summary = Vec::with_capacity(total);
+    let mut skipped = 0;
+    let mut first_failure = None;
+    
+    // Ctrl-C drops this whole future in main, cancelling in-flight uploads and podscript processes
+    while let Some((audio_file, transcript_file, result)) = results.next().await {
+        let result = match result {
+            Some(result) => result,
+            None if completed.contains(audio_file) => {
+                summary.push((audio_file, "SKIP".yellow().bold(), "done in an earlier run".to_string()));
+                skipped += 1;
+                continue;
+            }
+            None => {
+                summary.push((audio_file, "SKIP".yellow().bold(), format!("{:?} exists", transcript_file)));
+                skipped += 1;
+                continue;
+            }
+        };
+        
+        report.record(&audio_file.to_string_lossy(), &result);
+        if let Some(job) = &mut job {
+            job.record(audio_file, &result)?;
+        }
+        
+        match result {
+            Ok(outputs) => {
+                let written: Vec<String> = outputs.iter().map(|output| output.display().to_string()).collect();
+                summary.push((audio_file, "OK".green().bold(), written.join(", ")));
+            }
+            Err(e) => {
+                error!("Failed to transcribe {:?}: {}", audio_file, e);
+                summary.push((audio_file, "FAIL".red().bold(), format!("{:#}", e)));
+                first_failure = first_failure.or(Some(e));
+            }
+        }
+    }
+    
+    // Print the per-file summary
+    if !config.quiet {
+        println!();
+        for (audio_file, status, detail) in &summary {
+            println!("[{}] {}: {}", status, audio_file.display(), detail);
+        }
+        println!(
+            "{} succeeded, {} failed, {} skipped",
+            report.totals.succeeded,
+            report.totals.failed,
+            skipped
+        );
+    }
+    
+    // The run fails, with the exit status of the first failure, if any file did
+    match first_failure {
+        Some(e) => Err(e.context(format!("{} of {} files failed", report.totals.failed, total))),
+        None => Ok(()),
+    }
+}
+
+/// List the audio files in a directory, or matching a file name pattern, sorted by path
+pub fn find_audio_files(input: &str) -> Result<Vec<PathBuf>> {
+    let path = Path::new(input);
+    
+    // Wildcards are only supported in the file name part
+    let (dir, pattern) = if path.is_dir() {
+        (path, None)
+    } else if input.contains(['*', '?']) {
+        let dir = path.parent().filter(|dir| !dir.as_os_str().is_empty()).unwrap_or(Path::new("."));
+        let pattern = path.file_name().and_then(|name| name.to_str()).unwrap_or("");
+        (dir, Some(pattern))
+    } else {
+        return Err(anyhow::anyhow!("{:?} is not a directory or a file name pattern like \"recordings/*.mp3\"", input));
+    };
+    
+    let mut files = Vec::new();
+    for entry in fs::read_dir(dir).map_err(|e| anyhow::anyhow!("Failed to read directory {:?}: {}", dir, e))? {
+        let file = entry?.path();
+        let name = file.file_name().and_then(|name| name.to_str()).unwrap_or("");
+        let extension = file.extension().and_then(|ext| ext.to_str()).unwrap_or("").to_lowercase();
+        
+        if file.is_file()
+            && AUDIO_EXTENSIONS.contains(&extension.as_str())
+            && pattern.map_or(true, |pattern| wildcard_match(pattern, name))
+        {
+            files.push(file);
+        }
+    }
+    
+    files.sort();
+    Ok(files)
+}
+
+/// Match a file name against a pattern where `*` is any run of characters and `?` any one
+fn wildcard_match(pattern: &str, name: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let name: Vec<char> = name.chars().collect();
+    let (mut p, mut n) = (0, 0);
+    let mut backtrack: Option<(usize, usize)> = None;
+    
+    while n < name.len() {
+        if p < pattern.len() && (pattern[p] == '?' || pattern[p] == name[n]) {
+            p += 1;
+            n += 1;
+        } else if p < pattern.len() && pattern[p] == '*' {
+            backtrack = Some((p, n));
+            p += 1;
+        } else if let Some((star, matched)) = backtrack {
+            // Let the last `*` swallow one more character and retry
+            p = star + 1;
+            n = matched + 1;
+            backtrack = Some((star, matched + 1));
+        } else {
+            return false;
+        }
+    }
+    
+    pattern[p..].iter().all(|&c| c == '*')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    
+    /// Error message from parsing a template that should be rejected
+    fn template_error(template: &str, language: Option<&str>) -> String {
+        OutputTemplate::parse(template, language).unwrap_err().to_string()
+    }
+    
+    #[test]
+    fn renders_every_placeholder() {
+        let template = OutputTemplate::parse("{dir}/transcripts/{name}.{ext}.{lang}.{format}", Some("en")).unwrap();
+        assert_eq!(
+            template.render(Path::new("shows/ep1.mp3")),
+            PathBuf::from("shows/transcripts/ep1.mp3.en.txt")
+        );
+        
+        // A bare file name lives in the current directory
+        assert_eq!(template.render(Path::new("ep2.m4a")), PathBuf::from("./transcripts/ep2.m4a.en.txt"));
+    }
+    
+    #[test]
+    fn renders_a_template_without_placeholders_verbatim() {
+        let template = OutputTemplate::parse("out/all.txt", None).unwrap();
+        assert_eq!(template.render(Path::new("a/b.mp3")), PathBuf::from("out/all.txt"));
+        assert_eq!(template.as_str(), "out/all.txt");
+    }
+    
+    #[test]
+    fn rejects_unknown_or_unclosed_placeholders() {
+        assert!(template_error("{stem}.txt", None).contains("Unknown placeholder {stem}"));
+        assert!(template_error("{name.txt", None).contains("Unclosed"));
+    }
+    
+    #[test]
+    fn rejects_format_anywhere_but_the_final_extension() {
+        assert!(template_error("{format}/{name}.txt", None).contains("final extension"));
+        assert!(template_error("{name}.{format}.{format}", None).contains("final extension"));
+        assert!(OutputTemplate::parse("{name}.{format}", None).is_ok());
+    }
+    
+    #[test]
+    fn rejects_lang_without_a_language() {
+        assert!(template_error("{name}.{lang}.txt", None).contains("--language"));
+    }
+    
+    #[test]
+    fn rejects_a_directory_template() {
+        assert!(template_error("transcripts/", None).contains("names a directory"));
+    }
+    
+    #[test]
+    fn matches_wildcards() {
+        assert!(wildcard_match("*.mp3", "episode 1.mp3"));
+        assert!(wildcard_match("ep?.m4a", "ep7.m4a"));
+        assert!(wildcard_match("*", ""));
+        assert!(wildcard_match("ep*-*.wav", "ep1-final-mix.wav"));
+        assert!(wildcard_match("café*", "café au lait.ogg"));
+        
+        assert!(!wildcard_match("*.mp3", "episode.mp3.txt"));
+        assert!(!wildcard_match("ep?.m4a", "ep10.m4a"));
+        assert!(!wildcard_match("ep*.wav", "EP1.wav"));
+        assert!(!wildcard_match("?", ""));
+    }
+}
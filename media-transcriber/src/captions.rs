@@ -0,0 +1,594 @@
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::Path;
+
+/// Windows-1252 characters for bytes 0x80-0x9F (the rest match Latin-1)
+const WINDOWS_1252_HIGH: [char; 32] = [
+    '\u{20AC}', '\u{0081}', '\u{201A}', '\u{0192}', '\u{201E}', '\u{2026}', '\u{2020}', '\u{2021}',
+    '\u{02C6}', '\u{2030}', '\u{0160}', '\u{2039}', '\u{0152}', '\u{008D}', '\u{017D}', '\u{008F}',
+    '\u{0090}', '\u{2018}', '\u{2019}', '\u{201C}', '\u{201D}', '\u{2022}', '\u{2013}', '\u{2014}',
+    '\u{02DC}', '\u{2122}', '\u{0161}', '\u{203A}', '\u{0153}', '\u{009D}', '\u{017E}', '\u{0178}',
+];
+
+/// Text encoding of an input caption or transcript file
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum InputEncoding {
+    /// UTF-8 (the default)
+    Utf8,
+    /// ISO-8859-1, common in older SRT files
+    Latin1,
+    /// Windows-1252, Latin-1 plus curly quotes and dashes
+    #[value(name = "windows-1252")]
+    Windows1252,
+}
+
+/// Read a caption or transcript file as UTF-8 text
+/// 
+/// A byte order mark is stripped (UTF-16 files are detected by theirs), and
+/// other files are decoded from `encoding`, defaulting to UTF-8.
+pub fn read_caption_file(path: &Path, encoding: Option<InputEncoding>) -> Result<String> {
+    let bytes = fs::read(path)?;
+    
+    // Byte order marks identify the encoding regardless of --input-encoding
+    if let Some(rest) = bytes.strip_prefix(b"\xEF\xBB\xBF") {
+        return String::from_utf8(rest.to_vec())
+            .map_err(|e| anyhow::anyhow!("{:?} has a UTF-8 byte order mark but isn't valid UTF-8: {}", path, e));
+    }
+    if let Some(rest) = bytes.strip_prefix(b"\xFF\xFE").or_else(|| bytes.strip_prefix(b"\xFE\xFF")) {
+        let little_endian = bytes[0] == 0xFF;
+        let units = rest.chunks_exact(2).map(|pair| {
+            if little_endian { u16::from_le_bytes([pair[0], pair[1]]) } else { u16::from_be_bytes([pair[0], pair[1]]) }
+        });
+        return char::decode_utf16(units)
+            .collect::<Result<String, _>>()
+            .map_err(|e| anyhow::anyhow!("{:?} is not valid UTF-16: {}", path, e));
+    }
+    
+    match encoding {
+        Some(InputEncoding::Latin1) => Ok(bytes.iter().map(|&byte| char::from(byte)).collect()),
+        Some(InputEncoding::Windows1252) => Ok(bytes
+            .iter()
+            .map(|&byte| match byte {
+                0x80..=0x9F => WINDOWS_1252_HIGH[(byte - 0x80) as usize],
+                _ => char::from(byte),
+            })
+            .collect()),
+        Some(InputEncoding::Utf8) | None => String::from_utf8(bytes).map_err(|e| anyhow::anyhow!(
+            "{:?} is not valid UTF-8 ({}); pass --input-encoding latin1 or windows-1252 for older caption files",
+            path, e
+        )),
+    }
+}
+
+/// A timed span of transcript text (an SRT/VTT cue or a Whisper segment)
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Cue {
+    /// Start time in seconds
+    pub start: f64,
+    /// End time in seconds
+    pub end: f64,
+    /// Cue text
+    pub text: String,
+}
+
+/// A single word with timing, from Whisper's word-level timestamps
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Word {
+    /// The word as transcribed
+    pub word: String,
+    /// Start time in seconds
+    pub start: f64,
+    /// End time in seconds
+    pub end: f64,
+}
+
+/// The parts of a Whisper verbose_json response used for caption processing
+#[derive(Debug, Deserialize)]
+pub struct VerboseTranscript {
+    /// Segment-level timings
+    #[serde(default)]
+    pub segments: Vec<Cue>,
+    /// Word-level timings (only present when requested)
+    #[serde(default)]
+    pub words: Vec<Word>,
+}
+
+/// Parse a Whisper verbose_json response
+pub fn parse_verbose_json(content: &str) -> Result<VerboseTranscript> {
+    serde_json::from_str(content)
+        .map_err(|e| anyhow::anyhow!("Invalid verbose_json transcript: {}", e))
+}
+
+/// Parse an SRT timestamp (HH:MM:SS,mmm) into seconds
+fn parse_srt_timestamp(timestamp: &str) -> Option<f64> {
+    let (hms, millis) = timestamp.trim().split_once([',', '.'])?;
+    let parts: Vec<&str> = hms.split(':').collect();
+    
+    let (hours, minutes, seconds) = match parts.as_slice() {
+        [h, m, s] => (h.parse::<f64>().ok()?, m.parse::<f64>().ok()?, s.parse::<f64>().ok()?),
+        [m, s] => (0.0, m.parse::<f64>().ok()?, s.parse::<f64>().ok()?),
+        _ => return None,
+    };
+    
+    let millis: f64 = millis.parse().ok()?;
+    Some(hours * 3600.0 + minutes * 60.0 + seconds + millis / 1000.0)
+}
+
+/// Parse an SRT file into cues
+pub fn parse_srt(content: &str) -> Result<Vec<Cue>> {
+    let mut cues = Vec::new();
+    let normalized = content.replace("\r\n", "\n");
+    
+    for block in normalized.split("\n\n").map(str::trim).filter(|block| !block.is_empty()) {
+        let mut lines = block.lines();
+        
+        // The index line is optional in practice, so look for the timing line
+        let mut timing = lines.next().unwrap_or("");
+        if !timing.contains("-->") {
+            timing = lines.next().unwrap_or("");
+        }
+        
+        let (start, end) = timing
+            .split_once("-->")
+            .and_then(|(start, end)| Some((parse_srt_timestamp(start)?, parse_srt_timestamp(end)?)))
+            .ok_or_else(|| anyhow::anyhow!("Invalid SRT timing line: {:?}", timing))?;
+        
+        let text = lines.collect::<Vec<_>>().join("\n");
+        cues.push(Cue { start, end, text });
+    }
+    
+    Ok(cues)
+}
+
+/// Parse a WebVTT file into cues, dropping cue settings and markup
+pub fn parse_vtt(content: &str) -> Result<Vec<Cue>> {
+    let mut cues = Vec::new();
+    let normalized = content.replace("\r\n", "\n");
+    
+    for block in normalized.split("\n\n").map(str::trim).filter(|block| !block.is_empty()) {
+        // Skip the header and NOTE, STYLE and REGION blocks
+        if block.starts_with("WEBVTT") || block.starts_with("NOTE") || block.starts_with("STYLE") || block.starts_with("REGION") {
+            continue;
+        }
+        
+        let mut lines = block.lines();
+        
+        // Cue identifiers are optional
+        let mut timing = lines.next().unwrap_or("");
+        if !timing.contains("-->") {
+            timing = lines.next().unwrap_or("");
+        }
+        
+        // Cue settings (align:start etc.) follow the end timestamp
+        let (start, end) = timing
+            .split_once("-->")
+            .and_then(|(start, end)| {
+                let end = end.split_whitespace().next()?;
+                Some((parse_srt_timestamp(start)?, parse_srt_timestamp(end)?))
+            })
+            .ok_or_else(|| anyhow::anyhow!("Invalid VTT timing line: {:?}", timing))?;
+        
+        let text = lines.map(strip_vtt_markup).collect::<Vec<_>>().join("\n");
+        cues.push(Cue { start, end, text });
+    }
+    
+    Ok(cues)
+}
+
+/// Remove VTT tags (<v Speaker>, <i>, <00:00:01.000>, ...) and decode entities
+fn strip_vtt_markup(line: &str) -> String {
+    let mut text = String::with_capacity(line.len());
+    let mut in_tag = false;
+    
+    for c in line.chars() {
+        match c {
+            '<' => in_tag = true,
+            '>' if in_tag => in_tag = false,
+            _ if !in_tag => text.push(c),
+            _ => {}
+        }
+    }
+    
+    text.replace("&lt;", "<")
+        .replace("&gt;", ">")
+        .replace("&nbsp;", " ")
+        .replace("&amp;", "&")
+}
+
+/// Format seconds as an SRT timestamp (HH:MM:SS,mmm)
+pub fn format_srt_timestamp(seconds: f64) -> String {
+    let total_millis = (seconds.max(0.0) * 1000.0).round() as u64;
+    format!(
+        "{:02}:{:02}:{:02},{:03}",
+        total_millis / 3_600_000,
+        (total_millis / 60_000) % 60,
+        (total_millis / 1000) % 60,
+        total_millis % 1000
+    )
+}
+
+/// Render cues as SRT, numbering them from 1
+pub fn write_srt(cues: &[Cue]) -> String {
+    let mut srt = String::new();
+    
+    for (i, cue) in cues.iter().enumerate() {
+        srt.push_str(&format!(
+            "{}\n{} --> {}\n{}\n\n",
+            i + 1,
+            format_srt_timestamp(cue.start),
+            format_srt_timestamp(cue.end),
+            cue.text.trim()
+        ));
+    }
+    
+    srt
+}
+
+/// Render cues as WebVTT
+pub fn write_vtt(cues: &[Cue]) -> String {
+    let mut vtt = String::from("WEBVTT\n\n");
+    
+    for cue in cues {
+        vtt.push_str(&format!(
+            "{} --> {}\n{}\n\n",
+            format_srt_timestamp(cue.start).replace(',', "."),
+            format_srt_timestamp(cue.end).replace(',', "."),
+            cue.text.trim()
+        ));
+    }
+    
+    vtt
+}
+
+/// Check that SRT is well-formed, naming the first malformed cue
+/// 
+/// Stricter than `parse_srt`: cues must be numbered from 1 in order, use
+/// `HH:MM:SS,mmm` timestamps, end no earlier than they start and start no
+/// earlier than the cue before them.
+pub fn validate_srt(content: &str) -> Result<()> {
+    let normalized = content.replace("\r\n", "\n");
+    let mut previous_start = 0.0;
+    
+    for (i, block) in normalized.split("\n\n").map(str::trim).filter(|block| !block.is_empty()).enumerate() {
+        let number = i + 1;
+        let mut lines = block.lines();
+        
+        let index = lines.next().unwrap_or("");
+        if index.trim() != number.to_string() {
+            return Err(anyhow::anyhow!("cue {} is numbered {:?}", number, index));
+        }
+        previous_start = check_cue_timing(lines.next().unwrap_or(""), ',', number, previous_start)?;
+    }
+    
+    Ok(())
+}
+
+/// Check that WebVTT is well-formed, naming the first malformed cue
+/// 
+/// The file must start with a `WEBVTT` line; NOTE, STYLE and REGION blocks
+/// are skipped. Cues may have an identifier and settings, and are held to
+/// the same timing rules as `validate_srt`, with `.` before the milliseconds.
+pub fn validate_vtt(content: &str) -> Result<()> {
+    let normalized = content.replace("\r\n", "\n");
+    let header = normalized.lines().next().unwrap_or("");
+    if header != "WEBVTT" && !header.starts_with("WEBVTT ") && !header.starts_with("WEBVTT\t") {
+        return Err(anyhow::anyhow!("missing the WEBVTT header, found {:?}", header));
+    }
+    
+    let mut previous_start = 0.0;
+    let blocks = normalized.split("\n\n").map(str::trim).filter(|block| !block.is_empty()).skip(1);
+    let cues = blocks.filter(|block| !(block.starts_with("NOTE") || block.starts_with("STYLE") || block.starts_with("REGION")));
+    
+    for (i, block) in cues.enumerate() {
+        let mut lines = block.lines();
+        let mut timing = lines.next().unwrap_or("");
+        if !timing.contains("-->") {
+            timing = lines.next().unwrap_or("");
+        }
+        
+        // Settings after the end timestamp aren't checked
+        let timing = match timing.split_once("-->") {
+            Some((start, end)) => format!("{}--> {}", start, end.split_whitespace().next().unwrap_or("")),
+            None => timing.to_string(),
+        };
+        previous_start = check_cue_timing(&timing, '.', i + 1, previous_start)?;
+    }
+    
+    Ok(())
+}
+
+/// Check a cue's `start --> end` line, returning its start
+fn check_cue_timing(timing: &str, separator: char, number: usize, previous_start: f64) -> Result<f64> {
+    let (start, end) = timing
+        .split_once(" --> ")
+        .and_then(|(start, end)| Some((strict_timestamp(start, separator)?, strict_timestamp(end, separator)?)))
+        .ok_or_else(|| anyhow::anyhow!("cue {} has an invalid timing line {:?}", number, timing))?;
+    
+    if end < start {
+        return Err(anyhow::anyhow!("cue {} ends before it starts: {:?}", number, timing));
+    }
+    if start < previous_start {
+        return Err(anyhow::anyhow!(
+            "cue {} starts at {} before the previous cue's {}",
+            number, format_srt_timestamp(start), format_srt_timestamp(previous_start)
+        ));
+    }
+    
+    Ok(start)
+}
+
+/// Parse a `HH:MM:SS<separator>mmm` timestamp (VTT may leave out the hours), rejecting anything looser
+fn strict_timestamp(timestamp: &str, separator: char) -> Option<f64> {
+    let (hms, millis) = timestamp.split_once(separator)?;
+    let parts: Vec<&str> = hms.split(':').collect();
+    let two_digits = |part: &str| part.len() == 2 && part.bytes().all(|b| b.is_ascii_digit());
+    
+    let valid = millis.len() == 3
+        && millis.bytes().all(|b| b.is_ascii_digit())
+        && match parts.as_slice() {
+            [hours, minutes, seconds] => hours.len() >= 2 && hours.bytes().all(|b| b.is_ascii_digit()) && two_digits(minutes) && two_digits(seconds),
+            [minutes, seconds] => separator == '.' && two_digits(minutes) && two_digits(seconds),
+            _ => false,
+        };
+    if !valid || parts[parts.len() - 2] >= "60" || parts[parts.len() - 1] >= "60" {
+        return None;
+    }
+    
+    parse_srt_timestamp(timestamp)
+}
+
+/// Lines a caption cue may take up, the usual limit in captioning guidelines
+pub const MAX_CUE_LINES: usize = 2;
+
+/// Re-flow cues to fit a line length and a duration, for readable captions
+/// 
+/// Text is wrapped on word boundaries into lines of at most `max_line_length`
+/// characters (a longer word gets a line to itself), and a cue is split where
+/// it would need more than `MAX_CUE_LINES` lines or last longer than
+/// `max_duration` seconds. The pieces share the cue's time in proportion to
+/// their characters, so they still cover exactly the original span.
+pub fn constrain_cues(cues: &[Cue], max_line_length: Option<usize>, max_duration: Option<f64>) -> Vec<Cue> {
+    cues.iter()
+        .flat_map(|cue| constrain_cue(cue, max_line_length, max_duration))
+        .collect()
+}
+
+/// Split a single cue for `constrain_cues`
+fn constrain_cue(cue: &Cue, max_line_length: Option<usize>, max_duration: Option<f64>) -> Vec<Cue> {
+    let words: Vec<&str> = cue.text.split_whitespace().collect();
+    if words.is_empty() {
+        return vec![cue.clone()];
+    }
+    
+    // Time at a character offset into the cue's text, counting one space after each word
+    let total_chars: usize = words.iter().map(|word| word.chars().count() + 1).sum();
+    let time_at = |offset: usize| cue.start + (cue.end - cue.start) * offset as f64 / total_chars as f64;
+    
+    let mut pieces = Vec::new();
+    let mut lines: Vec<String> = Vec::new();
+    let mut start_offset = 0;
+    let mut offset = 0;
+    
+    for word in words {
+        let length = word.chars().count();
+        let fits_line = lines.last().map_or(false, |line| {
+            max_line_length.map_or(true, |max| line.chars().count() + 1 + length <= max)
+        });
+        let too_long = max_duration.map_or(false, |max| time_at(offset + length) - time_at(start_offset) > max);
+        
+        // Close the piece when the word would overrun its duration or its last line
+        if !lines.is_empty() && (too_long || (!fits_line && lines.len() == MAX_CUE_LINES)) {
+            pieces.push(Cue { start: time_at(start_offset), end: time_at(offset), text: lines.join("\n") });
+            lines.clear();
+            start_offset = offset;
+        }
+        
+        match lines.last_mut() {
+            Some(line) if fits_line => {
+                line.push(' ');
+                line.push_str(word);
+            }
+            _ => lines.push(word.to_string()),
+        }
+        offset += length + 1;
+    }
+    pieces.push(Cue { start: time_at(start_offset), end: cue.end, text: lines.join("\n") });
+    
+    pieces
+}
+
+/// Render cues as JSON in the shape of Whisper's verbose_json segments
+pub fn write_segments_json(cues: &[Cue]) -> Result<String> {
+    #[derive(Serialize)]
+    struct Segment<'a> {
+        id: usize,
+        start: f64,
+        end: f64,
+        text: &'a str,
+    }
+    
+    #[derive(Serialize)]
+    struct Segments<'a> {
+        segments: Vec<Segment<'a>>,
+    }
+    
+    let segments = cues
+        .iter()
+        .enumerate()
+        .map(|(id, cue)| Segment { id, start: cue.start, end: cue.end, text: cue.text.trim() })
+        .collect();
+    
+    Ok(serde_json::to_string_pretty(&Segments { segments })?)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    
+    /// Write `bytes` to a caption file in a fresh temp directory
+    fn fixture(bytes: &[u8]) -> (tempfile::TempDir, std::path::PathBuf) {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("captions.srt");
+        fs::write(&path, bytes).unwrap();
+        (dir, path)
+    }
+    
+    #[test]
+    fn strips_a_utf8_byte_order_mark() {
+        let (_dir, path) = fixture(b"\xEF\xBB\xBF1\r\n00:00:01,000 --> 00:00:02,500\r\nCaf\xC3\xA9 au lait\r\n\r\n");
+        
+        let content = read_caption_file(&path, None).unwrap();
+        assert!(content.starts_with('1'));
+        
+        let cues = parse_srt(&content).unwrap();
+        assert_eq!(cues.len(), 1);
+        assert_eq!((cues[0].start, cues[0].end), (1.0, 2.5));
+        assert_eq!(cues[0].text, "Café au lait");
+    }
+    
+    #[test]
+    fn byte_order_mark_overrides_the_input_encoding() {
+        let (_dir, path) = fixture(b"\xEF\xBB\xBFCaf\xC3\xA9");
+        assert_eq!(read_caption_file(&path, Some(InputEncoding::Latin1)).unwrap(), "Café");
+    }
+    
+    #[test]
+    fn decodes_utf16_with_a_byte_order_mark() {
+        let (_dir, path) = fixture(b"\xFF\xFEC\x00a\x00f\x00\xE9\x00");
+        assert_eq!(read_caption_file(&path, None).unwrap(), "Café");
+    }
+    
+    #[test]
+    fn decodes_latin1_when_asked() {
+        let (_dir, path) = fixture(b"1\n00:00:00,000 --> 00:00:01,000\nCaf\xE9 cr\xE8me\n");
+        
+        let cues = parse_srt(&read_caption_file(&path, Some(InputEncoding::Latin1)).unwrap()).unwrap();
+        assert_eq!(cues[0].text, "Café crème");
+    }
+    
+    #[test]
+    fn decodes_windows_1252_punctuation() {
+        let (_dir, path) = fixture(b"\x93Quoted\x94 \x96 caf\xE9");
+        assert_eq!(read_caption_file(&path, Some(InputEncoding::Windows1252)).unwrap(), "\u{201C}Quoted\u{201D} \u{2013} café");
+    }
+    
+    #[test]
+    fn rejects_latin1_read_as_utf8() {
+        let (_dir, path) = fixture(b"Caf\xE9");
+        let error = read_caption_file(&path, None).unwrap_err().to_string();
+        assert!(error.contains("--input-encoding"), "{}", error);
+    }
+    
+    /// Words of the cues, in order, across all their lines
+    fn words_of(cues: &[Cue]) -> Vec<String> {
+        cues.iter().flat_map(|cue| cue.text.split_whitespace().map(str::to_string)).collect()
+    }
+    
+    /// Check that pieces run back to back from `start` to `end`
+    fn assert_contiguous(pieces: &[Cue], start: f64, end: f64) {
+        assert_eq!(pieces.first().unwrap().start, start);
+        assert_eq!(pieces.last().unwrap().end, end);
+        for pair in pieces.windows(2) {
+            assert!((pair[0].end - pair[1].start).abs() < 1e-9, "{:?}", pieces);
+        }
+    }
+    
+    #[test]
+    fn splits_a_long_segment_into_two_line_cues() {
+        let cue = Cue {
+            start: 10.0,
+            end: 20.0,
+            text: "The quick brown fox jumps over the lazy dog near the river bank late in the afternoon".to_string(),
+        };
+        let pieces = constrain_cues(&[cue.clone()], Some(20), None);
+        
+        assert!(pieces.len() > 1);
+        for piece in &pieces {
+            let lines: Vec<&str> = piece.text.lines().collect();
+            assert!(lines.len() <= MAX_CUE_LINES, "{:?}", piece.text);
+            assert!(lines.iter().all(|line| line.chars().count() <= 20), "{:?}", piece.text);
+        }
+        assert_eq!(pieces[0].text, "The quick brown fox\njumps over the lazy");
+        assert_eq!(words_of(&pieces), words_of(&[cue]));
+        assert_contiguous(&pieces, 10.0, 20.0);
+    }
+    
+    #[test]
+    fn splits_a_long_segment_by_duration() {
+        let cue = Cue { start: 0.0, end: 12.0, text: "one two three four five six seven eight nine ten eleven twelve".to_string() };
+        let pieces = constrain_cues(&[cue.clone()], None, Some(4.0));
+        
+        assert!(pieces.len() >= 3);
+        assert!(pieces.iter().all(|piece| piece.end - piece.start <= 4.0 + 1e-9), "{:?}", pieces);
+        assert!(pieces.iter().all(|piece| !piece.text.contains('\n')));
+        assert_eq!(words_of(&pieces), words_of(&[cue]));
+        assert_contiguous(&pieces, 0.0, 12.0);
+    }
+    
+    #[test]
+    fn gives_an_overlong_word_its_own_line() {
+        let cue = Cue { start: 0.0, end: 3.0, text: "see supercalifragilistic now".to_string() };
+        let pieces = constrain_cues(&[cue], Some(10), None);
+        
+        assert_eq!(pieces.len(), 2);
+        assert_eq!(pieces[0].text, "see\nsupercalifragilistic");
+        assert_eq!(pieces[1].text, "now");
+    }
+    
+    #[test]
+    fn leaves_cues_that_fit_unchanged() {
+        let cue = Cue { start: 1.0, end: 2.0, text: "Short line.".to_string() };
+        let pieces = constrain_cues(&[cue], Some(42), Some(6.0));
+        
+        assert_eq!(pieces.len(), 1);
+        assert_eq!((pieces[0].start, pieces[0].end), (1.0, 2.0));
+        assert_eq!(pieces[0].text, "Short line.");
+    }
+    
+    #[test]
+    fn rendered_captions_validate() {
+        let cues = vec![
+            Cue { start: 0.0, end: 2.5, text: "Welcome back.".to_string() },
+            Cue { start: 2.5, end: 2.5, text: "".to_string() },
+            Cue { start: 3725.0, end: 3726.48, text: "Two\nlines".to_string() },
+        ];
+        
+        validate_srt(&write_srt(&cues)).unwrap();
+        validate_vtt(&write_vtt(&cues)).unwrap();
+        validate_srt("").unwrap();
+        validate_vtt("WEBVTT - podcast\n\nNOTE written by hand\n\nintro\n00:01.000 --> 00:02.000 align:start\nHi\n").unwrap();
+    }
+    
+    #[test]
+    fn malformed_srt_names_the_cue() {
+        let cases = [
+            ("1\n00:00:00,000 --> 00:00:01,000\nA\n\n3\n00:00:01,000 --> 00:00:02,000\nB\n", "cue 2 is numbered \"3\""),
+            ("1\n00:00:00.000 --> 00:00:01,000\nA\n", "cue 1 has an invalid timing line"),
+            ("1\n00:00:00,000 -> 00:00:01,000\nA\n", "cue 1 has an invalid timing line"),
+            ("1\n00:00:02,000 --> 00:00:01,000\nA\n", "cue 1 ends before it starts"),
+            ("1\n00:01:00,000 --> 00:01:01,000\nA\n\n2\n00:00:59,000 --> 00:01:02,000\nB\n", "cue 2 starts at 00:00:59,000 before the previous cue's 00:01:00,000"),
+            ("1\n00:00:61,000 --> 00:01:02,000\nA\n", "cue 1 has an invalid timing line"),
+        ];
+        
+        for (srt, expected) in cases {
+            let error = validate_srt(srt).unwrap_err().to_string();
+            assert!(error.starts_with(expected), "{:?}: {}", srt, error);
+        }
+    }
+    
+    #[test]
+    fn malformed_vtt_names_the_problem() {
+        let cases = [
+            ("1\n00:00:00.000 --> 00:00:01.000\nA\n", "missing the WEBVTT header"),
+            ("WEBVTT\n\n00:00:00,000 --> 00:00:01.000\nA\n", "cue 1 has an invalid timing line"),
+            ("WEBVTT\n\nA line without timing\n", "cue 1 has an invalid timing line"),
+            ("WEBVTT\n\n00:05.000 --> 00:06.000\nA\n\n00:04.000 --> 00:07.000\nB\n", "cue 2 starts at 00:00:04,000"),
+        ];
+        
+        for (vtt, expected) in cases {
+            let error = validate_vtt(vtt).unwrap_err().to_string();
+            assert!(error.starts_with(expected), "{:?}: {}", vtt, error);
+        }
+    }
+}
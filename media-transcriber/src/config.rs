@@ -1,22 +1,591 @@
-use anyhow::{Context, Result};
+use anyhow::Result;
+use chrono::NaiveDate;
 use dotenv::dotenv;
-use log::{debug, info};
+use log::{debug, info, warn};
 use std::env;
 use std::fs;
 use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::time::Duration;
 use thiserror::Error;
 
+use crate::utils::{self, RetryPolicy};
+
 /// Configuration errors
 #[derive(Error, Debug)]
 pub enum ConfigError {
-    #[error("API key not found. Please set OPENAI_API_KEY environment variable or use --api-key option")]
-    ApiKeyNotFound,
+    #[error("API key not found. Please set {0} environment variable or use --api-key option")]
+    ApiKeyNotFound(&'static str),
+}
+
+/// Transcription backend
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum Provider {
+    /// OpenAI's Whisper API, called through the podscript binary (or directly with --api-base)
+    Openai,
+    /// A local whisper.cpp server with an OpenAI-compatible endpoint
+    Local,
+    /// Groq's hosted Whisper models, through its OpenAI-compatible API
+    Groq,
+    /// AssemblyAI's transcript API, which can label speakers (--diarize)
+    Assemblyai,
+}
+
+impl Provider {
+    /// Name as accepted on the command line
+    pub fn name(&self) -> &'static str {
+        match self {
+            Provider::Openai => "openai",
+            Provider::Local => "local",
+            Provider::Groq => "groq",
+            Provider::Assemblyai => "assemblyai",
+        }
+    }
+    
+    /// Name for messages and prompts
+    pub fn label(&self) -> &'static str {
+        match self {
+            Provider::Openai => "OpenAI",
+            Provider::Local => "whisper.cpp",
+            Provider::Groq => "Groq",
+            Provider::Assemblyai => "AssemblyAI",
+        }
+    }
+    
+    /// Base URL used when --api-base isn't given
+    pub fn default_api_base(&self) -> &'static str {
+        match self {
+            Provider::Openai => "https://api.openai.com/v1",
+            Provider::Local => "http://localhost:8080/v1",
+            Provider::Groq => "https://api.groq.com/openai/v1",
+            Provider::Assemblyai => "https://api.assemblyai.com/v2",
+        }
+    }
+    
+    /// Environment variable holding the provider's API key
+    pub fn api_key_env(&self) -> &'static str {
+        match self {
+            Provider::Openai | Provider::Local => "OPENAI_API_KEY",
+            Provider::Groq => "GROQ_API_KEY",
+            Provider::Assemblyai => "ASSEMBLYAI_API_KEY",
+        }
+    }
+    
+    /// Model used when --model isn't given
+    pub fn default_model(&self) -> &'static str {
+        match self {
+            Provider::Openai | Provider::Local => "whisper-1",
+            Provider::Groq => "whisper-large-v3",
+            Provider::Assemblyai => "best",
+        }
+    }
+    
+    /// Models the provider accepts; empty if it takes any name (whisper.cpp serves whatever it loaded)
+    pub fn models(&self) -> &'static [&'static str] {
+        match self {
+            Provider::Openai => &["whisper-1", "gpt-4o-transcribe", "gpt-4o-mini-transcribe"],
+            Provider::Local => &[],
+            Provider::Groq => &["whisper-large-v3", "whisper-large-v3-turbo", "distil-whisper-large-v3-en"],
+            Provider::Assemblyai => &["best", "nano"],
+        }
+    }
+    
+    /// Fail early on a model the provider would reject with a 400
+    pub fn validate_model(&self, model: &str) -> Result<()> {
+        let models = self.models();
+        if !models.is_empty() && !models.contains(&model) {
+            return Err(anyhow::anyhow!(
+                "{} doesn't offer model {:?}; choose one of {}",
+                self.label(), model, models.join(", ")
+            ));
+        }
+        
+        Ok(())
+    }
+    
+    /// Whether the provider refuses requests without an API key
+    pub fn requires_api_key(&self) -> bool {
+        match self {
+            Provider::Openai | Provider::Groq | Provider::Assemblyai => true,
+            Provider::Local => false,
+        }
+    }
+    
+    /// Whether the provider can label who is speaking (--diarize); Whisper can't
+    pub fn supports_diarization(&self) -> bool {
+        *self == Provider::Assemblyai
+    }
+}
+
+/// What a transcription model can return, for catching unsupported options before any upload
+#[derive(Debug)]
+pub struct ModelCapabilities {
+    /// Model ID
+    pub model: &'static str,
+    /// Response format asked for by default
+    pub default_format: &'static str,
+    /// Whether the model returns verbose_json (segment and word timings, language, duration)
+    pub verbose_json: bool,
+    /// Whether the model supports the translations endpoint
+    pub translate: bool,
+}
+
+/// Capabilities of the models that don't behave like Whisper; add a row for each new one
+const MODEL_CAPABILITIES: &[ModelCapabilities] = &[
+    ModelCapabilities { model: "gpt-4o-transcribe", default_format: "json", verbose_json: false, translate: false },
+    ModelCapabilities { model: "gpt-4o-mini-transcribe", default_format: "json", verbose_json: false, translate: false },
+    // AssemblyAI's speech models return word timings and utterances, which stand in for segments
+    ModelCapabilities { model: "best", default_format: "verbose_json", verbose_json: true, translate: false },
+    ModelCapabilities { model: "nano", default_format: "verbose_json", verbose_json: true, translate: false },
+];
+
+/// Capabilities of whisper-1 and other Whisper models (Groq's, whisper.cpp's)
+const WHISPER_CAPABILITIES: ModelCapabilities = ModelCapabilities {
+    model: "whisper",
+    default_format: "verbose_json",
+    verbose_json: true,
+    translate: true,
+};
+
+/// Look up what a model can do; unknown models are assumed to be Whisper variants
+pub fn model_capabilities(model: &str) -> &'static ModelCapabilities {
+    MODEL_CAPABILITIES.iter()
+        .find(|capabilities| capabilities.model == model)
+        .unwrap_or(&WHISPER_CAPABILITIES)
+}
+
+/// A test on a file that a --model-policy rule can require
+#[derive(Debug, Clone, PartialEq)]
+pub enum ModelCondition {
+    /// `duration<10m`: audio shorter than this
+    ShorterThan(Duration),
+    /// `duration>=1h`: audio at least this long
+    AtLeast(Duration),
+    /// `language=en`: --language is this code
+    Language(String),
+    /// `language!=en`: --language is given and isn't this code
+    NotLanguage(String),
+}
+
+impl FromStr for ModelCondition {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        if let Some(value) = s.strip_prefix("duration>=") {
+            Ok(Self::AtLeast(utils::parse_duration(value)?))
+        } else if let Some(value) = s.strip_prefix("duration<") {
+            Ok(Self::ShorterThan(utils::parse_duration(value)?))
+        } else if let Some(code) = s.strip_prefix("language!=") {
+            Ok(Self::NotLanguage(code.to_lowercase()))
+        } else if let Some(code) = s.strip_prefix("language=") {
+            Ok(Self::Language(code.to_lowercase()))
+        } else {
+            Err(format!("unknown condition '{}'; use duration<10m, duration>=1h, language=en or language!=en", s))
+        }
+    }
+}
+
+impl ModelCondition {
+    /// Whether a file of this length (None if unknown) and --language meets the condition
+    /// 
+    /// Unknown lengths and languages meet no condition on them.
+    pub fn holds(&self, duration: Option<f64>, language: Option<&str>) -> bool {
+        match self {
+            Self::ShorterThan(limit) => duration.map_or(false, |seconds| seconds < limit.as_secs_f64()),
+            Self::AtLeast(limit) => duration.map_or(false, |seconds| seconds >= limit.as_secs_f64()),
+            Self::Language(code) => language.map_or(false, |language| language.eq_ignore_ascii_case(code)),
+            Self::NotLanguage(code) => language.map_or(false, |language| !language.eq_ignore_ascii_case(code)),
+        }
+    }
+}
+
+/// One line of a model policy: use `model` for files meeting every condition
+#[derive(Debug, Clone, PartialEq)]
+pub struct ModelRule {
+    /// Model to transcribe with
+    pub model: String,
+    /// Conditions a file must meet; none matches every file
+    pub conditions: Vec<ModelCondition>,
+    /// The line as written, to explain the choice in the log
+    pub text: String,
+}
+
+/// Rules --auto-model picks each file's model by; the first rule that matches wins
+/// 
+/// Written one rule per line, a model followed by its conditions, with `#`
+/// for comments:
+/// 
+/// ```text
+/// gpt-4o-mini-transcribe  duration<10m
+/// whisper-1               language!=en
+/// gpt-4o-transcribe
+/// ```
+#[derive(Debug, Clone, PartialEq)]
+pub struct ModelPolicy(pub Vec<ModelRule>);
+
+impl FromStr for ModelPolicy {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let mut rules = Vec::new();
+        
+        for (number, line) in s.lines().enumerate() {
+            let line = line.split('#').next().unwrap_or("").trim();
+            let mut words = line.split_whitespace();
+            let Some(model) = words.next() else {
+                continue;
+            };
+            
+            let conditions = words
+                .map(str::parse)
+                .collect::<Result<Vec<ModelCondition>, _>>()
+                .map_err(|e| format!("line {}: {}", number + 1, e))?;
+            rules.push(ModelRule { model: model.to_string(), conditions, text: line.to_string() });
+        }
+        
+        if rules.is_empty() {
+            return Err("the policy has no rules".to_string());
+        }
+        Ok(Self(rules))
+    }
+}
+
+/// --auto-model policy for OpenAI: the mini model for short clips, Whisper for other languages
+const OPENAI_MODEL_POLICY: &str = "
+gpt-4o-mini-transcribe  duration<10m
+whisper-1               language!=en
+gpt-4o-transcribe
+";
+
+/// --auto-model policy for Groq: the English-only distilled model when it applies, turbo for shorter files
+const GROQ_MODEL_POLICY: &str = "
+distil-whisper-large-v3-en  language=en
+whisper-large-v3-turbo      duration<30m
+whisper-large-v3
+";
+
+/// --auto-model policy for AssemblyAI: nano for short clips
+const ASSEMBLYAI_MODEL_POLICY: &str = "
+nano  duration<10m
+best
+";
+
+impl ModelPolicy {
+    /// Policy used when --model-policy isn't given; None for whisper.cpp, which serves a single model
+    pub fn builtin(provider: Provider) -> Option<Self> {
+        let policy = match provider {
+            Provider::Openai => OPENAI_MODEL_POLICY,
+            Provider::Groq => GROQ_MODEL_POLICY,
+            Provider::Assemblyai => ASSEMBLYAI_MODEL_POLICY,
+            Provider::Local => return None,
+        };
+        Some(policy.parse().expect("built-in model policies are valid"))
+    }
+    
+    /// Read a policy file
+    pub fn load(path: &Path) -> Result<Self> {
+        let content = fs::read_to_string(path)
+            .map_err(|e| anyhow::anyhow!("Failed to read model policy {:?}: {}", path, e))?;
+        content.parse().map_err(|e| anyhow::anyhow!("Invalid model policy {:?}: {}", path, e))
+    }
+    
+    /// First rule a file meets whose model `usable` accepts
+    pub fn choose(&self, duration: Option<f64>, language: Option<&str>, usable: impl Fn(&str) -> bool) -> Option<&ModelRule> {
+        self.0.iter().find(|rule| {
+            rule.conditions.iter().all(|condition| condition.holds(duration, language)) && usable(&rule.model)
+        })
+    }
+}
+
+/// Level of timing detail requested with --timestamps or --granularity
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum TimestampGranularity {
+    /// Start and end of every word (plus segments, with --timestamps)
+    Word,
+    /// Start and end of every segment (roughly a sentence)
+    Segment,
+}
+
+impl TimestampGranularity {
+    /// Values sent as `timestamp_granularities[]` for --timestamps
+    pub fn api_values(&self) -> &'static [&'static str] {
+        match self {
+            TimestampGranularity::Word => &["word", "segment"],
+            TimestampGranularity::Segment => &["segment"],
+        }
+    }
+    
+    /// Value sent as `timestamp_granularities[]` when picked on its own with --granularity
+    pub fn api_value(&self) -> &'static str {
+        match self {
+            TimestampGranularity::Word => "word",
+            TimestampGranularity::Segment => "segment",
+        }
+    }
+}
+
+/// Transcript file format written with --response-format
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum OutputFormat {
+    /// Plain text (the main transcript, always written)
+    Text,
+    /// SubRip subtitles
+    Srt,
+    /// WebVTT subtitles
+    Vtt,
+    /// Whisper's verbose_json: text, language, duration and segments
+    Json,
+    /// Versioned JSON envelope: source, model, parameters, language, duration, text and segments
+    PodscriptJson,
+}
+
+impl OutputFormat {
+    /// File extension for the format
+    pub fn extension(&self) -> &'static str {
+        match self {
+            OutputFormat::Text => "txt",
+            OutputFormat::Srt => "srt",
+            OutputFormat::Vtt => "vtt",
+            OutputFormat::Json => "json",
+            OutputFormat::PodscriptJson => "podscript.json",
+        }
+    }
+}
+
+/// An extra file written with --output-spec, with options of its own
+/// 
+/// Written as `format:path[:option,...]`, e.g. `srt:{name}.short.srt:maxcue=5s`
+/// or `txt:{name}.read.txt:paragraphs`. Caption options fall back to
+/// --max-cue-duration and --max-line-length.
+#[derive(Debug, Clone, PartialEq)]
+pub struct OutputSpec {
+    pub format: OutputFormat,
+    /// Where to write it, relative to the transcript's directory; `{name}` is the transcript's file stem
+    pub path: String,
+    /// Split cues longer than this many seconds (srt, vtt)
+    pub max_cue_duration: Option<f64>,
+    /// Wrap cue text at this many characters (srt, vtt)
+    pub max_line_length: Option<usize>,
+    /// Start a paragraph wherever the speaker paused (txt)
+    pub paragraphs: bool,
+}
+
+impl FromStr for OutputSpec {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let mut fields = s.splitn(3, ':');
+        let format = match fields.next().unwrap_or("").trim() {
+            "txt" => OutputFormat::Text,
+            name => <OutputFormat as clap::ValueEnum>::from_str(name, true)
+                .map_err(|_| format!("unknown format '{}' in '{}'; use txt, srt, vtt, json or podscript-json", name, s))?,
+        };
+        let path = fields.next().map(str::trim).filter(|path| !path.is_empty())
+            .ok_or_else(|| format!("'{}' has no file name; use format:path, e.g. srt:{{name}}.srt", s))?;
+        
+        let mut spec = Self { format, path: path.to_string(), max_cue_duration: None, max_line_length: None, paragraphs: false };
+        let captions = matches!(format, OutputFormat::Srt | OutputFormat::Vtt);
+        for option in fields.next().unwrap_or("").split(',').map(str::trim).filter(|option| !option.is_empty()) {
+            match option.split_once('=') {
+                Some(("maxcue", value)) if captions => {
+                    spec.max_cue_duration = Some(utils::parse_duration(value)?.as_secs_f64());
+                }
+                Some(("maxline", value)) if captions => {
+                    spec.max_line_length = Some(value.parse().ok().filter(|chars| *chars >= 10)
+                        .ok_or_else(|| format!("maxline must be at least 10 characters, got '{}'", value))?);
+                }
+                None if option == "paragraphs" && format == OutputFormat::Text => spec.paragraphs = true,
+                _ => {
+                    let allowed = match format {
+                        OutputFormat::Srt | OutputFormat::Vtt => "maxcue=DURATION and maxline=CHARS",
+                        OutputFormat::Text => "paragraphs",
+                        OutputFormat::Json | OutputFormat::PodscriptJson => "none",
+                    };
+                    return Err(format!("'{}' isn't an option for {} output (options: {})", option, format.extension(), allowed));
+                }
+            }
+        }
+        
+        Ok(spec)
+    }
+}
+
+impl OutputSpec {
+    /// File to write for the transcript `output_file`
+    pub fn path_for(&self, output_file: &Path) -> PathBuf {
+        let name = output_file.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+        let path = PathBuf::from(self.path.replace("{name}", name));
+        match output_file.parent() {
+            Some(dir) if path.is_relative() => dir.join(path),
+            _ => path,
+        }
+    }
+}
+
+/// Format audio is converted to with --transcode
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum TranscodeFormat {
+    /// MP3, accepted everywhere
+    Mp3,
+    /// Opus in an Ogg container, about half the size of MP3 for speech
+    Opus,
+}
+
+impl TranscodeFormat {
+    /// File extension for the converted file
+    pub fn extension(&self) -> &'static str {
+        match self {
+            TranscodeFormat::Mp3 => "mp3",
+            TranscodeFormat::Opus => "ogg",
+        }
+    }
+    
+    /// ffmpeg encoder and bitrate arguments
+    pub fn codec_args(&self) -> &'static [&'static str] {
+        match self {
+            TranscodeFormat::Mp3 => &["-c:a", "libmp3lame", "-b:a", "48k"],
+            TranscodeFormat::Opus => &["-c:a", "libopus", "-b:a", "24k"],
+        }
+    }
+}
+
+/// Which audio streams of a multi-track file to transcribe
+#[derive(Debug, Clone, PartialEq)]
+pub enum AudioStreamSelection {
+    /// Transcribe every audio stream separately
+    All,
+    /// Transcribe a single audio stream (1-based)
+    Index(usize),
+}
+
+impl FromStr for AudioStreamSelection {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        if s.eq_ignore_ascii_case("all") {
+            return Ok(Self::All);
+        }
+        
+        match s.parse::<usize>() {
+            Ok(index) if index > 0 => Ok(Self::Index(index)),
+            _ => Err(format!("expected 'all' or a stream number starting at 1, got '{}'", s)),
+        }
+    }
+}
+
+/// How much transcript goes into each part written by --split-output-every
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum SplitEvery {
+    /// Roughly this much audio per part (needs segment timings)
+    Duration(Duration),
+    /// Roughly this many words per part
+    Words(usize),
+}
+
+impl FromStr for SplitEvery {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        // A bare number is a word count, as it was before durations were accepted
+        let words = s.trim().strip_suffix(['w', 'W']).unwrap_or(s.trim());
+        if let Ok(words) = words.parse::<usize>() {
+            return match words {
+                0 => Err("--split-output-every must be greater than zero".to_string()),
+                words => Ok(Self::Words(words)),
+            };
+        }
+        
+        utils::parse_duration(s)
+            .map(Self::Duration)
+            .map_err(|_| format!("expected a word count like 2000w or a duration like 10m, got '{}'", s))
+    }
+}
+
+/// How --wrap lays out plain-text transcripts
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum TextWrap {
+    /// Start a new paragraph wherever the speaker paused between segments
+    Pauses,
+    /// Break lines before this column
+    Columns(usize),
+}
+
+impl FromStr for TextWrap {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        if s.eq_ignore_ascii_case("pauses") {
+            return Ok(Self::Pauses);
+        }
+        
+        match s.parse::<usize>() {
+            Ok(columns) if columns > 0 => Ok(Self::Columns(columns)),
+            _ => Err(format!("expected 'pauses' or a line width in columns, got '{}'", s)),
+        }
+    }
+}
+
+/// HTTP status codes to retry, from --retry-status-codes or RETRY_STATUS_CODES in the settings file
+/// 
+/// Accepts `429,503,529` as well as the list form `[429, 503, 529]`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct RetryStatusCodes(pub Vec<u16>);
+
+impl FromStr for RetryStatusCodes {
+    type Err = String;
+    
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        let list = s.trim();
+        let list = list.strip_prefix('[').and_then(|list| list.strip_suffix(']')).unwrap_or(list);
+        
+        let codes = list.split(',')
+            .map(str::trim)
+            .filter(|code| !code.is_empty())
+            .map(|code| match code.parse::<u16>() {
+                Ok(status) if (100..=599).contains(&status) => Ok(status),
+                _ => Err(format!("'{}' is not an HTTP status code (100-599)", code)),
+            })
+            .collect::<Result<Vec<_>, _>>()?;
+        
+        if codes.is_empty() {
+            return Err("expected a list of HTTP status codes like 429,503".to_string());
+        }
+        Ok(Self(codes))
+    }
+}
+
+impl std::fmt::Display for RetryStatusCodes {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        let codes: Vec<String> = self.0.iter().map(u16::to_string).collect();
+        f.write_str(&codes.join(","))
+    }
+}
+
+/// Parse a sampling temperature, which Whisper accepts from 0 to 1
+pub fn parse_temperature(value: &str) -> Result<f32, String> {
+    let temperature: f32 = value.trim().parse()
+        .map_err(|_| format!("expected a number from 0 to 1, got '{}'", value))?;
+    
+    if !(0.0..=1.0).contains(&temperature) {
+        return Err(format!("temperature must be from 0 to 1, got {}", temperature));
+    }
+    
+    Ok(temperature)
 }
 
 /// Configuration for the media transcriber
+#[derive(Clone)]
 pub struct Config {
-    /// OpenAI API key
+    /// API key (empty for providers that don't need one)
     pub api_key: String,
+    /// Transcription backend
+    pub provider: Provider,
+    /// Base URL of the provider's OpenAI-compatible API
+    pub api_base: String,
+    /// Model sent with each request
+    pub model: String,
+    /// Rules for picking each file's model instead (--auto-model)
+    pub model_policy: Option<ModelPolicy>,
     /// Language code (e.g., 'en' for English)
     pub language: Option<String>,
     /// Context to improve transcription accuracy
@@ -25,6 +594,124 @@ pub struct Config {
     pub limit: Option<usize>,
     /// Output directory for transcripts
     pub output_dir: PathBuf,
+    /// Also write every format as numbered parts of about this many words or this much audio
+    pub split_output_every: Option<SplitEvery>,
+    /// Also write each segment to its own file in <transcript>.segments/
+    pub split_segments: bool,
+    /// Paragraph or line-wrap plain-text transcripts
+    pub wrap: Option<TextWrap>,
+    /// Append a timestamped segment listing to text transcripts
+    pub include_segments: bool,
+    /// Longest SRT/VTT line, in characters; longer cue text is wrapped and split
+    pub max_line_length: Option<usize>,
+    /// Longest SRT/VTT cue, in seconds
+    pub max_cue_duration: Option<f64>,
+    /// Extra files with their own format options (--output-spec)
+    pub output_specs: Vec<OutputSpec>,
+    /// Waveform peaks per second of audio to add to JSON transcripts (--include-peaks)
+    pub peaks_per_second: Option<u32>,
+    /// Pace provider requests, slowing down on 429s and speeding back up (--adaptive-rate)
+    pub adaptive_rate: Option<utils::RateLimits>,
+    /// How transient network failures are retried
+    pub retry: RetryPolicy,
+    /// File whose contents are written before each transcript
+    pub prepend_file: Option<PathBuf>,
+    /// File whose contents are written after each transcript
+    pub append_file: Option<PathBuf>,
+    /// Decode audio files fully before transcribing to catch corrupt input
+    pub verify_integrity: bool,
+    /// Audio streams to transcribe from multi-track files
+    pub audio_stream: Option<AudioStreamSelection>,
+    /// Print the equivalent curl command for each transcription request
+    pub print_command: bool,
+    /// Replace names, emails, phone, card and social security numbers with tags, in captions too
+    pub redact_pii: bool,
+    /// Shortest final chunk (in seconds) kept separate when splitting large files
+    pub min_chunk_duration: u64,
+    /// Let each chunk of a large file detect its own language
+    pub detect_language_per_chunk: bool,
+    /// Detect the language on a sample, then transcribe with it pinned
+    pub auto_language: bool,
+    /// Print each chunk's text to stdout (and append it to the transcript) as soon as it's done
+    pub stream: bool,
+    /// Label each speaker's turns in the transcript (diarizing providers only)
+    pub diarize: bool,
+    /// Time between checks on a queued AssemblyAI transcript
+    pub poll_interval: Duration,
+    /// Where temporary files (downloads, chunks, captures) are created instead of the OS temp dir
+    pub temp_dir: Option<PathBuf>,
+    /// Providers to race for each request; empty to use only `provider`
+    pub fanout: Vec<Provider>,
+    /// Largest text file to write (transcript, part or --output-spec txt), in bytes; longer ones are cut at a sentence boundary
+    pub max_output_bytes: Option<usize>,
+    /// Filler words and phrases to remove from transcripts, if enabled
+    pub trim_fillers: Option<Vec<String>>,
+    /// Filename to send with uploads instead of the (possibly temporary) file's name
+    pub api_filename: Option<String>,
+    /// Prompt each chunk of a large file with the end of the previous chunk's transcript
+    pub carry_context: bool,
+    /// Chunks of a large file transcribed at the same time
+    pub chunk_concurrency: usize,
+    /// Only transcribe podcast episodes published on or after this date
+    pub since: Option<NaiveDate>,
+    /// Skip podcast episodes and batch files that already have a transcript
+    pub skip_existing: bool,
+    /// Directory to save each provider response body in, unparsed
+    pub save_raw_response: Option<PathBuf>,
+    /// Treat an empty transcript as an error instead of a warning
+    pub fail_on_empty: bool,
+    /// Parse the SRT, VTT and JSON files back after writing them, failing the source if one is malformed
+    pub validate_output: bool,
+    /// Shell command that transcripts are piped through, replaced by its output
+    pub postprocess_command: Option<String>,
+    /// Shell command run on each finished transcript
+    pub post_hook: Option<String>,
+    /// Shell command run when a transcription fails
+    pub post_hook_on_error: Option<String>,
+    /// Files larger than this many MB are split into chunks
+    pub chunk_size_mb: u64,
+    /// Seconds each chunk overlaps the previous one, de-duplicated when joining
+    pub chunk_overlap: u64,
+    /// Also write a JSON file of segment or word timings next to each transcript
+    pub timestamps: Option<TimestampGranularity>,
+    /// Exact timing levels to request with verbose_json; empty for what `timestamps` implies
+    pub granularities: Vec<TimestampGranularity>,
+    /// Show upload progress and a spinner on stderr during transcription requests
+    pub progress: bool,
+    /// Print nothing but errors (no summaries or status lines)
+    pub quiet: bool,
+    /// Format (file extension) of audio read from standard input
+    pub input_format: Option<String>,
+    /// Largest audio file downloaded from a direct URL, in bytes
+    pub max_download_bytes: u64,
+    /// Largest stdin, named-pipe or URL input held in memory instead of a temp file (--no-temp-disk)
+    pub memory_limit: Option<u64>,
+    /// Formats each transcript is written in, all derived from one API response
+    pub output_formats: Vec<OutputFormat>,
+    /// Send files whose extension or contents don't look like supported audio anyway
+    pub skip_format_check: bool,
+    /// Translate the audio into English instead of transcribing it
+    pub translate: bool,
+    /// Reuse stored results for audio already transcribed with the same parameters
+    pub use_cache: bool,
+    /// ggml model for running whisper.cpp as a program; None to use a whisper.cpp server
+    pub whisper_model: Option<PathBuf>,
+    /// whisper.cpp program run with `whisper_model`
+    pub whisper_binary: Option<PathBuf>,
+    /// Convert audio to this format (16 kHz mono) before uploading
+    pub transcode: Option<TranscodeFormat>,
+    /// Only report the language detected in this many opening seconds of each file
+    pub detect_language_only: Option<u64>,
+    /// Deadline for each transcription request; chunks of a large file each get their own
+    pub timeout: Duration,
+    /// Limits on waiting for and reading each provider response
+    pub http_timeouts: utils::HttpTimeouts,
+    /// Refuse to overwrite existing transcripts
+    pub no_clobber: bool,
+    /// Sampling temperature; 0 is the most deterministic
+    pub temperature: f32,
+    /// Fixed time (Unix seconds) to stamp outputs with instead of now; $SOURCE_DATE_EPOCH by default
+    pub output_epoch: Option<i64>,
 }
 
 impl Config {
@@ -35,30 +722,376 @@ impl Config {
         prompt: Option<String>,
         limit: Option<usize>,
         output_dir: &Path,
+        provider: Provider,
+        api_base: Option<String>,
     ) -> Result<Self> {
-        // Try to load API key from various sources
-        let api_key = api_key
-            .or_else(|| env::var("OPENAI_API_KEY").ok())
-            .or_else(|| load_api_key_from_env_file())
-            .context("Failed to load API key")?;
+        let api_base = resolve_api_base(provider, api_base);
         
-        // Validate API key
-        // Check for either the standard OpenAI key format (sk-...) or the project-based format (sk-proj-...)
-        if !api_key.starts_with("sk-") {
-            return Err(ConfigError::ApiKeyNotFound.into());
+        if is_azure_endpoint(&api_base) && !api_base.contains("api-version=") {
+            warn!("Azure OpenAI endpoints usually need an api-version, e.g. --api-base \"{}?api-version=2024-06-01\"", api_base);
         }
         
-        // Create output directory if it doesn't exist
-        fs::create_dir_all(output_dir)?;
+        // Try to load API key from various sources
+        let api_key = if provider.requires_api_key() {
+            let api_key = resolve_api_key(provider, api_key)
+                .ok_or(ConfigError::ApiKeyNotFound(provider.api_key_env()))?;
+            let api_key = resolve_secret_reference(api_key)?;
+            
+            // Validate API key
+            // Check for either the standard OpenAI key format (sk-...) or the project-based format (sk-proj-...)
+            // Azure keys are plain hex strings, and other providers have their own formats
+            if provider == Provider::Openai && !api_key.starts_with("sk-") && !is_azure_endpoint(&api_base) {
+                return Err(ConfigError::ApiKeyNotFound(provider.api_key_env()).into());
+            }
+            
+            api_key
+        } else {
+            api_key.map(resolve_secret_reference).transpose()?.unwrap_or_default()
+        };
+        
+        // Create output directory if it doesn't exist, and fail now if it can't be written
+        utils::ensure_writable_dir(output_dir, "Output directory")?;
         
         Ok(Self {
             api_key,
+            provider,
+            api_base,
+            model: provider.default_model().to_string(),
+            model_policy: None,
             language,
             prompt,
             limit,
             output_dir: output_dir.to_path_buf(),
+            split_output_every: None,
+            split_segments: false,
+            wrap: None,
+            include_segments: false,
+            max_line_length: None,
+            max_cue_duration: None,
+            output_specs: Vec::new(),
+            peaks_per_second: None,
+            adaptive_rate: None,
+            retry: RetryPolicy::default(),
+            prepend_file: None,
+            append_file: None,
+            verify_integrity: false,
+            audio_stream: None,
+            print_command: false,
+            redact_pii: false,
+            min_chunk_duration: 10,
+            detect_language_per_chunk: false,
+            auto_language: false,
+            stream: false,
+            diarize: false,
+            poll_interval: Duration::from_secs(crate::assemblyai::DEFAULT_POLL_INTERVAL_SECS),
+            temp_dir: None,
+            fanout: Vec::new(),
+            max_output_bytes: None,
+            trim_fillers: None,
+            api_filename: None,
+            carry_context: false,
+            chunk_concurrency: DEFAULT_CHUNK_CONCURRENCY,
+            since: None,
+            skip_existing: false,
+            save_raw_response: None,
+            fail_on_empty: false,
+            validate_output: false,
+            postprocess_command: None,
+            post_hook: None,
+            post_hook_on_error: None,
+            chunk_size_mb: 24,
+            chunk_overlap: 2,
+            timestamps: None,
+            granularities: Vec::new(),
+            progress: false,
+            quiet: false,
+            input_format: None,
+            max_download_bytes: 500 * 1024 * 1024,
+            memory_limit: None,
+            output_formats: vec![OutputFormat::Text],
+            skip_format_check: false,
+            translate: false,
+            use_cache: true,
+            whisper_model: None,
+            whisper_binary: None,
+            transcode: None,
+            detect_language_only: None,
+            timeout: Duration::from_secs(utils::DEFAULT_REQUEST_TIMEOUT_SECS),
+            http_timeouts: utils::HttpTimeouts::default(),
+            no_clobber: false,
+            temperature: 0.0,
+            output_epoch: utils::source_date_epoch(),
         })
     }
+    
+    /// Whether transcription goes through the podscript binary rather than straight to an HTTP API
+    /// 
+    /// podscript only transcribes with whisper-1 at temperature 0, talks to api.openai.com and
+    /// returns text, so any option that needs another endpoint or the full response bypasses it.
+    /// Its requests can't be paced either, so --adaptive-rate bypasses it too.
+    pub fn uses_podscript(&self) -> bool {
+        self.provider == Provider::Openai
+            && self.fanout.is_empty()
+            && self.api_base == Provider::Openai.default_api_base()
+            && self.model == Provider::Openai.default_model()
+            && self.temperature == 0.0
+            && !self.needs_segments()
+            && !self.translate
+            && self.adaptive_rate.is_none()
+    }
+    
+    /// The option that needs the audio as a file on disk, if any
+    /// 
+    /// ffmpeg, podscript, whisper.cpp and the AssemblyAI upload all read a file,
+    /// so with any of these --no-temp-disk still writes the input to disk.
+    pub fn needs_audio_file(&self) -> Option<&'static str> {
+        if self.whisper_model.is_some() {
+            Some("--whisper-model")
+        } else if self.provider == Provider::Assemblyai || self.fanout.contains(&Provider::Assemblyai) {
+            Some("--provider assemblyai")
+        } else if self.uses_podscript() {
+            Some("podscript (the default OpenAI settings)")
+        } else if self.transcode.is_some() {
+            Some("--transcode")
+        } else if self.verify_integrity {
+            Some("--verify-integrity")
+        } else if self.audio_stream.is_some() {
+            Some("--audio-stream")
+        } else if self.auto_language {
+            Some("--auto-language")
+        } else if self.model_policy.is_some() {
+            Some("--auto-model")
+        } else if self.detect_language_only.is_some() {
+            Some("--detect-language-only")
+        } else if self.peaks_per_second.is_some() {
+            Some("--include-peaks")
+        } else if self.print_command {
+            Some("--print-command")
+        } else {
+            None
+        }
+    }
+    
+    /// How much of a piped or downloaded input to hold in memory, if it may skip the disk at all
+    /// 
+    /// Inputs too large for one request are split with ffmpeg, so the limit
+    /// never goes past --chunk-size.
+    pub fn memory_spool_limit(&self) -> Option<u64> {
+        if self.needs_audio_file().is_some() {
+            return None;
+        }
+        self.memory_limit.map(|limit| limit.min(self.chunk_size_mb * 1024 * 1024))
+    }
+    
+    /// Fail early on options the model can't serve, which the API would reject with a 400
+    pub fn check_model_capabilities(&self) -> Result<()> {
+        let capabilities = model_capabilities(&self.model);
+        let unsupported = |option: &str| anyhow::anyhow!(
+            "{} doesn't support {}; use --model whisper-1 or drop the option",
+            self.model, option
+        );
+        
+        if self.diarize && !self.provider.supports_diarization() {
+            return Err(anyhow::anyhow!(
+                "{} can't tell speakers apart; --diarize needs --provider assemblyai",
+                self.provider.label()
+            ));
+        }
+        
+        if !capabilities.translate && self.translate {
+            return Err(unsupported("--translate"));
+        }
+        
+        if !capabilities.verbose_json {
+            if self.output_formats.iter().any(|format| *format != OutputFormat::Text) {
+                return Err(unsupported("--response-format srt, vtt or json (it only returns text)"));
+            }
+            if self.timestamps.is_some() || !self.granularities.is_empty() || self.split_segments || self.include_segments || self.wrap == Some(TextWrap::Pauses) {
+                return Err(unsupported("segment timings (--timestamps, --granularity, --split-segments, --include-segments, --wrap pauses)"));
+            }
+            if self.detect_language_only.is_some() || self.detect_language_per_chunk || (self.auto_language && self.language.is_none()) {
+                return Err(unsupported("language detection"));
+            }
+        }
+        
+        Ok(())
+    }
+    
+    /// Timing levels asked for, finest first: --granularity's, or what --timestamps needs
+    pub fn requested_granularities(&self) -> Vec<&'static str> {
+        if self.granularities.is_empty() {
+            return self.timestamps.map_or_else(Vec::new, |granularity| granularity.api_values().to_vec());
+        }
+        
+        [TimestampGranularity::Word, TimestampGranularity::Segment]
+            .into_iter()
+            .filter(|granularity| self.granularities.contains(granularity))
+            .map(|granularity| granularity.api_value())
+            .collect()
+    }
+    
+    /// Values sent as `timestamp_granularities[]`; translations only return segments, so they send none
+    pub fn timestamp_granularities(&self) -> Vec<&'static str> {
+        if self.translate {
+            return Vec::new();
+        }
+        self.requested_granularities()
+    }
+    
+    /// Whether word timings are asked for, which translations, whisper.cpp and --redact-pii can't provide
+    pub fn wants_word_timings(&self) -> bool {
+        self.requested_granularities().contains(&"word")
+    }
+    
+    /// Whether transcripts need segment timings (verbose_json) rather than just text
+    pub fn needs_segments(&self) -> bool {
+        self.timestamps.is_some()
+            || self.split_segments
+            || self.include_segments
+            || self.wrap == Some(TextWrap::Pauses)
+            || matches!(self.split_output_every, Some(SplitEvery::Duration(_)))
+            || self.output_formats.iter().any(|format| *format != OutputFormat::Text)
+            || !self.output_specs.is_empty()
+    }
+}
+
+/// Chunks of a large file transcribed at once by default, low enough to stay clear of rate limits
+pub const DEFAULT_CHUNK_CONCURRENCY: usize = 3;
+
+/// Settings file written by `configure`, the first place API keys are looked for
+pub const ENV_FILE: &str = ".env";
+
+/// Settings-file variable holding the retryable status codes (read by --retry-status-codes)
+pub const RETRY_STATUS_CODES_VAR: &str = "RETRY_STATUS_CODES";
+
+/// Set `KEY=value` lines in a .env file, keeping its other lines
+/// 
+/// Existing assignments of the keys (including `export KEY=...`) are
+/// replaced in place and new ones are appended. The file holds secrets, so
+/// it is made readable by the owner only.
+pub fn update_env_file(path: &Path, settings: &[(&str, &str)]) -> Result<()> {
+    let existing = match fs::read_to_string(path) {
+        Ok(content) => content,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => String::new(),
+        Err(e) => return Err(anyhow::anyhow!("Failed to read {:?}: {}", path, e)),
+    };
+    
+    let mut lines: Vec<String> = existing.lines().map(str::to_string).collect();
+    for (key, value) in settings {
+        let assignment = format!("{}={}", key, value);
+        let position = lines.iter().position(|line| {
+            let line = line.trim_start();
+            let line = line.strip_prefix("export ").unwrap_or(line).trim_start();
+            line.split_once('=').map_or(false, |(name, _)| name.trim() == *key)
+        });
+        
+        match position {
+            Some(index) => lines[index] = assignment,
+            None => lines.push(assignment),
+        }
+    }
+    
+    let mut options = fs::OpenOptions::new();
+    options.write(true).create(true).truncate(true);
+    #[cfg(unix)]
+    std::os::unix::fs::OpenOptionsExt::mode(&mut options, 0o600);
+    
+    let mut file = options.open(path)
+        .map_err(|e| anyhow::anyhow!("Failed to write {:?}: {}", path, e))?;
+    std::io::Write::write_all(&mut file, format!("{}\n", lines.join("\n")).as_bytes())?;
+    
+    // The mode above only applies to new files
+    #[cfg(unix)]
+    fs::set_permissions(path, std::os::unix::fs::PermissionsExt::from_mode(0o600))?;
+    
+    Ok(())
+}
+
+/// Base URL for a provider: the given one, OPENAI_API_BASE for OpenAI, or the provider's default
+pub fn resolve_api_base(provider: Provider, api_base: Option<String>) -> String {
+    api_base
+        .or_else(|| load_api_base(provider))
+        .unwrap_or_else(|| provider.default_api_base().to_string())
+        .trim_end_matches('/')
+        .to_string()
+}
+
+/// Load the OpenAI base URL from OPENAI_API_BASE in the environment or a .env file
+fn load_api_base(provider: Provider) -> Option<String> {
+    // Only OpenAI is commonly routed through a proxy or Azure
+    if provider != Provider::Openai {
+        return None;
+    }
+    
+    dotenv().ok();
+    env::var("OPENAI_API_BASE").ok().filter(|base| !base.trim().is_empty())
+}
+
+/// Whether a base URL points at Azure OpenAI, which authenticates with an `api-key` header
+pub fn is_azure_endpoint(api_base: &str) -> bool {
+    let host = api_base.split("://").nth(1).unwrap_or(api_base);
+    let host = host.split(['/', '?']).next().unwrap_or("").to_lowercase();
+    host.ends_with(".openai.azure.com") || host.ends_with(".azure-api.net")
+}
+
+/// Resolve the API key from the command line, environment or a .env file
+pub fn resolve_api_key(provider: Provider, api_key: Option<String>) -> Option<String> {
+    // The .env search below only knows OpenAI keys; other providers' keys come from the environment (or .env via dotenv)
+    if provider != Provider::Openai {
+        dotenv().ok();
+        return api_key.or_else(|| env::var(provider.api_key_env()).ok().filter(|key| !key.trim().is_empty()));
+    }
+    
+    api_key
+        .or_else(|| env::var("OPENAI_API_KEY").ok())
+        .or_else(|| load_api_key_from_env_file())
+}
+
+/// Read an API key from a file such as a mounted secret, ignoring surrounding whitespace
+pub fn read_api_key_file(path: &Path) -> Result<String> {
+    let key = fs::read_to_string(path)
+        .map_err(|e| anyhow::anyhow!("Failed to read API key file {:?}: {}", path, e))?
+        .trim()
+        .to_string();
+    
+    if key.is_empty() {
+        return Err(anyhow::anyhow!("API key file {:?} is empty", path));
+    }
+    
+    Ok(key)
+}
+
+/// Fetch the secret an `op://` (1Password) or `vault://path#field` (HashiCorp Vault) reference points to
+/// 
+/// Other values are returned unchanged. The secret itself is never logged.
+pub fn resolve_secret_reference(value: String) -> Result<String> {
+    let (cli, args) = if value.starts_with("op://") {
+        ("op", vec!["read".to_string(), "--no-newline".to_string(), value.clone()])
+    } else if let Some(reference) = value.strip_prefix("vault://") {
+        let (path, field) = reference.split_once('#').ok_or_else(|| {
+            anyhow::anyhow!("Vault reference {:?} needs a field, e.g. vault://secret/openai#api_key", value)
+        })?;
+        ("vault", vec!["kv".to_string(), "get".to_string(), format!("-field={}", field), path.to_string()])
+    } else {
+        return Ok(value);
+    };
+    
+    debug!("Fetching API key from {}", value);
+    if !utils::check_command(cli) {
+        return Err(anyhow::anyhow!("The API key is a {} reference, but the {} CLI isn't installed", cli, cli));
+    }
+    
+    let args: Vec<&str> = args.iter().map(String::as_str).collect();
+    let secret = utils::run_command(cli, &args)
+        .map_err(|e| anyhow::anyhow!("Failed to fetch the API key from {}: {}", value, e))?
+        .trim()
+        .to_string();
+    
+    if secret.is_empty() {
+        return Err(anyhow::anyhow!("{} returned an empty secret for {}", cli, value));
+    }
+    
+    Ok(secret)
 }
 
 /// Load API key from .env file
@@ -128,3 +1161,125 @@ fn load_api_key_from_env_file() -> Option<String> {
     
     None
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    
+    #[test]
+    fn retry_status_codes_accept_both_list_forms() {
+        assert_eq!("429,503".parse(), Ok(RetryStatusCodes(vec![429, 503])));
+        assert_eq!("[429, 500, 529]".parse(), Ok(RetryStatusCodes(vec![429, 500, 529])));
+        assert_eq!(RetryStatusCodes(vec![429, 503]).to_string(), "429,503");
+    }
+    
+    #[test]
+    fn split_every_takes_words_or_a_duration() {
+        assert_eq!("2000w".parse(), Ok(SplitEvery::Words(2000)));
+        assert_eq!("2000".parse(), Ok(SplitEvery::Words(2000)));
+        assert_eq!("10m".parse(), Ok(SplitEvery::Duration(Duration::from_secs(600))));
+        assert_eq!("1h30m".parse(), Ok(SplitEvery::Duration(Duration::from_secs(5400))));
+        assert!("0w".parse::<SplitEvery>().is_err());
+        assert!("ten".parse::<SplitEvery>().is_err());
+    }
+    
+    #[test]
+    fn retry_status_codes_reject_non_statuses() {
+        assert!("99".parse::<RetryStatusCodes>().is_err());
+        assert!("429,abc".parse::<RetryStatusCodes>().is_err());
+        assert!("[]".parse::<RetryStatusCodes>().is_err());
+    }
+    
+    #[test]
+    fn granularity_overrides_what_timestamps_requests() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut config = Config::new(None, None, None, None, dir.path(), Provider::Local, None).unwrap();
+        assert!(config.timestamp_granularities().is_empty());
+        
+        config.timestamps = Some(TimestampGranularity::Word);
+        assert_eq!(config.timestamp_granularities(), ["word", "segment"]);
+        
+        config.timestamps = None;
+        config.granularities = vec![TimestampGranularity::Word];
+        assert_eq!(config.timestamp_granularities(), ["word"]);
+        
+        config.granularities = vec![TimestampGranularity::Segment, TimestampGranularity::Word, TimestampGranularity::Segment];
+        assert_eq!(config.timestamp_granularities(), ["word", "segment"]);
+        
+        // Translations send none, but still can't give the word timings asked for
+        config.translate = true;
+        assert!(config.timestamp_granularities().is_empty());
+        assert!(config.wants_word_timings());
+    }
+    
+    #[test]
+    fn model_policy_picks_the_first_matching_usable_rule() {
+        let policy: ModelPolicy = "
+            # comment lines and trailing comments are skipped
+            fast   duration<10m language=en   # short English clips
+            multi  language!=en
+            long
+        ".parse().unwrap();
+        let pick = |duration, language, usable: &dyn Fn(&str) -> bool| {
+            policy.choose(duration, language, usable).map(|rule| rule.model.as_str())
+        };
+        let any = |_: &str| true;
+        
+        assert_eq!(pick(Some(120.0), Some("en"), &any), Some("fast"));
+        assert_eq!(pick(Some(600.0), Some("en"), &any), Some("long"));
+        assert_eq!(pick(Some(120.0), Some("de"), &any), Some("multi"));
+        assert_eq!(policy.0[0].text, "fast   duration<10m language=en");
+        
+        // Unknown lengths and languages meet no condition on them
+        assert_eq!(pick(None, None, &any), Some("long"));
+        
+        // A model that can't serve the options is passed over
+        assert_eq!(pick(Some(120.0), Some("en"), &|model: &str| model != "fast"), Some("long"));
+        assert_eq!(pick(Some(120.0), Some("en"), &|_: &str| false), None);
+    }
+    
+    #[test]
+    fn model_policy_rejects_bad_rules() {
+        assert!("fast duration<ten".parse::<ModelPolicy>().unwrap_err().starts_with("line 1:"));
+        assert!("fast\nslow speed>1".parse::<ModelPolicy>().unwrap_err().contains("unknown condition 'speed>1'"));
+        assert!("# only a comment".parse::<ModelPolicy>().is_err());
+    }
+    
+    #[test]
+    fn builtin_model_policies_name_the_providers_models() {
+        for provider in [Provider::Openai, Provider::Groq, Provider::Assemblyai] {
+            let policy = ModelPolicy::builtin(provider).unwrap();
+            assert!(policy.0.iter().all(|rule| provider.validate_model(&rule.model).is_ok()), "{:?}", provider);
+            assert!(policy.0.last().unwrap().conditions.is_empty(), "{:?}", provider);
+        }
+        assert!(ModelPolicy::builtin(Provider::Local).is_none());
+    }
+    
+    #[test]
+    fn parses_output_specs_with_their_options() {
+        let spec: OutputSpec = "srt:{name}.short.srt:maxcue=5s,maxline=32".parse().unwrap();
+        assert_eq!(spec.format, OutputFormat::Srt);
+        assert_eq!(spec.max_cue_duration, Some(5.0));
+        assert_eq!(spec.max_line_length, Some(32));
+        assert_eq!(spec.path_for(Path::new("out/episode.txt")), Path::new("out/episode.short.srt"));
+        
+        let spec: OutputSpec = "txt:/srv/read.txt:paragraphs".parse().unwrap();
+        assert_eq!((spec.format, spec.paragraphs), (OutputFormat::Text, true));
+        assert_eq!(spec.path_for(Path::new("out/episode.txt")), Path::new("/srv/read.txt"));
+        
+        assert!("podscript-json:{name}.full.json".parse::<OutputSpec>().is_ok());
+        
+        let errors = [
+            ("docx:out.docx", "unknown format 'docx'"),
+            ("srt", "'srt' has no file name"),
+            ("srt:out.srt:paragraphs", "'paragraphs' isn't an option for srt output"),
+            ("txt:out.txt:maxcue=5s", "'maxcue=5s' isn't an option for txt output"),
+            ("vtt:out.vtt:maxline=4", "maxline must be at least 10 characters"),
+            ("vtt:out.vtt:maxcue=soon", "expected a duration"),
+        ];
+        for (spec, expected) in errors {
+            let error = spec.parse::<OutputSpec>().unwrap_err();
+            assert!(error.starts_with(expected), "{}: {}", spec, error);
+        }
+    }
+}
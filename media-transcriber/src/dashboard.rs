@@ -0,0 +1,90 @@
+use indicatif::{MultiProgress, ProgressBar, ProgressStyle};
+use std::time::{Duration, Instant};
+
+/// Terminal dashboard showing the status of each source in a batch
+pub struct Dashboard {
+    /// Summary line with overall progress and throughput
+    summary: ProgressBar,
+    /// One line per source
+    bars: Vec<ProgressBar>,
+    /// When the batch started
+    started: Instant,
+    /// Number of finished sources (done or failed)
+    finished: usize,
+    /// Number of failed sources
+    failed: usize,
+    /// Keeps the bars drawn for the lifetime of the dashboard
+    _multi: MultiProgress,
+}
+
+impl Dashboard {
+    /// Create a dashboard with every source queued
+    pub fn new(sources: &[&str]) -> Self {
+        let multi = MultiProgress::new();
+        
+        let summary = multi.add(ProgressBar::new(sources.len() as u64));
+        summary.set_style(
+            ProgressStyle::with_template("{bar:30} {pos}/{len} sources  {msg}").unwrap(),
+        );
+        
+        let style = ProgressStyle::with_template("{prefix:>4} {spinner} {wide_msg}").unwrap();
+        let bars = sources
+            .iter()
+            .enumerate()
+            .map(|(i, source)| {
+                let bar = multi.add(ProgressBar::new_spinner());
+                bar.set_style(style.clone());
+                bar.set_prefix(format!("{}.", i + 1));
+                bar.set_message(format!("queued   {}", source));
+                bar
+            })
+            .collect();
+        
+        Self {
+            summary,
+            bars,
+            started: Instant::now(),
+            finished: 0,
+            failed: 0,
+            _multi: multi,
+        }
+    }
+    
+    /// Mark a source as running
+    pub fn start(&self, index: usize, source: &str) {
+        let bar = &self.bars[index];
+        bar.set_message(format!("running  {}", source));
+        bar.enable_steady_tick(Duration::from_millis(120));
+    }
+    
+    /// Mark a source as successfully processed
+    pub fn finish(&mut self, index: usize, source: &str, elapsed: Duration) {
+        self.bars[index].finish_with_message(format!("done     {} ({:.0?})", source, elapsed));
+        self.advance(false);
+    }
+    
+    /// Mark a source as failed
+    pub fn fail(&mut self, index: usize, source: &str, error: &anyhow::Error) {
+        self.bars[index].finish_with_message(format!("failed   {}: {}", source, error));
+        self.advance(true);
+    }
+    
+    /// Update the summary line after a source finishes
+    fn advance(&mut self, failed: bool) {
+        self.finished += 1;
+        if failed {
+            self.failed += 1;
+        }
+        
+        // Throughput in sources per minute since the batch started
+        let minutes = self.started.elapsed().as_secs_f64() / 60.0;
+        let throughput = if minutes > 0.0 { self.finished as f64 / minutes } else { 0.0 };
+        
+        self.summary.inc(1);
+        self.summary.set_message(format!("{} failed, {:.1} sources/min", self.failed, throughput));
+        
+        if self.finished == self.bars.len() {
+            self.summary.finish();
+        }
+    }
+}
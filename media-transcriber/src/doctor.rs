@@ -0,0 +1,137 @@
+use anyhow::Result;
+use colored::Colorize;
+use std::path::Path;
+use std::process::Command;
+
+use crate::config::{self, Provider};
+use crate::transcription::PODSCRIPT_BINARY;
+use crate::utils;
+
+/// Outcome of a single preflight check
+struct Check {
+    /// What was checked
+    name: &'static str,
+    /// Whether the check passed
+    passed: bool,
+    /// Whether a failure prevents transcription altogether
+    critical: bool,
+    /// Details (a version, or what to do about a failure)
+    detail: String,
+}
+
+/// Run preflight checks and print a checklist
+/// 
+/// Returns true if every critical check passed.
+pub async fn run(api_key: Option<String>) -> Result<bool> {
+    let mut checks = Vec::new();
+    
+    // Required binaries
+    checks.push(check_binary("ffmpeg", &["-version"], true, "Install it with 'brew install ffmpeg' or 'sudo apt-get install ffmpeg'"));
+    checks.push(check_binary("ffprobe", &["-version"], true, "ffprobe ships with ffmpeg; reinstall ffmpeg"));
+    checks.push(check_binary("yt-dlp", &["--version"], false, "Needed for YouTube sources: 'brew install yt-dlp' or https://github.com/yt-dlp/yt-dlp"));
+    checks.push(check_podscript());
+    
+    // API key and connectivity
+    let api_key = config::resolve_api_key(Provider::Openai, api_key)
+        .map(config::resolve_secret_reference)
+        .transpose();
+    match api_key {
+        Ok(Some(key)) if key.starts_with("sk-") => {
+            checks.push(Check {
+                name: "OpenAI API key",
+                passed: true,
+                critical: true,
+                detail: "found".to_string(),
+            });
+            checks.push(check_openai_auth(&key).await);
+        }
+        Ok(Some(_)) => checks.push(Check {
+            name: "OpenAI API key",
+            passed: false,
+            critical: true,
+            detail: "found, but it doesn't look like an OpenAI key (expected it to start with 'sk-')".to_string(),
+        }),
+        Ok(None) => checks.push(Check {
+            name: "OpenAI API key",
+            passed: false,
+            critical: true,
+            detail: "not found; set OPENAI_API_KEY, add it to a .env file or pass --api-key".to_string(),
+        }),
+        Err(e) => checks.push(Check {
+            name: "OpenAI API key",
+            passed: false,
+            critical: true,
+            detail: e.to_string(),
+        }),
+    }
+    
+    // Print the checklist
+    for check in &checks {
+        let status = match (check.passed, check.critical) {
+            (true, _) => "PASS".green().bold(),
+            (false, true) => "FAIL".red().bold(),
+            (false, false) => "WARN".yellow().bold(),
+        };
+        println!("[{}] {}: {}", status, check.name, check.detail);
+    }
+    
+    Ok(checks.iter().all(|check| check.passed || !check.critical))
+}
+
+/// Check that a binary is installed and report its version
+fn check_binary(name: &'static str, version_args: &[&str], critical: bool, hint: &str) -> Check {
+    match Command::new(name).args(version_args).output() {
+        Ok(output) if output.status.success() => {
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            Check {
+                name,
+                passed: true,
+                critical,
+                detail: stdout.lines().next().unwrap_or("installed").trim().to_string(),
+            }
+        }
+        _ => Check {
+            name,
+            passed: false,
+            critical,
+            detail: format!("not found. {}", hint),
+        },
+    }
+}
+
+/// Check that the podscript binary used for transcription is present
+fn check_podscript() -> Check {
+    let passed = Path::new(PODSCRIPT_BINARY).is_file();
+    
+    Check {
+        name: "podscript",
+        passed,
+        critical: true,
+        detail: if passed {
+            format!("found at {}", PODSCRIPT_BINARY)
+        } else {
+            format!("not found at {}; build podscript in the parent directory", PODSCRIPT_BINARY)
+        },
+    }
+}
+
+/// Check that the OpenAI API is reachable and accepts the key
+async fn check_openai_auth(api_key: &str) -> Check {
+    let name = "OpenAI API";
+    let response = utils::http_client()
+        .get("https://api.openai.com/v1/models")
+        .bearer_auth(api_key)
+        .send()
+        .await;
+    
+    let (passed, detail) = match response {
+        Ok(response) if response.status().is_success() => (true, "reachable, key accepted".to_string()),
+        Ok(response) if response.status().as_u16() == 401 => {
+            (false, "reachable, but the API key was rejected; check it at https://platform.openai.com/api-keys".to_string())
+        }
+        Ok(response) => (false, format!("reachable, but returned HTTP {}", response.status())),
+        Err(e) => (false, format!("unreachable ({}); check your network or proxy settings", e)),
+    };
+    
+    Check { name, passed, critical: true, detail }
+}
@@ -0,0 +1,72 @@
+use anyhow::Result;
+use log::warn;
+use std::path::{Path, PathBuf};
+
+use crate::local_file::{LocalFileProcessor, STDIN_PATH};
+use crate::utils;
+
+/// Whisper API price in US dollars per minute of audio, used unless overridden
+pub const DEFAULT_PRICE_PER_MINUTE: f64 = 0.006;
+
+/// Print the audio duration and estimated transcription cost of local files, without calling any API
+/// 
+/// Durations come from ffprobe. Files that can't be probed are listed and
+/// left out of the total.
+pub async fn run(files: &[PathBuf], price_per_minute: f64) -> Result<()> {
+    if files.is_empty() {
+        return Err(anyhow::anyhow!("Nothing to estimate: --dry-run needs local audio files"));
+    }
+    
+    let mut total_minutes = 0.0;
+    let mut unknown = 0;
+    
+    for file in files {
+        match utils::get_audio_duration(file).await {
+            Ok(seconds) => {
+                // The API bills per started second, so round up
+                let minutes = seconds.ceil() / 60.0;
+                total_minutes += minutes;
+                println!("{}: {} (${:.2})", file.display(), format_minutes(minutes), minutes * price_per_minute);
+            }
+            Err(e) => {
+                unknown += 1;
+                println!("{}: duration unknown ({})", file.display(), e);
+            }
+        }
+    }
+    
+    println!();
+    println!(
+        "{} files, {} of audio, estimated cost ${:.2} at ${} per minute",
+        files.len() - unknown,
+        format_minutes(total_minutes),
+        total_minutes * price_per_minute,
+        price_per_minute
+    );
+    if unknown > 0 {
+        println!("{} files couldn't be measured and aren't included", unknown);
+    }
+    
+    Ok(())
+}
+
+/// Local audio files named by a --source or --file list, warning about sources that can't be measured offline
+pub fn local_sources<'s>(sources: impl IntoIterator<Item = &'s str>) -> Vec<PathBuf> {
+    let mut files = Vec::new();
+    
+    for source in sources {
+        if source != STDIN_PATH && LocalFileProcessor::is_local_file_path(source) {
+            files.push(Path::new(source).to_path_buf());
+        } else {
+            warn!("Skipping {} in --dry-run: only local files can be measured without downloading", source);
+        }
+    }
+    
+    files
+}
+
+/// Format a duration in minutes as e.g. "1h 02m 05s"
+fn format_minutes(minutes: f64) -> String {
+    let seconds = (minutes * 60.0).round() as u64;
+    format!("{}h {:02}m {:02}s", seconds / 3600, seconds / 60 % 60, seconds % 60)
+}
@@ -0,0 +1,95 @@
+use anyhow::Result;
+use log::info;
+use serde::Serialize;
+use std::path::{Path, PathBuf};
+
+use crate::captions;
+use crate::utils;
+
+/// Format of a word-level search index
+#[derive(Debug, Clone, Copy, PartialEq, Eq, clap::ValueEnum)]
+pub enum IndexFormat {
+    /// NIST CTM: `<file> <channel> <start> <duration> <word>` per line
+    Ctm,
+    /// Compact JSON: `{"source": ..., "words": [{"w", "s", "e"}, ...]}`
+    Json,
+}
+
+impl IndexFormat {
+    /// File extension for the format
+    fn extension(&self) -> &'static str {
+        match self {
+            IndexFormat::Ctm => "ctm",
+            IndexFormat::Json => "index.json",
+        }
+    }
+}
+
+/// A time-coded token in the compact JSON index
+#[derive(Serialize)]
+struct IndexedWord<'a> {
+    /// Word text, without surrounding whitespace
+    w: &'a str,
+    /// Start time in seconds (millisecond precision)
+    s: f64,
+    /// End time in seconds (millisecond precision)
+    e: f64,
+}
+
+/// Compact JSON index document
+#[derive(Serialize)]
+struct Index<'a> {
+    /// Transcript the words came from
+    source: &'a str,
+    /// Words in order of appearance
+    words: Vec<IndexedWord<'a>>,
+}
+
+/// Build a word-level index from a verbose_json transcript with word timestamps
+/// 
+/// The index is written next to the input as `<name>.ctm` or
+/// `<name>.index.json` unless an output path is given.
+pub fn run(input: &Path, format: IndexFormat, output: Option<PathBuf>) -> Result<PathBuf> {
+    let transcript = captions::parse_verbose_json(&captions::read_caption_file(input, None)?)?;
+    if transcript.words.is_empty() {
+        return Err(anyhow::anyhow!(
+            "{:?} has no word timestamps; it must be a verbose_json transcript requested with word granularity",
+            input
+        ));
+    }
+    
+    let source = input.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+    let words = transcript.words.iter().filter(|word| !word.word.trim().is_empty());
+    
+    let rendered = match format {
+        IndexFormat::Ctm => {
+            // CTM fields are whitespace-separated, so the file id can't contain spaces
+            let file_id = source.replace(char::is_whitespace, "_");
+            words
+                .map(|word| format!(
+                    "{} 1 {:.3} {:.3} {}\n",
+                    file_id,
+                    word.start,
+                    (word.end - word.start).max(0.0),
+                    word.word.trim()
+                ))
+                .collect::<String>()
+        }
+        IndexFormat::Json => serde_json::to_string(&Index {
+            source,
+            words: words
+                .map(|word| IndexedWord {
+                    w: word.word.trim(),
+                    s: (word.start * 1000.0).round() / 1000.0,
+                    e: (word.end * 1000.0).round() / 1000.0,
+                })
+                .collect(),
+        })?,
+    };
+    
+    let output = output.unwrap_or_else(|| input.with_file_name(format!("{}.{}", source, format.extension())));
+    utils::write_atomic(&output, rendered)?;
+    
+    info!("Wrote a {} index of {} words to {:?}", format.extension(), transcript.words.len(), output);
+    Ok(output)
+}
@@ -0,0 +1,132 @@
+use anyhow::Result;
+use log::{info, warn};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::config::Config;
+use crate::utils;
+
+/// Version of the job state format; bumped when a field changes meaning or is removed
+const JOB_STATE_VERSION: u32 = 1;
+
+/// Progress of a --batch run, saved with --resume so an interrupted run can pick up where it stopped
+#[derive(Debug, Serialize, Deserialize)]
+pub struct JobState {
+    /// Format version of the state file
+    pub version: u32,
+    /// Settings the transcripts were made with, to warn when a resume changes them
+    pub parameters: BTreeMap<String, String>,
+    /// Status of each file, by path
+    pub files: BTreeMap<String, FileState>,
+    /// Where the state is saved
+    #[serde(skip)]
+    path: PathBuf,
+}
+
+/// Where a single file of the job stands
+#[derive(Debug, Serialize, Deserialize)]
+pub struct FileState {
+    /// "pending", "succeeded" or "failed"
+    pub status: String,
+    /// Transcript files written
+    #[serde(default)]
+    pub outputs: Vec<PathBuf>,
+    /// Why the file failed
+    #[serde(default)]
+    pub error: Option<String>,
+}
+
+impl JobState {
+    /// Load the state file, or start a new one if it doesn't exist yet
+    /// 
+    /// Files found now but not recorded are added as pending. A resume with
+    /// settings that differ from the recorded ones is allowed, with a warning,
+    /// and the state then records the new settings.
+    pub fn load_or_create(path: &Path, files: &[PathBuf], config: &Config, suffix: &str) -> Result<Self> {
+        let parameters = job_parameters(config, suffix);
+        
+        let mut state = if path.exists() {
+            let content = fs::read_to_string(path)
+                .map_err(|e| anyhow::anyhow!("Failed to read job state {:?}: {}", path, e))?;
+            let mut state: JobState = serde_json::from_str(&content)
+                .map_err(|e| anyhow::anyhow!("Job state {:?} is not valid: {}", path, e))?;
+            
+            let changed: Vec<&str> = parameters.iter()
+                .filter(|(name, value)| state.parameters.get(*name) != Some(value))
+                .map(|(name, _)| name.as_str())
+                .collect();
+            if !changed.is_empty() {
+                warn!(
+                    "Resuming {:?} with different settings ({}); files done earlier keep their old transcripts",
+                    path, changed.join(", ")
+                );
+            }
+            
+            info!("Resuming job {:?}: {} of {} files already done", path, state.completed().len(), state.files.len());
+            state.parameters = parameters;
+            state
+        } else {
+            JobState {
+                version: JOB_STATE_VERSION,
+                parameters,
+                files: BTreeMap::new(),
+                path: PathBuf::new(),
+            }
+        };
+        
+        state.path = path.to_path_buf();
+        for file in files {
+            state.files.entry(file.to_string_lossy().into_owned()).or_insert_with(|| FileState {
+                status: "pending".to_string(),
+                outputs: Vec::new(),
+                error: None,
+            });
+        }
+        
+        state.save()?;
+        Ok(state)
+    }
+    
+    /// Files that succeeded in an earlier run
+    pub fn completed(&self) -> HashSet<PathBuf> {
+        self.files.iter()
+            .filter(|(_, file)| file.status == "succeeded")
+            .map(|(path, _)| PathBuf::from(path))
+            .collect()
+    }
+    
+    /// Record a file's result and save the state straight away
+    pub fn record(&mut self, audio_file: &Path, result: &Result<Vec<PathBuf>>) -> Result<()> {
+        let state = match result {
+            Ok(outputs) => FileState { status: "succeeded".to_string(), outputs: outputs.clone(), error: None },
+            Err(e) => FileState { status: "failed".to_string(), outputs: Vec::new(), error: Some(format!("{:#}", e)) },
+        };
+        self.files.insert(audio_file.to_string_lossy().into_owned(), state);
+        
+        self.save()
+    }
+    
+    /// Write the state file, replacing it atomically so a crash can't corrupt it
+    fn save(&self) -> Result<()> {
+        utils::write_atomic(&self.path, serde_json::to_string_pretty(self)?)
+    }
+}
+
+/// Settings that change what a transcript says or where it's written
+fn job_parameters(config: &Config, suffix: &str) -> BTreeMap<String, String> {
+    let formats: Vec<String> = config.output_formats.iter().map(|format| format.extension().to_string()).collect();
+    
+    BTreeMap::from([
+        ("provider".to_string(), config.provider.name().to_string()),
+        ("model".to_string(), if config.model_policy.is_some() { "auto".to_string() } else { config.model.clone() }),
+        ("language".to_string(), config.language.clone().unwrap_or_default()),
+        ("prompt".to_string(), config.prompt.clone().unwrap_or_default()),
+        ("translate".to_string(), config.translate.to_string()),
+        ("temperature".to_string(), config.temperature.to_string()),
+        ("response_formats".to_string(), formats.join(",")),
+        ("timestamps".to_string(), config.requested_granularities().first().copied().unwrap_or("").to_string()),
+        ("suffix".to_string(), suffix.to_string()),
+    ])
+}
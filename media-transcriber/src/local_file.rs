@@ -2,10 +2,11 @@ use anyhow::Result;
 use log::{debug, info};
 use std::path::{Path, PathBuf};
 use std::fs;
+use std::io::IsTerminal;
 
 use crate::config::Config;
-use crate::transcription::TranscriptionService;
-use crate::utils;
+use crate::transcription::{TranscriptionError, TranscriptionService};
+use crate::utils::{self, Spool, Spooled};
 
 /// Processor for local media files
 pub struct LocalFileProcessor<'a> {
@@ -26,24 +27,31 @@ impl<'a> LocalFileProcessor<'a> {
     /// 2. Creates an output directory for the transcription
     /// 3. Transcribes the file using the Whisper API
     /// 4. Saves the transcript to the output directory
-    pub async fn process(&self, file_path: &str) -> Result<()> {
-        // Convert string path to PathBuf
-        let file_path = PathBuf::from(file_path);
+    /// 
+    /// Returns the transcript files written.
+    pub async fn process(&self, file_path: &str) -> Result<Vec<PathBuf>> {
+        // Convert string path to PathBuf ("-" is standard input, transcribed as "stdin")
+        let from_stdin = file_path == STDIN_PATH;
+        let file_path = PathBuf::from(if from_stdin { "stdin" } else { file_path });
         
         // Validate file exists
-        if !file_path.exists() {
-            return Err(anyhow::anyhow!("File does not exist: {:?}", file_path));
+        if !from_stdin && !file_path.exists() {
+            return Err(TranscriptionError::FileNotFound { file: file_path }.into());
         }
         
-        // Validate file is a supported format
-        let extension = file_path.extension()
-            .and_then(|ext| ext.to_str())
-            .unwrap_or("");
-            
-        // Check if file is an MP3
-        if extension.to_lowercase() != "mp3" {
-            return Err(anyhow::anyhow!("Unsupported file format: {}", extension));
-        }
+        // Stdin and FIFOs can only be read once and have no size, so capture them to a regular
+        // file first, or into memory under --no-temp-disk
+        let mut capture = if from_stdin {
+            Some(self.capture_stdin()?)
+        } else if Self::is_fifo(&file_path) {
+            Some(self.capture_fifo(&file_path)?)
+        } else {
+            None
+        };
+        let audio_path = capture.as_ref()
+            .map(|(name, spooled)| spooled.path_or(name))
+            .unwrap_or_else(|| file_path.clone());
+        let held_audio = capture.as_mut().and_then(|(_, spooled)| spooled.take_memory());
         
         // Get file name for output directory
         let file_stem = file_path.file_stem()
@@ -61,7 +69,10 @@ impl<'a> LocalFileProcessor<'a> {
         let file_info = format!(
             "File: {}\nSize: {} bytes\nTranscribed: {}",
             file_path.display(),
-            fs::metadata(&file_path)?.len(),
+            match &held_audio {
+                Some(audio) => audio.len() as u64,
+                None => fs::metadata(&audio_path)?.len(),
+            },
             chrono::Local::now().to_rfc3339()
         );
         fs::write(output_dir.join("file_info.txt"), file_info)?;
@@ -70,25 +81,116 @@ impl<'a> LocalFileProcessor<'a> {
         let transcript_path = output_dir.join("transcript.txt");
         
         // Create transcription service
-        let transcription_service = TranscriptionService::new(self.config);
+        let transcription_service = TranscriptionService::new(self.config).holding(held_audio);
         
         // Transcribe the file
         info!("Transcribing local file: {:?}", file_path);
-        transcription_service.transcribe_file(&file_path, &transcript_path).await?;
+        let outputs = transcription_service.transcribe_file(&audio_path, &transcript_path).await?;
         
         info!("Transcription complete: {:?}", transcript_path);
-        Ok(())
+        Ok(outputs)
     }
     
     /// Check if a path is a local file path rather than a URL
     pub fn is_local_file_path(path: &str) -> bool {
+        // "-" reads the audio from standard input
+        if path == STDIN_PATH {
+            return true;
+        }
+        
         // Check if path starts with http:// or https://
         if path.starts_with("http://") || path.starts_with("https://") {
             return false;
         }
         
-        // Check if path exists as a local file
+        // Check if path exists as a local file (or a named pipe fed by a recorder)
         let path_buf = PathBuf::from(path);
-        path_buf.exists() && path_buf.is_file()
+        path_buf.exists() && (path_buf.is_file() || Self::is_fifo(&path_buf))
+    }
+    
+    /// Check if a path is a named pipe (FIFO)
+    #[cfg(unix)]
+    fn is_fifo(path: &Path) -> bool {
+        use std::os::unix::fs::FileTypeExt;
+        
+        fs::metadata(path)
+            .map(|metadata| metadata.file_type().is_fifo())
+            .unwrap_or(false)
+    }
+    
+    /// Check if a path is a named pipe (FIFO)
+    #[cfg(not(unix))]
+    fn is_fifo(_path: &Path) -> bool {
+        false
+    }
+    
+    /// Read a named pipe until the writer closes it, saving the data to a temporary file
+    /// 
+    /// The FIFO's extension is kept (defaulting to mp3) so format checks still
+    /// apply. Returns the capture's file name with the data, which stays in
+    /// memory under --no-temp-disk if it's small enough; a file is removed
+    /// along with its temporary directory when the data is dropped.
+    fn capture_fifo(&self, fifo_path: &Path) -> Result<(String, Spooled)> {
+        info!("Reading named pipe until the writer closes it: {:?}", fifo_path);
+        
+        let extension = fifo_path.extension()
+            .and_then(|ext| ext.to_str())
+            .unwrap_or("mp3");
+        let capture_name = format!("fifo_capture.{}", extension);
+        
+        let mut fifo = fs::File::open(fifo_path)?;
+        let mut spool = Spool::new(self.config.temp_dir.as_deref(), &capture_name, self.config.memory_spool_limit());
+        std::io::copy(&mut fifo, &mut spool)?;
+        let capture = spool.finish()?;
+        
+        debug!("Captured {} bytes from named pipe {:?} ({})", capture.len(), fifo_path, capture);
+        Ok((capture_name, capture))
+    }
+    
+    /// Read standard input to the end, saving the audio to a temporary file
+    /// 
+    /// Stdin has no file name, so the format comes from --input-format or is
+    /// recognized from the data's first bytes. Returns the capture's file name
+    /// with the data, as `capture_fifo` does.
+    fn capture_stdin(&self) -> Result<(String, Spooled)> {
+        if std::io::stdin().is_terminal() {
+            return Err(anyhow::anyhow!("--source - reads audio from standard input, but nothing is piped in"));
+        }
+        
+        info!("Reading audio from standard input");
+        
+        let mut spool = Spool::new(self.config.temp_dir.as_deref(), "stdin_capture", self.config.memory_spool_limit());
+        std::io::copy(&mut std::io::stdin().lock(), &mut spool)?;
+        let mut capture = spool.finish()?;
+        if capture.len() == 0 {
+            return Err(anyhow::anyhow!("No audio data was received on standard input"));
+        }
+        
+        let format = match &self.config.input_format {
+            Some(format) => format.trim_start_matches('.').to_lowercase(),
+            None => {
+                let sniffed = match &capture {
+                    Spooled::Memory(data) => utils::sniff_bytes(data),
+                    Spooled::File { path, .. } => utils::sniff_file_type(path)?,
+                };
+                sniffed
+                    .ok_or_else(|| anyhow::anyhow!("Couldn't tell the format of the audio on standard input; pass --input-format (e.g. mp3)"))?
+                    .to_string()
+            }
+        };
+        
+        // The extension is what the format checks and the API go by
+        let capture_name = format!("stdin_capture.{}", format);
+        if let Spooled::File { path, .. } = &mut capture {
+            let capture_path = path.with_file_name(&capture_name);
+            fs::rename(&path, &capture_path)?;
+            *path = capture_path;
+        }
+        
+        debug!("Captured {} bytes of {} audio from standard input ({})", capture.len(), format, capture);
+        Ok((capture_name, capture))
     }
 }
+
+/// Source path that stands for standard input
+pub const STDIN_PATH: &str = "-";
@@ -1,19 +1,46 @@
 use anyhow::Result;
-use clap::{Parser, Subcommand};
+use clap::parser::ValueSource;
+use clap::{CommandFactory, FromArgMatches, Parser, Subcommand};
 use colored::Colorize;
-use log::{error, info};
+use log::{error, info, warn};
+use std::io::IsTerminal;
 use std::path::PathBuf;
+use std::time::{Duration, Instant};
+use thiserror::Error;
 
+mod align;
+mod assemblyai;
+mod batch;
+mod captions;
 mod config;
+mod dashboard;
+mod doctor;
+mod estimate;
+mod index;
+mod job;
 mod local_file;
+mod models;
+mod output;
+mod plaintext;
 mod podcast;
+mod remote_file;
+mod report;
+mod resegment;
 mod transcription;
 mod utils;
+mod whisper_cpp;
 mod youtube;
 
-use config::Config;
+use config::{AudioStreamSelection, Config, ConfigError, OutputFormat, OutputSpec, Provider, RetryStatusCodes, SplitEvery, TextWrap, TimestampGranularity, TranscodeFormat};
+use captions::InputEncoding;
+use dashboard::Dashboard;
+use index::IndexFormat;
 use local_file::LocalFileProcessor;
 use podcast::PodcastProcessor;
+use remote_file::RemoteFileProcessor;
+use report::RunReport;
+use transcription::TranscriptionError;
+use utils::{RetryPolicy, DEFAULT_RETRY_STATUS_CODES};
 use youtube::YouTubeProcessor;
 
 /// Media Transcriber - A fast tool for transcribing podcasts, YouTube videos, and local MP3 files
@@ -30,95 +57,1085 @@ struct Cli {
     #[command(subcommand)]
     command: Option<Commands>,
 
-    /// URL of a podcast RSS feed, YouTube channel/video, or path to a local MP3 file
+    /// URL of a podcast RSS feed, YouTube channel/video or audio file, path to a local MP3 file, or - to read audio from stdin
     #[arg(short, long, conflicts_with = "file")]
     source: Option<String>,
 
+    /// Format of audio piped in with --source - (e.g. mp3); recognized from the data when omitted
+    #[arg(long, value_name = "EXT")]
+    input_format: Option<String>,
+
+    /// Transcribe every audio file in a directory or matching a pattern (e.g. "interviews/*.m4a"), writing transcripts next to them
+    #[arg(long, value_name = "DIR|PATTERN")]
+    batch: Option<String>,
+
+    /// Suffix for --batch transcripts, replacing the audio file's extension
+    #[arg(long, default_value = ".txt", requires = "batch")]
+    suffix: String,
+
+    /// Path for each --batch transcript, from {dir}, {name}, {ext} (of the audio file), {format} and {lang}, e.g. "transcripts/{name}.en.{format}"
+    #[arg(long, value_name = "TEMPLATE", requires = "batch", conflicts_with = "suffix")]
+    output_template: Option<String>,
+
+    /// Number of --batch files transcribed at the same time
+    #[arg(long, default_value_t = 3, requires = "batch", value_parser = clap::value_parser!(u16).range(1..=32))]
+    concurrency: u16,
+
+    /// Record each --batch file's result in this JSON state file, and skip files it lists as done when re-run
+    #[arg(long, value_name = "STATEFILE", requires = "batch", value_parser = utils::parse_path)]
+    resume: Option<PathBuf>,
+
     /// File containing a list of sources (one URL per line)
-    #[arg(short, long, conflicts_with = "source")]
+    #[arg(short, long, conflicts_with = "source", value_parser = utils::parse_path)]
     file: Option<PathBuf>,
 
     /// Language code (e.g., 'en' for English)
-    #[arg(short, long)]
+    #[arg(short, long, env("PODSCRIPT_LANGUAGE"))]
     language: Option<String>,
 
+    /// Translate the audio into English text (Whisper's translations endpoint) instead of transcribing it
+    #[arg(long, conflicts_with = "detect_language_per_chunk")]
+    translate: bool,
+
+    /// Convert audio to 16 kHz mono mp3 (or opus) with ffmpeg before uploading, for smaller uploads and formats Whisper rejects
+    #[arg(long, value_enum, num_args = 0..=1, default_missing_value = "mp3", value_name = "FORMAT")]
+    transcode: Option<TranscodeFormat>,
+
+    /// Only print the language detected in the first SECONDS of each source (default 30), writing no transcripts
+    #[arg(long, num_args = 0..=1, default_missing_value = "30", value_name = "SECONDS", value_parser = clap::value_parser!(u64).range(1..), conflicts_with = "translate")]
+    detect_language_only: Option<u64>,
+
+    /// Always call the API, ignoring results cached from identical earlier requests
+    #[arg(long)]
+    no_cache: bool,
+
     /// Context to improve transcription accuracy
     #[arg(short, long)]
     prompt: Option<String>,
 
+    /// Sampling temperature from 0 to 1; higher values vary the wording more between runs
+    #[arg(long, default_value_t = 0.0, value_parser = config::parse_temperature)]
+    temperature: f32,
+
+    /// Read the prompt from a file, e.g. a glossary of names and jargon (cut to Whisper's prompt limit)
+    #[arg(long, value_name = "FILE", conflicts_with = "prompt", value_parser = utils::parse_path)]
+    prompt_file: Option<PathBuf>,
+
     /// Limit the number of episodes/videos to process (newest first)
     #[arg(short, long)]
     limit: Option<usize>,
 
-    /// OpenAI API key for transcription
+    /// API key for transcription (default: OPENAI_API_KEY, or GROQ_API_KEY with --provider groq)
     #[arg(long, env("OPENAI_API_KEY"))]
     api_key: Option<String>,
 
+    /// Read the API key from this file (e.g. a mounted secret) instead of the environment
+    #[arg(long, value_name = "PATH", conflicts_with = "api_key_ref", value_parser = utils::parse_path)]
+    api_key_file: Option<PathBuf>,
+
+    /// Fetch the API key from a secret manager: op://VAULT/ITEM/FIELD (1Password) or vault://PATH#FIELD (Vault)
+    #[arg(long, value_name = "REF")]
+    api_key_ref: Option<String>,
+
+    /// Transcription backend ('local' targets a whisper.cpp server and needs no API key)
+    #[arg(long, value_enum, env("PODSCRIPT_PROVIDER"), default_value_t = Provider::Openai)]
+    provider: Provider,
+
+    /// Label who is speaking in text and SRT/VTT output ("Speaker A: ..."); only --provider assemblyai supports it
+    #[arg(long)]
+    diarize: bool,
+
+    /// How often to check on a queued AssemblyAI transcript, e.g. 3s or 1m
+    #[arg(long, default_value = "3s", value_name = "DURATION", value_parser = utils::parse_duration)]
+    poll_interval: Duration,
+
+    /// Model to transcribe with (default: whisper-1, whisper-large-v3 with --provider groq, best with --provider assemblyai)
+    #[arg(long, env("PODSCRIPT_MODEL"))]
+    model: Option<String>,
+
+    /// Pick each file's model by its length and --language, using --model-policy or a built-in policy (--model on the command line wins)
+    #[arg(long)]
+    auto_model: bool,
+
+    /// Rules for --auto-model, one per line: a model and its conditions (duration<10m, duration>=1h, language=en, language!=en); the first match wins
+    #[arg(long, env("PODSCRIPT_MODEL_POLICY"), value_name = "FILE", requires = "auto_model", value_parser = utils::parse_path)]
+    model_policy: Option<PathBuf>,
+
+    /// With --provider local, run whisper.cpp with this ggml model file instead of calling a whisper.cpp server
+    #[arg(long, env("PODSCRIPT_WHISPER_MODEL"), value_name = "PATH", value_parser = utils::parse_path)]
+    whisper_model: Option<PathBuf>,
+
+    /// whisper.cpp program to run with --whisper-model (default: whisper-cli or main on the PATH)
+    #[arg(long, value_name = "PATH", requires = "whisper_model", value_parser = utils::parse_path)]
+    whisper_binary: Option<PathBuf>,
+
+    /// Base URL of the provider's API, e.g. a proxy or an Azure OpenAI deployment (default: OPENAI_API_BASE for openai, http://localhost:8080/v1 for local)
+    #[arg(long, value_name = "URL")]
+    api_base: Option<String>,
+
+    /// Filename to send with each upload (e.g. audio.wav), for servers that infer the format from it
+    #[arg(long, value_name = "NAME")]
+    api_filename: Option<String>,
+
+    /// Save every provider response body, exactly as received, as numbered files in this directory
+    #[arg(long, value_name = "DIR", value_parser = utils::parse_path)]
+    save_raw_response: Option<PathBuf>,
+
+    /// Send each request to several providers at once and keep the first success (e.g. openai,local)
+    #[arg(long, value_enum, value_delimiter = ',', value_name = "PROVIDERS")]
+    fanout: Vec<Provider>,
+
+    /// Only transcribe podcast episodes published on or after this date (YYYY-MM-DD)
+    #[arg(long, value_name = "DATE")]
+    since: Option<chrono::NaiveDate>,
+
+    /// Skip podcast episodes and --batch files that already have a transcript, so a run can be repeated to pick up new ones
+    #[arg(long)]
+    skip_existing: bool,
+
+    /// Fail a source instead of overwriting its existing transcript (or set PODSCRIPT_NO_CLOBBER=true)
+    #[arg(long, env("PODSCRIPT_NO_CLOBBER"))]
+    no_clobber: bool,
+
+    /// Overwrite existing transcripts even when PODSCRIPT_NO_CLOBBER is set
+    #[arg(long)]
+    force: bool,
+
     /// Output directory for transcripts (default: transcripts)
-    #[arg(short, long, default_value = "transcripts")]
+    #[arg(short, long, default_value = "transcripts", value_parser = utils::parse_path)]
     output_dir: PathBuf,
 
-    /// Enable verbose logging
+    /// Also write each format as numbered parts split on segment boundaries, every N words (2000w) or every so much audio (10m)
+    #[arg(long, value_name = "WORDS|DURATION")]
+    split_output_every: Option<SplitEvery>,
+
+    /// Also write each segment to a numbered file (e.g. 0001_00-00-12.txt) in <transcript>.segments/, with an index.json of times
+    #[arg(long)]
+    split_segments: bool,
+
+    /// Lay out plain-text transcripts: 'pauses' starts a paragraph at each long pause, a number wraps lines at that column
+    #[arg(long, env("PODSCRIPT_WRAP"), num_args = 0..=1, default_missing_value = "pauses", value_name = "pauses|COLUMNS")]
+    wrap: Option<TextWrap>,
+
+    /// Keep each transcript as Whisper returned it, overriding --wrap and PODSCRIPT_WRAP
+    #[arg(long)]
+    no_wrap: bool,
+
+    /// Append a "Segments" section listing each segment's [mm:ss] start time and text to the text transcript
+    #[arg(long)]
+    include_segments: bool,
+
+    /// Remove filler words (um, uh, you know, ...) from transcripts
+    #[arg(long)]
+    trim_fillers: bool,
+
+    /// File of filler words/phrases for --trim-fillers, one per line (default: a built-in English list)
+    #[arg(long, value_name = "FILE", requires = "trim_fillers", value_parser = utils::parse_path)]
+    filler_list: Option<PathBuf>,
+
+    /// Pipe each transcript through this shell command and keep its stdout (PODSCRIPT_FORMAT=text is set)
+    #[arg(long, value_name = "COMMAND")]
+    postprocess_command: Option<String>,
+
+    /// Run this shell command on each finished transcript, with its path as $1 and its text on stdin (runs arbitrary commands)
+    #[arg(long, value_name = "COMMAND")]
+    post_hook: Option<String>,
+
+    /// Run this shell command when a transcription fails, with the error in PODSCRIPT_ERROR (runs arbitrary commands)
+    #[arg(long, value_name = "COMMAND")]
+    post_hook_on_error: Option<String>,
+
+    /// Truncate text transcripts longer than this many bytes at a sentence boundary, each part and --output-spec txt file too (header/footer not counted)
+    #[arg(long, value_name = "BYTES")]
+    max_output_bytes: Option<usize>,
+
+    /// Largest audio file to download when --source is a direct audio URL, in MB
+    #[arg(long, default_value_t = 500, value_name = "MB")]
+    max_download_size: u64,
+
+    /// Keep stdin, named-pipe and direct-URL audio in memory instead of a temp file, up to --in-memory-limit
+    #[arg(long)]
+    no_temp_disk: bool,
+
+    /// Largest input --no-temp-disk holds in memory, in MB; larger ones still go to a temp file
+    #[arg(long, default_value_t = 25, value_name = "MB", requires = "no_temp_disk")]
+    in_memory_limit: u64,
+
+    /// File to write before each transcript and part, as NOTE blocks in VTT ({filename}, {date} and {model} are substituted)
+    #[arg(long, value_name = "FILE", value_parser = utils::parse_path)]
+    prepend_file: Option<PathBuf>,
+
+    /// File to write after each transcript and part, as NOTE blocks in VTT ({filename}, {date} and {model} are substituted)
+    #[arg(long, value_name = "FILE", value_parser = utils::parse_path)]
+    append_file: Option<PathBuf>,
+
+    /// Don't refuse files whose extension or contents don't look like audio the API supports
+    #[arg(long)]
+    skip_format_check: bool,
+
+    /// Decode each audio file before transcribing and refuse truncated, corrupt or DRM-protected files
+    #[arg(long)]
+    verify_integrity: bool,
+
+    /// Transcribe each audio stream of multi-track files separately ('all') or only stream N
+    #[arg(long, value_name = "all|N")]
+    audio_stream: Option<AudioStreamSelection>,
+
+    /// Print the equivalent curl command (API key redacted) for each transcription request
+    #[arg(long)]
+    print_command: bool,
+
+    /// Replace names, emails, phone, card and social security numbers in transcripts (and captions) with tags like [PHONE]
+    #[arg(long)]
+    redact_pii: bool,
+
+    /// Formats to write each transcript in, comma-separated (text, srt, vtt, json, podscript-json); the text transcript is always written
+    #[arg(long, value_enum, value_delimiter = ',', default_value = "text", value_name = "FORMATS")]
+    response_format: Vec<OutputFormat>,
+
+    /// Wrap SRT/VTT cue text at this many characters, splitting cues that need more than two lines
+    #[arg(long, value_name = "CHARS", value_parser = clap::value_parser!(u16).range(10..))]
+    max_line_length: Option<u16>,
+
+    /// Split SRT/VTT cues longer than this many seconds, sharing their time out by length
+    #[arg(long, value_name = "SECONDS")]
+    max_cue_duration: Option<f64>,
+
+    /// Add the audio's waveform (the peak of each window, 0 to 1) to json and podscript-json transcripts, read with ffmpeg
+    #[arg(long)]
+    include_peaks: bool,
+
+    /// Waveform peaks per second of audio for --include-peaks
+    #[arg(long, default_value_t = 10, value_name = "N", requires = "include_peaks", value_parser = clap::value_parser!(u32).range(1..=100))]
+    peaks_per_second: u32,
+
+    /// Also write FORMAT to PATH (relative to the transcript, {name} for its stem) with its own options, e.g. srt:{name}.short.srt:maxcue=5s,maxline=32 or txt:{name}.read.txt:paragraphs; repeatable
+    #[arg(long = "output-spec", value_name = "FORMAT:PATH[:OPTIONS]")]
+    output_specs: Vec<OutputSpec>,
+
+    /// Also write <transcript>.timestamps.json with start/end times in seconds for each segment, or each word too
+    #[arg(long, value_enum, value_name = "word|segment")]
+    timestamps: Option<TimestampGranularity>,
+
+    /// Timings to request with SRT/VTT/JSON output, comma-separated (segment, word or both) instead of what --timestamps implies
+    #[arg(long, value_enum, value_delimiter = ',', value_name = "segment,word", conflicts_with = "timestamps")]
+    granularity: Vec<TimestampGranularity>,
+
+    /// Split files larger than this many MB into chunks (at most 25, OpenAI's upload limit)
+    #[arg(long, default_value_t = 24, value_name = "MB", value_parser = clap::value_parser!(u64).range(1..=25))]
+    chunk_size: u64,
+
+    /// Seconds each chunk overlaps the previous one; words repeated in the overlap are removed when joining
+    #[arg(long, default_value_t = 2, value_name = "SECONDS")]
+    chunk_overlap: u64,
+
+    /// Merge a final chunk shorter than this many seconds into the previous one when splitting large files
+    #[arg(long, default_value_t = 10, value_name = "SECONDS")]
+    min_chunk_duration: u64,
+
+    /// Prompt each chunk of a large file with the end of the previous chunk's transcript, for consistent names and terms
+    #[arg(long)]
+    carry_context: bool,
+
+    /// Number of chunks of a large file transcribed at the same time (1 with --carry-context, which needs them in order)
+    #[arg(long, default_value_t = config::DEFAULT_CHUNK_CONCURRENCY as u16, value_parser = clap::value_parser!(u16).range(1..=16))]
+    chunk_concurrency: u16,
+
+    /// Most request bodies uploaded at the same time, however many files and chunks are in flight (default: no limit)
+    #[arg(long, value_name = "N", value_parser = clap::value_parser!(u16).range(1..))]
+    max_concurrent_uploads: Option<u16>,
+
+    /// Pace provider requests, halving the rate on each 429 (and retrying up to --retries times) and adding one request per minute back after each success
+    #[arg(long)]
+    adaptive_rate: bool,
+
+    /// Requests per minute --adaptive-rate starts at
+    #[arg(long, default_value_t = 60.0, value_name = "PER_MINUTE", requires = "adaptive_rate")]
+    initial_rate: f64,
+
+    /// Lowest requests per minute --adaptive-rate backs off to
+    #[arg(long, default_value_t = 5.0, value_name = "PER_MINUTE", requires = "adaptive_rate")]
+    min_rate: f64,
+
+    /// Highest requests per minute --adaptive-rate ramps up to
+    #[arg(long, default_value_t = 600.0, value_name = "PER_MINUTE", requires = "adaptive_rate")]
+    max_rate: f64,
+
+    /// Detect the language of each chunk of a large file separately, for recordings that switch languages
+    #[arg(long, conflicts_with = "language")]
+    detect_language_per_chunk: bool,
+
+    /// Detect the language on the first minute, then transcribe the whole file with it pinned (ignored with --language)
+    #[arg(long, conflicts_with = "detect_language_per_chunk")]
+    auto_language: bool,
+
+    /// Print the transcript of a chunked file to stdout chunk by chunk as they finish, appending to the transcript file too
+    #[arg(long, conflicts_with_all = ["batch", "file"])]
+    stream: bool,
+
+    /// Directory for temporary files such as downloads and audio chunks (default: the OS temp dir)
+    #[arg(long, env("PODSCRIPT_TEMP_DIR"), value_name = "DIR", value_parser = utils::parse_path)]
+    temp_dir: Option<PathBuf>,
+
+    /// Keep temporary files (downloads, chunks, transcoded audio) for debugging and print where they are
+    #[arg(long)]
+    keep_temp: bool,
+
+    /// Print HTTP request counts, retries, bytes sent and received, and p50/p95 latency at the end (also added to the --webhook report)
+    #[arg(long)]
+    metrics: bool,
+
+    /// Deadline for each transcription request (each chunk of a large file gets its own), e.g. 10m or 90s
+    #[arg(long, default_value = "30m", value_name = "DURATION", value_parser = utils::parse_duration)]
+    timeout: Duration,
+
+    /// Seconds to wait for an HTTP connection (including TLS) before giving up
+    #[arg(long, default_value_t = utils::DEFAULT_CONNECT_TIMEOUT_SECS, value_name = "SECONDS")]
+    connect_timeout: u64,
+
+    /// How long a provider may take to start responding once an upload is sent, e.g. 5m
+    #[arg(long, default_value = "10m", value_name = "DURATION", value_parser = utils::parse_duration)]
+    response_timeout: Duration,
+
+    /// How long reading a provider's response body may take, e.g. 2m
+    #[arg(long, default_value = "2m", value_name = "DURATION", value_parser = utils::parse_duration)]
+    read_timeout: Duration,
+
+    /// Print each local file's duration and the estimated API cost, then exit without transcribing
+    #[arg(long)]
+    dry_run: bool,
+
+    /// Price per minute of audio used by --dry-run, in US dollars
+    #[arg(long, env("PODSCRIPT_PRICE_PER_MINUTE"), default_value_t = estimate::DEFAULT_PRICE_PER_MINUTE, value_name = "USD")]
+    price_per_minute: f64,
+
+    /// Fail a source whose transcript comes back empty instead of only warning
+    #[arg(long)]
+    fail_on_empty: bool,
+
+    /// Parse each SRT, VTT and JSON file back after writing it and fail the source if one is malformed
+    #[arg(long)]
+    validate_output: bool,
+
+    /// Number of times to retry downloads and transcription requests after network errors or retryable HTTP statuses
+    #[arg(long, default_value_t = 3)]
+    retries: u32,
+
+    /// HTTP status codes to retry (default: 429,500,502,503,504); `configure` can save them in .env
+    #[arg(long, env("RETRY_STATUS_CODES"), value_name = "CODES")]
+    retry_status_codes: Option<RetryStatusCodes>,
+
+    /// POST a JSON summary of the run to this URL when it completes or fails
+    #[arg(long, value_name = "URL")]
+    webhook: Option<String>,
+
+    /// Write failed sources (with the error as a comment) to this file, in a format --file can re-run
+    #[arg(long, value_name = "FILE", value_parser = utils::parse_path)]
+    errors_output: Option<PathBuf>,
+
+    /// Show a live status dashboard when processing a sources file (requires a terminal)
+    #[arg(long)]
+    tui: bool,
+
+    /// Only print errors: no progress, warnings or final status
+    #[arg(short, long, conflicts_with_all = ["verbose", "debug"])]
+    quiet: bool,
+
+    /// Log each high-level step (sources, episodes, chunks)
     #[arg(short, long)]
     verbose: bool,
+
+    /// Log detailed tracing of every step, commands and requests included
+    #[arg(long)]
+    debug: bool,
+}
+
+/// How much is printed, from --quiet, --verbose and --debug
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+enum Verbosity {
+    /// Errors only
+    Quiet,
+    /// Warnings, errors and the final status
+    Normal,
+    /// Plus high-level steps
+    Verbose,
+    /// Plus detailed tracing
+    Debug,
+}
+
+impl Verbosity {
+    /// Level chosen by the command-line flags (--debug wins over --verbose)
+    fn from_cli(cli: &Cli) -> Self {
+        if cli.debug {
+            Verbosity::Debug
+        } else if cli.verbose {
+            Verbosity::Verbose
+        } else if cli.quiet {
+            Verbosity::Quiet
+        } else {
+            Verbosity::Normal
+        }
+    }
+    
+    /// Default log filter for the level (RUST_LOG still overrides it)
+    fn log_filter(&self) -> &'static str {
+        match self {
+            Verbosity::Quiet => "error",
+            Verbosity::Normal => "warn",
+            Verbosity::Verbose => "info",
+            Verbosity::Debug => "debug",
+        }
+    }
 }
 
 #[derive(Subcommand)]
 enum Commands {
-    /// Configure API keys and settings
-    Configure,
+    /// Save the API key and default language to .env, prompting for them unless given as flags
+    Configure {
+        /// API key to save without prompting (for scripts)
+        #[arg(long, value_name = "KEY")]
+        openai_api_key: Option<String>,
+        
+        /// Default language code to save, e.g. en
+        #[arg(long)]
+        language: Option<String>,
+        
+        /// Default provider to save; the key is saved as that provider's key (e.g. GROQ_API_KEY)
+        #[arg(long, value_enum)]
+        provider: Option<Provider>,
+        
+        /// HTTP status codes to retry by default, e.g. 429,500,502,503,529
+        #[arg(long, value_name = "CODES")]
+        retry_status_codes: Option<RetryStatusCodes>,
+    },
+    /// Manage cached transcription results
+    Cache {
+        #[command(subcommand)]
+        action: CacheCommand,
+    },
+    /// Check dependencies, API key and connectivity before a big run
+    Doctor,
+    /// List the transcription models of the provider (and --api-base) in use
+    Models,
+    /// Re-split a verbose_json or SRT transcript into sentence-level segments
+    Resegment {
+        /// Transcript to re-split (.json in verbose_json format, or .srt)
+        input: PathBuf,
+        
+        /// Where to write the result (default: <name>.sentences.<ext> next to the input)
+        #[arg(long)]
+        output: Option<PathBuf>,
+        
+        /// Encoding of the input file when it has no byte order mark (default: utf-8)
+        #[arg(long, value_enum)]
+        input_encoding: Option<InputEncoding>,
+    },
+    /// Re-time an edited plain-text transcript into SRT/VTT using the original word timestamps
+    Align {
+        /// Edited plain-text transcript
+        text: PathBuf,
+        
+        /// Original verbose_json transcript with word timestamps
+        words: PathBuf,
+        
+        /// Where to write the captions; .srt or .vtt (default: <name>.srt next to the text)
+        #[arg(long)]
+        output: Option<PathBuf>,
+        
+        /// Encoding of the input file when it has no byte order mark (default: utf-8)
+        #[arg(long, value_enum)]
+        input_encoding: Option<InputEncoding>,
+    },
+    /// Build a word-level search index (CTM or compact JSON) from a verbose_json transcript
+    Index {
+        /// verbose_json transcript with word timestamps
+        input: PathBuf,
+        
+        /// Index format
+        #[arg(long, value_enum, default_value_t = IndexFormat::Json)]
+        format: IndexFormat,
+        
+        /// Where to write the index (default: <name>.ctm or <name>.index.json next to the input)
+        #[arg(long)]
+        output: Option<PathBuf>,
+    },
+    /// Convert an SRT or VTT file to plain text, dropping cue numbers and timestamps
+    StripTimestamps {
+        /// Caption file to convert (.srt or .vtt)
+        input: PathBuf,
+        
+        /// Where to write the result (default: <name>.plain.txt next to the input)
+        #[arg(long)]
+        output: Option<PathBuf>,
+        
+        /// Encoding of the input file when it has no byte order mark (default: utf-8)
+        #[arg(long, value_enum)]
+        input_encoding: Option<InputEncoding>,
+    },
+}
+
+#[derive(Subcommand)]
+enum CacheCommand {
+    /// Delete all cached transcription results and chunks
+    Clear,
+}
+
+/// Exit status for invalid arguments (clap uses it for its own errors too)
+const EXIT_USAGE: i32 = 2;
+/// Exit status when the API key is missing or rejected
+const EXIT_AUTH: i32 = 3;
+/// Exit status when a file, URL or API endpoint doesn't exist
+const EXIT_NOT_FOUND: i32 = 4;
+/// Exit status when the provider's rate limit or quota was hit
+const EXIT_RATE_LIMITED: i32 = 5;
+/// Exit status for connection failures and timeouts
+const EXIT_NETWORK: i32 = 6;
+
+/// Missing, invalid or conflicting command-line arguments, which exit with `EXIT_USAGE`
+#[derive(Error)]
+#[error("{0}")]
+struct UsageError(String);
+
+// `main` prints errors with {:?}, which should read as the message alone
+impl std::fmt::Debug for UsageError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.0)
+    }
+}
+
+/// Report an argument check that failed elsewhere (e.g. in `Config`) as a usage error
+fn usage(error: anyhow::Error) -> anyhow::Error {
+    UsageError(format!("{:#}", error)).into()
 }
 
 /// Main entry point for the media transcriber application
+/// 
+/// Errors are printed and mapped to an exit status by category (see
+/// `exit_status`), so scripts can tell a bad key from a network outage.
 #[tokio::main]
-async fn main() -> Result<()> {
-    // Parse command line arguments
-    let cli = Cli::parse();
+async fn main() {
+    if let Err(e) = run().await {
+        eprintln!("Error: {:?}", e);
+        std::process::exit(exit_status(&e));
+    }
+}
+
+/// Exit status for an error, found by looking through its causes for a known type
+fn exit_status(error: &anyhow::Error) -> i32 {
+    for cause in error.chain() {
+        if cause.downcast_ref::<UsageError>().is_some() {
+            return EXIT_USAGE;
+        }
+        
+        if let Some(ConfigError::ApiKeyNotFound(_)) = cause.downcast_ref::<ConfigError>() {
+            return EXIT_AUTH;
+        }
+        
+        if let Some(error) = cause.downcast_ref::<TranscriptionError>() {
+            return match error {
+                TranscriptionError::Api { status, .. } => match status.as_u16() {
+                    401 | 403 => EXIT_AUTH,
+                    404 => EXIT_NOT_FOUND,
+                    429 => EXIT_RATE_LIMITED,
+                    _ => 1,
+                },
+                TranscriptionError::FileNotFound { .. } => EXIT_NOT_FOUND,
+                TranscriptionError::Timeout { .. } => EXIT_NETWORK,
+            };
+        }
+        
+        if cause.downcast_ref::<utils::HttpTimeoutError>().is_some() {
+            return EXIT_NETWORK;
+        }
+        
+        // Downloads and other requests fail with the HTTP client's own errors
+        if let Some(error) = cause.downcast_ref::<reqwest::Error>() {
+            return match error.status().map(|status| status.as_u16()) {
+                Some(401 | 403) => EXIT_AUTH,
+                Some(404 | 410) => EXIT_NOT_FOUND,
+                Some(429) => EXIT_RATE_LIMITED,
+                Some(_) => 1,
+                None if error.is_connect() || error.is_timeout() || error.is_request() => EXIT_NETWORK,
+                None => 1,
+            };
+        }
+        
+        if let Some(error) = cause.downcast_ref::<std::io::Error>() {
+            if error.kind() == std::io::ErrorKind::NotFound {
+                return EXIT_NOT_FOUND;
+            }
+        }
+    }
+    
+    1
+}
+
+/// Run the command given on the command line
+async fn run() -> Result<()> {
+    // Load settings saved by `configure` so they work as defaults for the flags below. A missing
+    // .env is fine, but a broken one would silently drop every setting after the bad line
+    if let Err(dotenv::Error::LineParse(line, _)) = dotenv::dotenv() {
+        return Err(anyhow::anyhow!(
+            "Couldn't parse this line of .env: {:?}. Lines look like KEY=value, and ${{VAR}} refers to another variable",
+            line
+        ));
+    }
+    
+    // Parse command line arguments, keeping the matches to tell flags from environment defaults
+    let matches = Cli::command().get_matches();
+    let cli = Cli::from_arg_matches(&matches).unwrap_or_else(|e| e.exit());
     
     // Initialize logging
-    init_logger(cli.verbose);
+    let verbosity = Verbosity::from_cli(&cli);
+    init_logger(verbosity);
+    
+    // Set up the HTTP client shared by every request
+    utils::init_http_client(Duration::from_secs(cli.connect_timeout))?;
+    utils::keep_temp_dirs(cli.keep_temp);
+    if cli.metrics {
+        utils::enable_http_metrics();
+    }
+    if let Some(limit) = cli.max_concurrent_uploads {
+        utils::limit_concurrent_uploads(limit as usize);
+    }
     
     // Print welcome message
-    print_welcome();
+    if verbosity >= Verbosity::Verbose {
+        print_welcome();
+    }
     
     // Process commands or default behavior
     match &cli.command {
-        Some(Commands::Configure) => {
-            configure().await?;
+        Some(Commands::Configure { openai_api_key, language, provider, retry_status_codes }) => {
+            configure(openai_api_key.clone(), language.clone(), *provider, retry_status_codes.clone()).await?;
+        }
+        Some(Commands::Cache { action: CacheCommand::Clear }) => {
+            clear_cache()?;
+        }
+        Some(Commands::Doctor) => {
+            if !doctor::run(cli.api_key).await? {
+                return Err(anyhow::anyhow!("Some required checks failed; see the list above"));
+            }
+            return Ok(());
+        }
+        Some(Commands::Models) => {
+            let api_key = api_key_from_cli(&cli, &matches)?;
+            models::run(cli.provider, api_key, cli.api_base.clone()).await?;
+            return Ok(());
+        }
+        Some(Commands::Resegment { input, output, input_encoding }) => {
+            resegment::run(input, output.clone(), *input_encoding)?;
+        }
+        Some(Commands::Align { text, words, output, input_encoding }) => {
+            align::run(text, words, output.clone(), *input_encoding)?;
+        }
+        Some(Commands::Index { input, format, output }) => {
+            index::run(input, *format, output.clone())?;
+        }
+        Some(Commands::StripTimestamps { input, output, input_encoding }) => {
+            plaintext::run(input, output.clone(), *input_encoding)?;
         }
         None => {
             // Validate input - need at least one source
-            if cli.source.is_none() && cli.file.is_none() {
-                error!("You must specify either --source or --file");
-                std::process::exit(1);
+            if cli.source.is_none() && cli.file.is_none() && cli.batch.is_none() {
+                return Err(UsageError("You must specify --source, --file or --batch".to_string()).into());
+            }
+            
+            // Estimate from local files only, so no API key or network is needed
+            if cli.dry_run {
+                let files = if let Some(batch) = &cli.batch {
+                    batch::find_audio_files(batch)?
+                } else if let Some(sources_file) = &cli.file {
+                    let content = std::fs::read_to_string(sources_file)?;
+                    estimate::local_sources(
+                        content.lines().map(str::trim).filter(|line| !line.is_empty() && !line.starts_with('#')),
+                    )
+                } else {
+                    estimate::local_sources(cli.source.as_deref())
+                };
+                
+                let price = if cli.provider == Provider::Local { 0.0 } else { cli.price_per_minute };
+                return estimate::run(&files, price).await;
             }
             
+            let api_key = api_key_from_cli(&cli, &matches)?;
+            
+            // Translations are always English; a default language from .env doesn't apply to them
+            let language = if cli.translate {
+                if matches.value_source("language") == Some(ValueSource::CommandLine) {
+                    return Err(UsageError("--translate always produces English and can't be combined with --language".to_string()).into());
+                }
+                None
+            } else {
+                cli.language
+            };
+            
+            let prompt = match &cli.prompt_file {
+                Some(path) => Some(transcription::load_prompt_file(path)?),
+                None => cli.prompt,
+            };
+            
             // Create configuration
-            let config = Config::new(
-                cli.api_key,
-                cli.language,
-                cli.prompt,
+            let mut config = Config::new(
+                api_key,
+                language,
+                prompt,
                 cli.limit,
                 &cli.output_dir,
+                cli.provider,
+                cli.api_base,
             )?;
+            config.split_output_every = cli.split_output_every;
+            config.split_segments = cli.split_segments;
+            config.wrap = cli.wrap.filter(|_| !cli.no_wrap);
+            config.include_segments = cli.include_segments;
+            config.retry = RetryPolicy::new(
+                cli.retries,
+                cli.retry_status_codes.map_or_else(|| DEFAULT_RETRY_STATUS_CODES.to_vec(), |codes| codes.0),
+            ).map_err(usage)?;
+            if cli.adaptive_rate {
+                if !(cli.min_rate > 0.0 && cli.min_rate <= cli.initial_rate && cli.initial_rate <= cli.max_rate) {
+                    return Err(UsageError(format!(
+                        "--adaptive-rate needs 0 < --min-rate <= --initial-rate <= --max-rate, got {} <= {} <= {}",
+                        cli.min_rate, cli.initial_rate, cli.max_rate
+                    )).into());
+                }
+                config.adaptive_rate = Some(utils::RateLimits { initial: cli.initial_rate, min: cli.min_rate, max: cli.max_rate });
+            }
+            config.prepend_file = cli.prepend_file;
+            config.append_file = cli.append_file;
+            config.verify_integrity = cli.verify_integrity;
+            config.skip_format_check = cli.skip_format_check;
+            config.audio_stream = cli.audio_stream;
+            config.print_command = cli.print_command;
+            config.redact_pii = cli.redact_pii;
+            config.min_chunk_duration = cli.min_chunk_duration;
+            config.chunk_size_mb = cli.chunk_size;
+            config.chunk_overlap = cli.chunk_overlap;
+            config.detect_language_per_chunk = cli.detect_language_per_chunk;
+            config.auto_language = cli.auto_language;
+            config.stream = cli.stream;
+            config.diarize = cli.diarize;
+            config.poll_interval = cli.poll_interval;
+            config.carry_context = cli.carry_context;
+            config.chunk_concurrency = cli.chunk_concurrency as usize;
+            config.since = cli.since;
+            config.skip_existing = cli.skip_existing;
+            config.no_clobber = cli.no_clobber && !cli.force;
+            config.fail_on_empty = cli.fail_on_empty;
+            config.validate_output = cli.validate_output;
+            config.postprocess_command = cli.postprocess_command;
+            config.post_hook = cli.post_hook;
+            config.post_hook_on_error = cli.post_hook_on_error;
+            
+            // Fail now rather than midway through a multi-GB transcode
+            if let Some(temp_dir) = &cli.temp_dir {
+                utils::ensure_writable_dir(temp_dir, "Temp directory")?;
+            }
+            config.temp_dir = cli.temp_dir;
+            
+            // Racing providers share one key, so at most one of them can need it
+            if cli.fanout.iter().filter(|provider| provider.requires_api_key()).count() > 1 {
+                return Err(UsageError("--fanout can include only one provider that needs an API key".to_string()).into());
+            }
+            config.fanout = cli.fanout;
+            
+            // An explicit --model is a decision already made, so --auto-model only fills in for the default
+            let model_from_command_line = matches.value_source("model") == Some(ValueSource::CommandLine);
+            if cli.auto_model && model_from_command_line {
+                info!("--model {} given, so --auto-model is off", cli.model.as_deref().unwrap_or(""));
+            } else if cli.auto_model {
+                let policy = match &cli.model_policy {
+                    Some(path) => config::ModelPolicy::load(path)?,
+                    None => config::ModelPolicy::builtin(config.provider).ok_or_else(|| UsageError(format!(
+                        "{} has no built-in --auto-model policy; pass --model-policy",
+                        config.provider.label()
+                    )))?,
+                };
+                config.model_policy = Some(policy);
+            }
+            if let Some(model) = cli.model {
+                config.model = model;
+            }
+            let policy_models = config.model_policy.iter().flat_map(|policy| &policy.0).map(|rule| &rule.model);
+            for model in std::iter::once(&config.model).chain(policy_models) {
+                for provider in std::iter::once(&config.provider).chain(&config.fanout) {
+                    provider.validate_model(model).map_err(usage)?;
+                }
+            }
+            
+            // Find whisper.cpp and its model now rather than after the first download
+            let runs_whisper_cpp = config.provider == Provider::Local || config.fanout.contains(&Provider::Local);
+            if let Some(model) = cli.whisper_model.filter(|_| runs_whisper_cpp) {
+                whisper_cpp::check_model(&model)?;
+                config.whisper_binary = Some(whisper_cpp::find_binary(cli.whisper_binary.as_deref())?);
+                config.whisper_model = Some(model);
+                
+            }
+            config.timestamps = cli.timestamps;
+            config.granularities = cli.granularity;
+            if config.whisper_model.is_some() && config.wants_word_timings() {
+                return Err(UsageError("whisper.cpp only reports segment timings; use --timestamps segment or --granularity segment".to_string()).into());
+            }
+            config.translate = cli.translate;
+            config.use_cache = !cli.no_cache;
+            config.detect_language_only = cli.detect_language_only;
+            config.timeout = cli.timeout;
+            config.http_timeouts = utils::HttpTimeouts { response: cli.response_timeout, read: cli.read_timeout };
+            config.temperature = cli.temperature;
+            
+            // Transcoding is an optimization, so without ffmpeg the originals are sent as they are
+            if cli.transcode.is_some() && !utils::check_command("ffmpeg") {
+                warn!("--transcode needs ffmpeg on the PATH; uploading files unconverted");
+            } else {
+                config.transcode = cli.transcode;
+            }
+            
+            if config.translate && config.wants_word_timings() {
+                return Err(UsageError("Word timestamps aren't available for translations; use --timestamps segment or --granularity segment".to_string()).into());
+            }
+            config.output_formats = cli.response_format;
+            config.output_specs = cli.output_specs;
+            if cli.include_peaks {
+                let writes_json = config.output_formats.iter().chain(config.output_specs.iter().map(|spec| &spec.format))
+                    .any(|format| matches!(format, OutputFormat::Json | OutputFormat::PodscriptJson));
+                if !writes_json {
+                    return Err(UsageError("--include-peaks adds to JSON transcripts; add json or podscript-json to --response-format".to_string()).into());
+                }
+                if !utils::check_command("ffmpeg") {
+                    return Err(anyhow::anyhow!("--include-peaks reads the waveform with ffmpeg, which isn't on the PATH"));
+                }
+                config.peaks_per_second = Some(cli.peaks_per_second);
+            }
+            check_granularity(&config).map_err(usage)?;
+            // SRT has no comment syntax, so a header would show up as a caption
+            let writes_srt = config.output_formats.contains(&OutputFormat::Srt)
+                || config.output_specs.iter().any(|spec| spec.format == OutputFormat::Srt);
+            if (config.prepend_file.is_some() || config.append_file.is_some()) && writes_srt {
+                return Err(UsageError(
+                    "--prepend-file and --append-file can't be added to SRT files, which have no comments; use vtt (where they become NOTE blocks) or drop srt from --response-format and --output-spec".to_string()
+                ).into());
+            }
+            // Every file of a batch shares its directory, so one fixed name would be overwritten by each
+            if let Some(spec) = config.output_specs.iter().find(|spec| cli.batch.is_some() && !spec.path.contains("{name}")) {
+                return Err(UsageError(format!(
+                    "--output-spec {:?} would be overwritten by every file of the batch; put {{name}} in its path",
+                    spec.path
+                )).into());
+            }
+            if cli.max_cue_duration.map_or(false, |seconds| seconds <= 0.0) {
+                return Err(UsageError("--max-cue-duration must be more than 0 seconds".to_string()).into());
+            }
+            config.max_line_length = cli.max_line_length.map(usize::from);
+            config.max_cue_duration = cli.max_cue_duration;
+            config.check_model_capabilities().map_err(usage)?;
+            
+            // Progress goes to stderr; skip it when piped, logged to a file or under the dashboard
+            config.quiet = cli.quiet;
+            config.input_format = cli.input_format;
+            config.progress = !cli.quiet
+                && !cli.tui
+                && std::io::stdout().is_terminal()
+                && std::io::stderr().is_terminal();
+            
+            if config.needs_segments() && (cli.trim_fillers || config.postprocess_command.is_some()) {
+                warn!("--trim-fillers and --postprocess-command only change the transcript text, not the timestamps, SRT, VTT or JSON files");
+            }
+            // A phone number or name spans several word timings, which can't be redacted one by one
+            if config.redact_pii && config.wants_word_timings() {
+                return Err(UsageError("--redact-pii can't redact word timings; use --timestamps segment or --granularity segment".to_string()).into());
+            }
+            
+            // The name goes into a multipart header, so a path makes no sense
+            if let Some(name) = &cli.api_filename {
+                if name.is_empty() || name.contains(['/', '\\']) {
+                    return Err(UsageError(format!("--api-filename must be a plain file name, got {:?}", name)).into());
+                }
+                if config.uses_podscript() {
+                    warn!("--api-filename only applies to HTTP providers; podscript sends the real file name");
+                }
+            }
+            config.api_filename = cli.api_filename;
+            
+            if let Some(dir) = &cli.save_raw_response {
+                utils::ensure_writable_dir(dir, "Raw response directory")?;
+                if config.uses_podscript() {
+                    warn!("--save-raw-response only applies to HTTP providers; podscript doesn't expose the response");
+                }
+            }
+            config.save_raw_response = cli.save_raw_response;
+            config.max_output_bytes = cli.max_output_bytes;
+            config.max_download_bytes = cli.max_download_size * 1024 * 1024;
+            
+            if cli.no_temp_disk {
+                config.memory_limit = Some(cli.in_memory_limit * 1024 * 1024);
+            }
+            
+            // The built-in filler list is English-only
+            if cli.trim_fillers {
+                config.trim_fillers = Some(match &cli.filler_list {
+                    Some(path) => output::load_filler_list(path)?,
+                    None if config.language.as_deref().map_or(true, |lang| lang.starts_with("en")) => {
+                        output::DEFAULT_FILLERS.iter().map(|filler| filler.to_string()).collect()
+                    }
+                    None => return Err(UsageError(format!(
+                        "--trim-fillers only has a built-in English list; pass --filler-list for language {:?}",
+                        config.language.as_deref().unwrap_or("")
+                    )).into()),
+                });
+            }
+            
+            // Check the batch naming before anything is transcribed
+            let output_template = cli.output_template.as_deref()
+                .map(|template| batch::OutputTemplate::parse(template, config.language.as_deref()))
+                .transpose()
+                .map_err(usage)?;
+            
+            // Told once here rather than for every source that spills
+            if let (Some(_), Some(option)) = (config.memory_limit, config.needs_audio_file()) {
+                warn!("--no-temp-disk has no effect with {}, which reads the audio from a file", option);
+            }
             
             // Process sources
-            if let Some(source_url) = cli.source {
-                process_single_source(&source_url, &config).await?;
-            } else if let Some(sources_file) = cli.file {
-                process_sources_file(&sources_file, &config).await?;
+            let mut report = RunReport::new();
+            let processing = async {
+                if let Some(source_url) = &cli.source {
+                    let result = process_single_source(source_url, &config).await;
+                    report.record(source_url, &result);
+                    result.map(|_| ())
+                } else if let Some(sources_file) = &cli.file {
+                    process_sources_file(sources_file, &config, cli.tui, &mut report).await
+                } else if let Some(batch) = &cli.batch {
+                    batch::run(
+                        batch,
+                        &cli.suffix,
+                        output_template.as_ref(),
+                        cli.concurrency as usize,
+                        cli.resume.as_deref(),
+                        &config,
+                        &mut report,
+                    ).await
+                } else {
+                    Ok(())
+                }
+            };
+            
+            // Dropping the run on a signal aborts requests, kills podscript and removes temp dirs
+            let mut cancelled = None;
+            let result = tokio::select! {
+                result = processing => result,
+                (signal, exit_code) = shutdown_signal() => {
+                    cancelled = Some(exit_code);
+                    Err(anyhow::anyhow!("Cancelled by {}; removed temporary files of unfinished transcriptions", signal))
+                }
+            };
+            
+            // Record failures so they can be retried with --file
+            if let Some(errors_output) = &cli.errors_output {
+                match report.write_failed_sources(errors_output) {
+                    Ok(0) => {}
+                    Ok(count) => warn!("Wrote {} failed source(s) to {:?}", count, errors_output),
+                    Err(e) => error!("{}", e),
+                }
+            }
+            
+            // Notify the webhook whether or not the run succeeded
+            if let Some(webhook) = &cli.webhook {
+                report.finish(result.as_ref().err());
+                if let Err(e) = report::send_webhook(webhook, &report, &config.retry).await {
+                    error!("{}", e);
+                }
+            }
+            
+            // Printed for failed runs too, since those are the ones worth tuning
+            if let Some(metrics) = utils::http_metrics() {
+                eprintln!("HTTP: {}", metrics);
+            }
+            
+            // A cancelled run exits with the signal's conventional status rather than 1
+            if let (Some(exit_code), Err(e)) = (cancelled, &result) {
+                error!("{}", e);
+                std::process::exit(exit_code);
             }
+            
+            result?;
         }
     }
     
-    info!("{}", "Media transcription completed successfully!".green().bold());
+    // A streamed transcript owns stdout
+    if verbosity > Verbosity::Quiet && !cli.stream {
+        println!("{}", "Media transcription completed successfully!".green().bold());
+    }
+    Ok(())
+}
+
+/// Wait for Ctrl-C or SIGTERM, returning the signal's name and the exit status to use for it
+async fn shutdown_signal() -> (&'static str, i32) {
+    #[cfg(unix)]
+    {
+        use tokio::signal::unix::{signal, SignalKind};
+        
+        if let Ok(mut terminate) = signal(SignalKind::terminate()) {
+            return tokio::select! {
+                _ = tokio::signal::ctrl_c() => ("Ctrl-C", 130),
+                _ = terminate.recv() => ("SIGTERM", 143),
+            };
+        }
+    }
+    
+    let _ = tokio::signal::ctrl_c().await;
+    ("Ctrl-C", 130)
+}
+
+/// Pick the API key from the command line, in order: --api-key, --api-key-file, --api-key-ref,
+/// then the environment and .env files
+/// 
+/// OPENAI_API_KEY is no use to other providers, which have their own variable.
+fn api_key_from_cli(cli: &Cli, matches: &clap::ArgMatches) -> Result<Option<String>> {
+    let from_command_line = matches.value_source("api_key") == Some(ValueSource::CommandLine);
+    
+    Ok(match (&cli.api_key, &cli.api_key_file, &cli.api_key_ref) {
+        (Some(key), _, _) if from_command_line => Some(key.clone()),
+        (_, Some(path), _) => Some(config::read_api_key_file(path)?),
+        (_, None, Some(reference)) => Some(reference.clone()),
+        (key, None, None) => key.clone().filter(|_| cli.provider.api_key_env() == "OPENAI_API_KEY"),
+    })
+}
+
+/// Check that --granularity has timed output to go into, and segments wherever they're needed
+/// 
+/// A request for word timings alone returns no segments, which captions and
+/// the segment-based text options are built from.
+fn check_granularity(config: &Config) -> Result<()> {
+    if config.granularities.is_empty() {
+        return Ok(());
+    }
+    
+    if !config.needs_segments() {
+        return Err(anyhow::anyhow!(
+            "--granularity only applies to verbose_json output; add --response-format json, srt or vtt"
+        ));
+    }
+    
+    let needs_segment_timings = config.output_formats.iter().any(|format| matches!(format, OutputFormat::Srt | OutputFormat::Vtt))
+        || config.output_specs.iter().any(|spec| matches!(spec.format, OutputFormat::Srt | OutputFormat::Vtt) || spec.paragraphs)
+        || config.split_segments
+        || config.include_segments
+        || config.wrap == Some(TextWrap::Pauses)
+        || matches!(config.split_output_every, Some(SplitEvery::Duration(_)));
+    if needs_segment_timings && !config.granularities.contains(&TimestampGranularity::Segment) {
+        return Err(anyhow::anyhow!(
+            "SRT/VTT, paragraphs, --split-segments, --include-segments, --wrap pauses and --split-output-every durations are built from segments; use --granularity segment,word"
+        ));
+    }
+    
     Ok(())
 }
 
 /// Initialize the logger with appropriate verbosity
-fn init_logger(verbose: bool) {
+fn init_logger(verbosity: Verbosity) {
     env_logger::Builder::from_env(env_logger::Env::default().default_filter_or(
-        if verbose { "debug" } else { "info" },
+        verbosity.log_filter(),
     ))
     .format_timestamp(None)
     .init();
@@ -131,15 +1148,110 @@ fn print_welcome() {
     println!();
 }
 
+/// Remove the cache directory, reporting how much space was freed
+fn clear_cache() -> Result<()> {
+    let cache_dir = utils::cache_dir();
+    if !cache_dir.exists() {
+        println!("Cache is already empty: {}", cache_dir.display());
+        return Ok(());
+    }
+    
+    let size = utils::dir_size(&cache_dir);
+    std::fs::remove_dir_all(&cache_dir)
+        .map_err(|e| anyhow::anyhow!("Failed to clear cache {}: {}", cache_dir.display(), e))?;
+    
+    println!("Cleared {:.1} MB from {}", size as f64 / 1_048_576.0, cache_dir.display());
+    Ok(())
+}
+
 /// Configure API keys and settings
-async fn configure() -> Result<()> {
+/// 
+/// With --openai-api-key (and optionally --language) the settings are saved without
+/// prompting, for scripts; otherwise they're asked for on the terminal. Other
+/// lines of the settings file are kept. A --provider is saved as the default
+/// and decides which variable the key is saved under; --retry-status-codes is
+/// saved as the default list of statuses to retry.
+async fn configure(
+    api_key: Option<String>,
+    language: Option<String>,
+    provider: Option<Provider>,
+    retry_status_codes: Option<RetryStatusCodes>,
+) -> Result<()> {
     info!("Configuring API keys and settings...");
-    // Implementation will be added later
+    let path = std::path::Path::new(config::ENV_FILE);
+    let key_provider = provider.unwrap_or(Provider::Openai);
+    
+    let (api_key, language) = match api_key {
+        Some(api_key) => (Some(api_key), language),
+        None => {
+            if !std::io::stdin().is_terminal() {
+                return Err(UsageError(
+                    "No terminal to prompt on; pass --openai-api-key (and optionally --language) to configure non-interactively".to_string()
+                ).into());
+            }
+            
+            println!("Saving settings to {:?} (leave an answer blank to keep the current value)", path);
+            let api_key = prompt(&format!("{} API key: ", key_provider.label()), true)?;
+            let language = prompt("Default language code, e.g. en: ", false)?;
+            (Some(api_key).filter(|key| !key.is_empty()), Some(language).filter(|lang| !lang.is_empty()))
+        }
+    };
+    
+    if let Some(api_key) = &api_key {
+        if key_provider == Provider::Openai && !api_key.starts_with("sk-") {
+            return Err(anyhow::anyhow!("That doesn't look like an OpenAI API key (they start with sk-)"));
+        }
+    }
+    
+    let mut settings = Vec::new();
+    if let Some(api_key) = &api_key {
+        settings.push((key_provider.api_key_env(), api_key.as_str()));
+    }
+    if let Some(provider) = &provider {
+        settings.push(("PODSCRIPT_PROVIDER", provider.name()));
+    }
+    if let Some(language) = &language {
+        settings.push(("PODSCRIPT_LANGUAGE", language.as_str()));
+    }
+    let retry_status_codes = retry_status_codes.map(|codes| codes.to_string());
+    if let Some(codes) = &retry_status_codes {
+        settings.push((config::RETRY_STATUS_CODES_VAR, codes.as_str()));
+    }
+    
+    if settings.is_empty() {
+        println!("Nothing to change");
+        return Ok(());
+    }
+    
+    config::update_env_file(path, &settings)?;
+    println!("Saved {} to {:?}", settings.iter().map(|(key, _)| *key).collect::<Vec<_>>().join(", "), path);
     Ok(())
 }
 
-/// Process a single source (podcast, YouTube, or local file)
-async fn process_single_source(source_url: &str, config: &Config) -> Result<()> {
+/// Ask a question on the terminal and return the trimmed answer, optionally without echoing it
+fn prompt(question: &str, masked: bool) -> Result<String> {
+    print!("{}", question);
+    std::io::Write::flush(&mut std::io::stdout())?;
+    
+    // stty acts on the terminal it inherits as stdin
+    let hidden = masked
+        && cfg!(unix)
+        && std::process::Command::new("stty").arg("-echo").status().map_or(false, |status| status.success());
+    
+    let mut answer = String::new();
+    let result = std::io::stdin().read_line(&mut answer);
+    
+    if hidden {
+        let _ = std::process::Command::new("stty").arg("echo").status();
+        println!();
+    }
+    
+    result?;
+    Ok(answer.trim().to_string())
+}
+
+/// Process a single source (podcast, YouTube, or local file), returning the transcript files written
+async fn process_single_source(source_url: &str, config: &Config) -> Result<Vec<PathBuf>> {
     info!("Processing source: {}", source_url);
     
     // Check if it's a local file path
@@ -147,24 +1259,36 @@ async fn process_single_source(source_url: &str, config: &Config) -> Result<()>
         // Process local file
         info!("Detected local file: {}", source_url);
         let local_file_processor = LocalFileProcessor::new(config);
-        local_file_processor.process(source_url).await?;
+        local_file_processor.process(source_url).await
+    }
+    // Audio files linked directly, e.g. https://example.com/episode.mp3
+    else if RemoteFileProcessor::is_audio_url(source_url) {
+        let remote_file_processor = RemoteFileProcessor::new(config);
+        remote_file_processor.process(source_url).await
     }
     // Detect YouTube source
     else if source_url.contains("youtube.com") || source_url.contains("youtu.be") {
         // Process YouTube source
         let youtube_processor = YouTubeProcessor::new(config);
-        youtube_processor.process(source_url).await?;
+        youtube_processor.process(source_url).await
+    }
+    // Anything that isn't a URL was meant as a local file
+    else if !source_url.contains("://") {
+        Err(TranscriptionError::FileNotFound { file: PathBuf::from(source_url) }.into())
     } else {
         // Process podcast source
         let podcast_processor = PodcastProcessor::new(config);
-        podcast_processor.process(source_url).await?;
+        podcast_processor.process(source_url).await
     }
-    
-    Ok(())
 }
 
 /// Process a list of sources from a file
-async fn process_sources_file(sources_file: &PathBuf, config: &Config) -> Result<()> {
+async fn process_sources_file(
+    sources_file: &PathBuf,
+    config: &Config,
+    tui: bool,
+    report: &mut RunReport,
+) -> Result<()> {
     info!("Processing sources from file: {:?}", sources_file);
     
     // Read sources file
@@ -176,13 +1300,56 @@ async fn process_sources_file(sources_file: &PathBuf, config: &Config) -> Result
     
     info!("Found {} sources to process", sources.len());
     
-    // Process each source
+    // The dashboard replaces per-source logging, which would garble it
+    let log_level = log::max_level();
+    let mut dashboard = if tui && std::io::stderr().is_terminal() {
+        log::set_max_level(log::LevelFilter::Warn);
+        Some(Dashboard::new(&sources))
+    } else {
+        if tui {
+            warn!("--tui requires a terminal, falling back to plain logging");
+        }
+        None
+    };
+    
+    // Process each source, carrying on past failures
+    let mut first_failure = None;
     for (i, source) in sources.iter().enumerate() {
         info!("Processing source {}/{}: {}", i + 1, sources.len(), source);
-        if let Err(e) = process_single_source(source, config).await {
-            error!("Failed to process source {}: {}", source, e);
+        if let Some(dashboard) = &dashboard {
+            dashboard.start(i, source);
+        }
+        
+        let started = Instant::now();
+        let result = process_single_source(source, config).await;
+        report.record(source, &result);
+        
+        match (&mut dashboard, result) {
+            (Some(dashboard), Ok(_)) => dashboard.finish(i, source, started.elapsed()),
+            (Some(dashboard), Err(e)) => {
+                dashboard.fail(i, source, &e);
+                first_failure = first_failure.or(Some(e));
+            }
+            (None, Ok(_)) => {}
+            (None, Err(e)) => {
+                error!("Failed to process source {}: {}", source, e);
+                first_failure = first_failure.or(Some(e));
+            }
         }
     }
     
-    Ok(())
+    log::set_max_level(log_level);
+    
+    if report.totals.empty_transcripts > 0 {
+        warn!(
+            "{} of {} transcripts came back empty; see the warnings above",
+            report.totals.empty_transcripts, report.totals.transcripts
+        );
+    }
+    
+    // The run fails, with the exit status of the first failure, if any source did
+    match first_failure {
+        Some(e) => Err(e.context(format!("{} of {} sources failed", report.totals.failed, sources.len()))),
+        None => Ok(()),
+    }
 }
@@ -0,0 +1,86 @@
+use anyhow::Result;
+use serde::Deserialize;
+
+use crate::config::{self, ConfigError, Provider};
+use crate::utils;
+
+/// Body of an OpenAI-compatible `GET /models` response
+#[derive(Debug, Deserialize)]
+struct ModelList {
+    /// Every model the key can use, audio or not
+    data: Vec<ModelInfo>,
+}
+
+/// A single entry of a model list
+#[derive(Debug, Deserialize)]
+struct ModelInfo {
+    /// Model ID, as passed to --model
+    id: String,
+}
+
+/// Print the transcription models a provider offers, one per line
+/// 
+/// OpenAI (or the proxy at --api-base) is asked for its models, which also
+/// checks the API key. Groq's and AssemblyAI's models are fixed lists, and a whisper.cpp
+/// server uses whichever model it was started with.
+pub async fn run(provider: Provider, api_key: Option<String>, api_base: Option<String>) -> Result<()> {
+    let models = match provider {
+        Provider::Openai => fetch_models(provider, api_key, api_base).await?,
+        Provider::Groq | Provider::Assemblyai => provider.models().iter().map(|model| model.to_string()).collect(),
+        Provider::Local => {
+            println!("A whisper.cpp server transcribes with the model it was started with (whisper-server -m MODEL); --model is ignored");
+            return Ok(());
+        }
+    };
+    
+    for model in models {
+        println!("{}", model);
+    }
+    
+    Ok(())
+}
+
+/// Ask the provider's `/models` endpoint for its speech-to-text models
+async fn fetch_models(provider: Provider, api_key: Option<String>, api_base: Option<String>) -> Result<Vec<String>> {
+    let api_base = config::resolve_api_base(provider, api_base);
+    if config::is_azure_endpoint(&api_base) {
+        return Err(anyhow::anyhow!(
+            "Azure OpenAI deployments can't list models; the deployment in --api-base decides the model"
+        ));
+    }
+    
+    let api_key = config::resolve_api_key(provider, api_key)
+        .ok_or(ConfigError::ApiKeyNotFound(provider.api_key_env()))?;
+    let api_key = config::resolve_secret_reference(api_key)?;
+    
+    let url = format!("{}/models", api_base);
+    let response = utils::http_client()
+        .get(&url)
+        .bearer_auth(&api_key)
+        .send()
+        .await
+        .map_err(|e| anyhow::anyhow!("Couldn't reach {} ({}); check your network or --api-base", url, e))?;
+    
+    let status = response.status();
+    if status.as_u16() == 401 {
+        return Err(anyhow::anyhow!("{} rejected the API key; check {}", provider.label(), provider.api_key_env()));
+    }
+    if !status.is_success() {
+        return Err(anyhow::anyhow!("{} returned HTTP {}", url, status));
+    }
+    
+    // The list covers chat, embedding and image models too; keep the ones that transcribe
+    let list: ModelList = response.json().await?;
+    let mut models: Vec<String> = list.data
+        .into_iter()
+        .map(|model| model.id)
+        .filter(|id| id.contains("whisper") || id.contains("transcribe"))
+        .collect();
+    models.sort();
+    
+    if models.is_empty() {
+        return Err(anyhow::anyhow!("{} lists no speech-to-text models", url));
+    }
+    
+    Ok(models)
+}
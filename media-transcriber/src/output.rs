@@ -0,0 +1,998 @@
+use anyhow::Result;
+use log::{debug, info, warn};
+use regex::Regex;
+use serde::Serialize;
+use std::fs;
+use std::io::Write;
+use std::ops::Range;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+
+use crate::captions::Cue;
+use crate::config::SplitEvery;
+use crate::utils;
+
+/// Split a transcript into sentences, keeping the trailing punctuation
+/// 
+/// Whisper's plain text output is a run of sentences separated by whitespace,
+/// so a sentence ends at `.`, `!` or `?` followed by whitespace.
+fn split_sentences(text: &str) -> Vec<&str> {
+    let re = Regex::new(r"[.!?]+[\s]+").unwrap();
+    let mut sentences = Vec::new();
+    let mut start = 0;
+    
+    for m in re.find_iter(text) {
+        sentences.push(text[start..m.end()].trim());
+        start = m.end();
+    }
+    
+    if start < text.len() && !text[start..].trim().is_empty() {
+        sentences.push(text[start..].trim());
+    }
+    
+    sentences
+}
+
+/// Build the path of a numbered part file (e.g. transcript.txt -> transcript.part1.txt)
+pub fn part_path(output_file: &Path, part: usize) -> PathBuf {
+    let stem = output_file.file_stem()
+        .and_then(|stem| stem.to_str())
+        .unwrap_or("transcript");
+    
+    let file_name = match output_file.extension().and_then(|ext| ext.to_str()) {
+        Some(ext) => format!("{}.part{}.{}", stem, part, ext),
+        None => format!("{}.part{}", stem, part),
+    };
+    
+    output_file.with_file_name(file_name)
+}
+
+/// The numbered part files written for a file, in order
+pub fn part_files(output_file: &Path) -> Vec<PathBuf> {
+    part_files_from(output_file, 1)
+}
+
+/// Write `parts` as the numbered part files of `output_file`
+/// 
+/// Parts left over from an earlier, longer split are removed, so
+/// `part_files` only ever finds this split's parts.
+pub fn write_parts(output_file: &Path, parts: &[String]) -> Result<Vec<PathBuf>> {
+    let mut part_files = Vec::with_capacity(parts.len());
+    for (i, part) in parts.iter().enumerate() {
+        let path = part_path(output_file, i + 1);
+        utils::write_atomic(&path, part)?;
+        part_files.push(path);
+    }
+    
+    for stale in part_files_from(output_file, parts.len() + 1) {
+        fs::remove_file(&stale)?;
+    }
+    
+    Ok(part_files)
+}
+
+/// Existing part files numbered `first` and up
+fn part_files_from(output_file: &Path, first: usize) -> Vec<PathBuf> {
+    (first..)
+        .map(|part| part_path(output_file, part))
+        .take_while(|path| path.exists())
+        .collect()
+}
+
+/// Split a finished plain-text transcript into numbered part files
+/// 
+/// Used when there are no segment timings to split on. Each part holds
+/// roughly `words_per_part` words and always ends on a sentence boundary,
+/// so every part reads as a standalone transcript; paragraph breaks are
+/// kept. The full transcript is left in place.
+pub fn split_transcript(output_file: &Path, words_per_part: usize) -> Result<Vec<PathBuf>> {
+    if words_per_part == 0 {
+        return Err(anyhow::anyhow!("--split-output-every must be greater than zero"));
+    }
+    
+    let transcript = fs::read_to_string(output_file)?;
+    
+    // Group sentences into parts of at least `words_per_part` words
+    let mut parts: Vec<String> = Vec::new();
+    let mut current = String::new();
+    let mut current_words = 0;
+    
+    for paragraph in transcript.split("\n\n").map(str::trim).filter(|paragraph| !paragraph.is_empty()) {
+        for (i, sentence) in split_sentences(paragraph).into_iter().enumerate() {
+            if !current.is_empty() {
+                current.push_str(if i == 0 { "\n\n" } else { " " });
+            }
+            current.push_str(sentence);
+            current_words += sentence.split_whitespace().count();
+            
+            if current_words >= words_per_part {
+                parts.push(std::mem::take(&mut current));
+                current_words = 0;
+            }
+        }
+    }
+    
+    if !current.is_empty() {
+        parts.push(current);
+    }
+    
+    // A transcript that fits in a single part doesn't need splitting
+    if parts.len() <= 1 {
+        debug!("Transcript fits in a single part, not splitting: {:?}", output_file);
+        return write_parts(output_file, &[]);
+    }
+    
+    let part_files = write_parts(output_file, &parts)?;
+    info!("Split transcript into {} parts: {:?}", part_files.len(), output_file);
+    Ok(part_files)
+}
+
+/// Group segments into parts of about `every`, each ending on a segment boundary
+/// 
+/// A part closes with the segment that takes it to the word count or
+/// duration, so no segment is cut in two. Returns each part's range of
+/// segment indices.
+pub fn split_points(segments: &[Cue], every: SplitEvery) -> Vec<Range<usize>> {
+    let mut parts = Vec::new();
+    let mut start = 0;
+    let mut words = 0;
+    
+    for (i, segment) in segments.iter().enumerate() {
+        words += segment.text.split_whitespace().count();
+        let full = match every {
+            SplitEvery::Words(limit) => words >= limit,
+            SplitEvery::Duration(limit) => segment.end - segments[start].start >= limit.as_secs_f64(),
+        };
+        
+        if full {
+            parts.push(start..i + 1);
+            start = i + 1;
+            words = 0;
+        }
+    }
+    
+    if start < segments.len() {
+        parts.push(start..segments.len());
+    }
+    
+    parts
+}
+
+/// Which segments start a new paragraph in the transcript
+/// 
+/// A segment does when the transcript text has a line break before it (as
+/// diarized transcripts do between speakers), or when `pause` is given and
+/// the silence before it is at least that long, as with --wrap pauses.
+pub fn paragraph_starts(text: &str, segments: &[Cue], pause: Option<f64>) -> Vec<bool> {
+    let mut cursor = 0;
+    
+    segments.iter().enumerate().map(|(i, segment)| {
+        let mut starts = i > 0 && pause.is_some_and(|pause| segment.start - segments[i - 1].end >= pause);
+        
+        let segment_text = segment.text.trim();
+        if let Some(offset) = text[cursor..].find(segment_text).filter(|_| !segment_text.is_empty()) {
+            starts |= i > 0 && text[cursor..cursor + offset].contains('\n');
+            cursor += offset + segment_text.len();
+        }
+        
+        starts
+    }).collect()
+}
+
+/// Join segments into plain text, with a blank line where a paragraph starts
+pub fn join_segments(segments: &[Cue], paragraph_starts: &[bool]) -> String {
+    let mut text = String::new();
+    
+    for (i, segment) in segments.iter().enumerate() {
+        if i > 0 {
+            text.push_str(if paragraph_starts[i] { "\n\n" } else { " " });
+        }
+        text.push_str(segment.text.trim());
+    }
+    
+    text
+}
+
+/// Silence between segments that starts a new paragraph with --wrap pauses, in seconds
+pub const PARAGRAPH_PAUSE_SECS: f64 = 1.5;
+
+/// Rewrite a transcript from its segments, starting a new paragraph at each pause
+/// 
+/// Segments are joined with spaces, and a blank line goes wherever the gap
+/// to the next segment is at least `pause` seconds. A transcript without
+/// segments is left alone. Returns the number of paragraphs.
+pub fn paragraph_on_pauses(output_file: &Path, segments: &[Cue], pause: f64) -> Result<usize> {
+    if segments.is_empty() {
+        return Ok(0);
+    }
+    
+    let mut text = String::new();
+    let mut paragraphs = 1;
+    
+    for (i, segment) in segments.iter().enumerate() {
+        if i > 0 {
+            if segment.start - segments[i - 1].end >= pause {
+                text.push_str("\n\n");
+                paragraphs += 1;
+            } else {
+                text.push(' ');
+            }
+        }
+        text.push_str(segment.text.trim());
+    }
+    text.push('\n');
+    
+    utils::write_atomic(output_file, text)?;
+    debug!("Split {:?} into {} paragraphs", output_file, paragraphs);
+    Ok(paragraphs)
+}
+
+/// Append a "Segments" section listing each segment's start time and text
+/// 
+/// Times are `[mm:ss]`, or `[h:mm:ss]` past the first hour. The transcript
+/// above is left as it was, and nothing is added without segments.
+pub fn append_segment_listing(output_file: &Path, segments: &[Cue]) -> Result<()> {
+    if segments.is_empty() {
+        return Ok(());
+    }
+    
+    let mut transcript = fs::read_to_string(output_file)?;
+    let trimmed = transcript.trim_end().len();
+    transcript.truncate(trimmed);
+    transcript.push_str("\n\nSegments\n");
+    
+    for segment in segments {
+        let start = segment.start.max(0.0) as u64;
+        let time = if start >= 3600 {
+            format!("{}:{:02}:{:02}", start / 3600, start / 60 % 60, start % 60)
+        } else {
+            format!("{:02}:{:02}", start / 60, start % 60)
+        };
+        transcript.push_str(&format!("[{}] {}\n", time, segment.text.trim()));
+    }
+    
+    utils::write_atomic(output_file, transcript)?;
+    debug!("Appended {} segments to {:?}", segments.len(), output_file);
+    Ok(())
+}
+
+/// Wrap each line of a transcript to fit within `columns`, keeping existing line breaks
+/// 
+/// Words longer than the width get a line of their own rather than being split.
+pub fn wrap_lines(output_file: &Path, columns: usize) -> Result<()> {
+    let transcript = fs::read_to_string(output_file)?;
+    let mut wrapped = String::with_capacity(transcript.len());
+    
+    for line in transcript.lines() {
+        let mut width = 0;
+        
+        for word in line.split_whitespace() {
+            let word_width = word.chars().count();
+            if width > 0 && width + 1 + word_width > columns {
+                wrapped.push('\n');
+                width = 0;
+            } else if width > 0 {
+                wrapped.push(' ');
+                width += 1;
+            }
+            wrapped.push_str(word);
+            width += word_width;
+        }
+        wrapped.push('\n');
+    }
+    
+    utils::write_atomic(output_file, wrapped)?;
+    debug!("Wrapped {:?} at {} columns", output_file, columns);
+    Ok(())
+}
+
+/// One line of the --split-segments index
+#[derive(Serialize)]
+struct SegmentEntry<'a> {
+    /// Segment file name, relative to the index
+    file: String,
+    /// Start time in seconds
+    start: f64,
+    /// End time in seconds
+    end: f64,
+    /// Segment text
+    text: &'a str,
+}
+
+/// Directory holding the per-segment files of a transcript (e.g. transcript.segments/)
+pub fn segments_dir(output_file: &Path) -> PathBuf {
+    output_file.with_extension("segments")
+}
+
+/// Write each segment to its own numbered file, plus an index.json mapping files to times
+/// 
+/// Files are named by position and start time, e.g. `0001_00-00-12.txt`, so they
+/// sort in playback order. The directory is replaced on each run so no files
+/// are left over from an earlier transcription with more segments.
+pub fn write_segment_files(output_file: &Path, segments: &[Cue]) -> Result<PathBuf> {
+    let dir = segments_dir(output_file);
+    if dir.exists() {
+        fs::remove_dir_all(&dir)?;
+    }
+    fs::create_dir_all(&dir)?;
+    
+    let mut index = Vec::with_capacity(segments.len());
+    
+    for (i, segment) in segments.iter().enumerate() {
+        let start = segment.start.max(0.0) as u64;
+        let file = format!("{:04}_{:02}-{:02}-{:02}.txt", i + 1, start / 3600, start / 60 % 60, start % 60);
+        let text = segment.text.trim();
+        
+        utils::write_atomic(dir.join(&file), format!("{}\n", text))?;
+        index.push(SegmentEntry { file, start: segment.start, end: segment.end, text });
+    }
+    
+    utils::write_atomic(dir.join("index.json"), serde_json::to_string_pretty(&index)?)?;
+    
+    info!("Wrote {} segment files to {:?}", segments.len(), dir);
+    Ok(dir)
+}
+
+/// Values substituted into prepend/append templates
+pub struct TemplateVars<'a> {
+    /// Name of the transcribed audio file
+    pub filename: &'a str,
+    /// Transcription date (YYYY-MM-DD)
+    pub date: String,
+    /// Model used for transcription
+    pub model: &'a str,
+}
+
+/// Substitute `{filename}`, `{date}` and `{model}` in a template
+fn render_template(template: &str, vars: &TemplateVars) -> String {
+    template
+        .replace("{filename}", vars.filename)
+        .replace("{date}", &vars.date)
+        .replace("{model}", vars.model)
+}
+
+/// Header and footer text from --prepend-file and --append-file, with the template filled in
+pub struct Boilerplate {
+    pub header: Option<String>,
+    pub footer: Option<String>,
+}
+
+impl Boilerplate {
+    /// Read and render the header and footer files; None if neither is given
+    pub fn load(prepend_file: Option<&Path>, append_file: Option<&Path>, vars: &TemplateVars) -> Result<Option<Self>> {
+        if prepend_file.is_none() && append_file.is_none() {
+            return Ok(None);
+        }
+        
+        let render = |path: Option<&Path>, description: &str| -> Result<Option<String>> {
+            path.map(|path| {
+                let template = fs::read_to_string(path)
+                    .map_err(|e| anyhow::anyhow!("Failed to read {} file {:?}: {}", description, path, e))?;
+                Ok(render_template(&template, vars).trim().to_string())
+            }).transpose()
+        };
+        
+        Ok(Some(Self {
+            header: render(prepend_file, "prepend")?,
+            footer: render(append_file, "append")?,
+        }))
+    }
+}
+
+/// Wrap a finished plain-text transcript (or part) with the header and footer
+pub fn wrap_transcript(output_file: &Path, boilerplate: &Boilerplate) -> Result<()> {
+    let transcript = fs::read_to_string(output_file)?;
+    let mut wrapped = String::new();
+    
+    if let Some(header) = &boilerplate.header {
+        wrapped.push_str(header);
+        wrapped.push_str("\n\n");
+    }
+    
+    wrapped.push_str(transcript.trim());
+    
+    if let Some(footer) = &boilerplate.footer {
+        wrapped.push_str("\n\n");
+        wrapped.push_str(footer);
+    }
+    
+    wrapped.push('\n');
+    utils::write_atomic(output_file, wrapped)?;
+    
+    debug!("Wrapped transcript with prepend/append files: {:?}", output_file);
+    Ok(())
+}
+
+/// Add the header and footer to a WebVTT file as NOTE comment blocks
+/// 
+/// The header goes after the `WEBVTT` line and the footer after the last
+/// cue. A blank line would end a NOTE, so each paragraph gets its own, and
+/// `-->` (not allowed in a NOTE) is written as `->`.
+pub fn wrap_vtt(vtt_file: &Path, boilerplate: &Boilerplate) -> Result<()> {
+    let notes = |text: &str| -> String {
+        text.replace("-->", "->")
+            .split("\n\n")
+            .map(str::trim)
+            .filter(|paragraph| !paragraph.is_empty())
+            .map(|paragraph| format!("NOTE\n{}\n\n", paragraph))
+            .collect()
+    };
+    
+    let vtt = fs::read_to_string(vtt_file)?;
+    let cues = vtt.strip_prefix("WEBVTT").unwrap_or(&vtt).trim();
+    
+    let mut wrapped = String::from("WEBVTT\n\n");
+    if let Some(header) = &boilerplate.header {
+        wrapped.push_str(&notes(header));
+    }
+    if !cues.is_empty() {
+        wrapped.push_str(cues);
+        wrapped.push_str("\n\n");
+    }
+    if let Some(footer) = &boilerplate.footer {
+        wrapped.push_str(&notes(footer));
+    }
+    
+    utils::write_atomic(vtt_file, wrapped)?;
+    debug!("Added prepend/append files to {:?} as notes", vtt_file);
+    Ok(())
+}
+
+/// Marker appended to transcripts cut short by --max-output-bytes
+const TRUNCATION_MARKER: &str = "\n\n[Transcript truncated]\n";
+
+/// Truncate a transcript to at most `max_bytes`, cutting at a sentence boundary
+/// 
+/// Falls back to a word boundary when not even the first sentence fits. The
+/// marker counts toward the limit. Returns the original and truncated sizes,
+/// or `None` if the transcript already fits.
+pub fn truncate_transcript(output_file: &Path, max_bytes: usize) -> Result<Option<(usize, usize)>> {
+    let transcript = fs::read_to_string(output_file)?;
+    if transcript.len() <= max_bytes {
+        return Ok(None);
+    }
+    
+    let budget = max_bytes.saturating_sub(TRUNCATION_MARKER.len());
+    
+    // Keep whole sentences, preserving the original spacing between them
+    let mut end = 0;
+    for sentence in split_sentences(&transcript) {
+        let sentence_end = sentence.as_ptr() as usize - transcript.as_ptr() as usize + sentence.len();
+        if sentence_end > budget {
+            break;
+        }
+        end = sentence_end;
+    }
+    
+    if end == 0 {
+        end = budget;
+        while !transcript.is_char_boundary(end) {
+            end -= 1;
+        }
+        end = transcript[..end].rfind(char::is_whitespace).unwrap_or(end);
+    }
+    
+    let truncated = format!("{}{}", transcript[..end].trim_end(), TRUNCATION_MARKER);
+    utils::write_atomic(output_file, &truncated)?;
+    
+    Ok(Some((transcript.len(), truncated.len())))
+}
+
+/// Luhn checksum used to tell card numbers apart from other long digit runs
+fn passes_luhn(digits: &str) -> bool {
+    let digits: Vec<u32> = digits.chars().filter_map(|c| c.to_digit(10)).collect();
+    
+    let sum: u32 = digits
+        .iter()
+        .rev()
+        .enumerate()
+        .map(|(i, &d)| if i % 2 == 1 { if d * 2 > 9 { d * 2 - 9 } else { d * 2 } } else { d })
+        .sum();
+    
+    sum % 10 == 0
+}
+
+/// Structured PII and the tag each kind is replaced with
+/// 
+/// Order matters: card numbers and SSNs would otherwise be caught as phone numbers.
+const PII_PATTERNS: [(&str, &str); 4] = [
+    ("[EMAIL]", r"[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}"),
+    ("[CARD]", r"\b(?:\d[ -]?){12,18}\d\b"),
+    ("[SSN]", r"\b\d{3}-\d{2}-\d{4}\b"),
+    ("[PHONE]", r"(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)|\b\d{3})[ .-]?\d{3}[ .-]?\d{4}\b"),
+];
+
+/// Titles and introductions followed by a person's name, e.g. "Dr. Patel" or "my name is Jane Doe"
+const NAME_INTRODUCTION: &str =
+    r"\b(?:(?:Mr|Mrs|Ms|Miss|Dr|Prof)\.?|[Mm]y name is|I'm|I am|[Cc]all me|named)\s+([A-Z][a-z]+(?:[ -][A-Z][a-z]+)?)";
+
+/// Tag names are replaced with
+const NAME_TAG: &str = "[NAME]";
+
+/// Replaces PII in a transcript's text and in its segments alike
+/// 
+/// Structured PII (emails, Luhn-checked card numbers, US social security
+/// numbers and phone numbers) is found with patterns. Names are learned from
+/// titles and introductions anywhere in the transcript and then redacted
+/// wherever they appear, first or last name alone included, so a name
+/// introduced in one segment is also caught in the others. Redacting too much
+/// is preferred to missing a name.
+pub struct PiiRedactor {
+    patterns: Vec<(&'static str, Regex)>,
+    /// Learned names, longest first; None if the transcript introduces nobody
+    names: Option<Regex>,
+}
+
+impl PiiRedactor {
+    /// Compile the patterns and learn the names introduced in `transcript`
+    pub fn new(transcript: &str) -> Self {
+        let patterns = PII_PATTERNS.iter()
+            .map(|(tag, pattern)| (*tag, Regex::new(pattern).unwrap()))
+            .collect();
+        
+        // A full name and each of its parts
+        let mut names: Vec<String> = Vec::new();
+        for caps in Regex::new(NAME_INTRODUCTION).unwrap().captures_iter(transcript) {
+            let name = &caps[1];
+            names.push(name.to_string());
+            names.extend(name.split([' ', '-']).map(str::to_string));
+        }
+        names.sort_by(|a, b| b.len().cmp(&a.len()).then_with(|| a.cmp(b)));
+        names.dedup();
+        
+        let names = (!names.is_empty()).then(|| {
+            let alternatives: Vec<String> = names.iter().map(|name| regex::escape(name)).collect();
+            Regex::new(&format!(r"\b(?:{})\b", alternatives.join("|"))).unwrap()
+        });
+        
+        Self { patterns, names }
+    }
+    
+    /// Replace the PII in `text` with tags, returning the text and the number of redactions per tag
+    pub fn redact(&self, text: &str) -> (String, Vec<(&'static str, usize)>) {
+        let mut text = text.to_string();
+        let mut counts = Vec::new();
+        
+        for (tag, re) in &self.patterns {
+            let mut count = 0;
+            text = re
+                .replace_all(&text, |caps: &regex::Captures| {
+                    let matched = &caps[0];
+                    if *tag == "[CARD]" && !passes_luhn(matched) {
+                        return matched.to_string();
+                    }
+                    count += 1;
+                    tag.to_string()
+                })
+                .into_owned();
+            counts.push((*tag, count));
+        }
+        
+        let mut count = 0;
+        if let Some(names) = &self.names {
+            text = names
+                .replace_all(&text, |_: &regex::Captures| {
+                    count += 1;
+                    NAME_TAG
+                })
+                .into_owned();
+        }
+        counts.push((NAME_TAG, count));
+        
+        (text, counts)
+    }
+}
+
+/// Replace PII in a transcript file with placeholder tags
+/// 
+/// See `PiiRedactor` for what is detected. Returns the number of redactions
+/// per tag.
+pub fn redact_pii(output_file: &Path) -> Result<Vec<(&'static str, usize)>> {
+    let transcript = fs::read_to_string(output_file)?;
+    let (redacted, counts) = PiiRedactor::new(&transcript).redact(&transcript);
+    
+    utils::write_atomic(output_file, redacted)?;
+    Ok(counts)
+}
+
+/// Most words compared when removing text repeated across a chunk overlap
+const MAX_OVERLAP_WORDS: usize = 30;
+
+/// Remove the start of a chunk transcript that repeats the end of the previous one
+/// 
+/// Overlapping chunks transcribe the shared seconds twice. The longest run of
+/// words (ignoring case and punctuation) that ends `previous` and starts
+/// `next` is dropped from `next`; at least two words must match so a single
+/// common word like "the" isn't mistaken for overlap.
+pub fn strip_overlap<'a>(previous: &str, next: &'a str) -> &'a str {
+    let normalize = |word: &str| -> String {
+        word.chars().filter(|c| c.is_alphanumeric()).flat_map(char::to_lowercase).collect()
+    };
+    
+    let tail: Vec<String> = previous.split_whitespace().rev().take(MAX_OVERLAP_WORDS).map(normalize).collect();
+    let head: Vec<(usize, String)> = next
+        .split_whitespace()
+        .take(MAX_OVERLAP_WORDS)
+        .map(|word| (word.as_ptr() as usize - next.as_ptr() as usize + word.len(), normalize(word)))
+        .collect();
+    
+    // `tail` is reversed, so its first k entries are the last k words of `previous`
+    for k in (2..=tail.len().min(head.len())).rev() {
+        let matches = (0..k).all(|i| tail[k - 1 - i] == head[i].1);
+        if matches {
+            debug!("Removed {} words repeated across a chunk overlap", k);
+            return next[head[k - 1].0..].trim_start();
+        }
+    }
+    
+    next
+}
+
+/// English filler words removed by --trim-fillers
+/// 
+/// "like" is left out because it's usually a real word; add it with --filler-list.
+pub const DEFAULT_FILLERS: &[&str] = &["um", "umm", "uh", "uhh", "erm", "er", "ah", "hmm", "mm", "you know"];
+
+/// Read a filler list file: one word or phrase per line, `#` for comments
+pub fn load_filler_list(path: &Path) -> Result<Vec<String>> {
+    let content = fs::read_to_string(path)
+        .map_err(|e| anyhow::anyhow!("Failed to read filler list {:?}: {}", path, e))?;
+    
+    Ok(content
+        .lines()
+        .map(str::trim)
+        .filter(|line| !line.is_empty() && !line.starts_with('#'))
+        .map(str::to_string)
+        .collect())
+}
+
+/// Remove filler words from a transcript, returning how many were removed
+/// 
+/// Matching is case-insensitive and on whole words. A comma before or after
+/// the filler goes with it, so "it was, um, great" becomes "it was great".
+pub fn trim_fillers(output_file: &Path, fillers: &[String]) -> Result<usize> {
+    if fillers.is_empty() {
+        return Ok(0);
+    }
+    
+    // Longest first so phrases win over their own first word
+    let mut alternatives: Vec<String> = fillers.iter().map(|filler| regex::escape(filler)).collect();
+    alternatives.sort_by_key(|alternative| std::cmp::Reverse(alternative.len()));
+    let re = Regex::new(&format!(r"(?i)(?:,\s*)?\b(?:{})\b,?", alternatives.join("|")))?;
+    
+    let transcript = fs::read_to_string(output_file)?;
+    let count = re.find_iter(&transcript).count();
+    if count == 0 {
+        return Ok(0);
+    }
+    
+    // Tidy up the spacing left behind
+    let trimmed = re.replace_all(&transcript, "");
+    let trimmed = Regex::new(r"[ \t]{2,}").unwrap().replace_all(&trimmed, " ");
+    let trimmed = Regex::new(r"[ \t]+([.,!?])").unwrap().replace_all(&trimmed, "$1");
+    let trimmed: Vec<&str> = trimmed.lines().map(str::trim).collect();
+    
+    utils::write_atomic(output_file, trimmed.join("\n"))?;
+    Ok(count)
+}
+
+/// Pipe a transcript through a shell command and replace it with the command's output
+/// 
+/// The command gets the transcript on stdin and `PODSCRIPT_FORMAT`
+/// (currently always `text`) in its environment. A non-zero exit fails the
+/// transcript and leaves the file untouched.
+pub fn postprocess_transcript(output_file: &Path, command: &str) -> Result<()> {
+    let transcript = fs::read(output_file)?;
+    
+    let mut child = Command::new("sh")
+        .arg("-c")
+        .arg(command)
+        .env("PODSCRIPT_FORMAT", "text")
+        .env("PODSCRIPT_OUTPUT", output_file)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| anyhow::anyhow!("Failed to run postprocess command {:?}: {}", command, e))?;
+    
+    // Write stdin from another thread so a command that streams its output can't deadlock
+    let mut stdin = child.stdin.take().expect("stdin is piped");
+    let writer = std::thread::spawn(move || stdin.write_all(&transcript));
+    
+    let output = child.wait_with_output()?;
+    let write_result = writer.join().expect("stdin writer panicked");
+    
+    if !output.status.success() {
+        return Err(anyhow::anyhow!(
+            "Postprocess command {:?} failed ({}): {}",
+            command,
+            output.status,
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    
+    // A command that exits successfully without reading all its input is fine
+    if let Err(e) = write_result {
+        debug!("Postprocess command didn't read the whole transcript: {}", e);
+    }
+    
+    utils::write_atomic(output_file, output.stdout)?;
+    debug!("Postprocessed transcript with {:?}: {:?}", command, output_file);
+    Ok(())
+}
+
+/// Run a --post-hook or --post-hook-on-error command for a transcript
+/// 
+/// The command runs with `sh -c` and gets the transcript path as `$1` and in
+/// `PODSCRIPT_OUTPUT`. A finished transcript is also its stdin; after a
+/// failure stdin is empty and `PODSCRIPT_ERROR` holds the error. The exit
+/// status is logged, but a failing hook doesn't fail the transcript.
+pub async fn run_post_hook(command: &str, output_file: &Path, error: Option<&str>) {
+    let stdin = match error {
+        None => match fs::File::open(output_file) {
+            Ok(file) => Stdio::from(file),
+            Err(e) => {
+                warn!("Post hook not run for {:?}: {}", output_file, e);
+                return;
+            }
+        },
+        Some(_) => Stdio::null(),
+    };
+    
+    let mut hook = tokio::process::Command::new("sh");
+    hook.arg("-c")
+        .arg(command)
+        .arg("sh")
+        .arg(output_file)
+        .env("PODSCRIPT_OUTPUT", output_file)
+        .env("PODSCRIPT_STATUS", if error.is_some() { "failed" } else { "ok" })
+        .stdin(stdin)
+        .kill_on_drop(true);
+    
+    if let Some(error) = error {
+        hook.env("PODSCRIPT_ERROR", error);
+    }
+    
+    debug!("Running post hook {:?} for {:?}", command, output_file);
+    match hook.status().await {
+        Ok(status) if status.success() => info!("Post hook {:?} succeeded for {:?}", command, output_file),
+        Ok(status) => warn!(
+            "Post hook {:?} for {:?} exited with {}",
+            command,
+            output_file,
+            status.code().map_or_else(|| "a signal".to_string(), |code| format!("status {}", code))
+        ),
+        Err(e) => warn!("Failed to run post hook {:?}: {}", command, e),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+    
+    fn cue(start: f64, end: f64, text: &str) -> Cue {
+        Cue { start, end, text: text.to_string() }
+    }
+    
+    #[test]
+    fn splits_by_words_on_segment_boundaries() {
+        let segments = [cue(0.0, 2.0, "One two three."), cue(2.0, 4.0, "Four five."), cue(4.0, 6.0, "Six."), cue(6.0, 8.0, "Seven eight.")];
+        assert_eq!(split_points(&segments, SplitEvery::Words(4)), [0..2, 2..4]);
+        assert_eq!(split_points(&segments, SplitEvery::Words(100)), [0..4]);
+    }
+    
+    #[test]
+    fn splits_by_duration_on_segment_boundaries() {
+        let segments = [cue(0.0, 250.0, "a"), cue(250.0, 610.0, "b"), cue(610.0, 700.0, "c"), cue(700.0, 1300.0, "d")];
+        assert_eq!(split_points(&segments, SplitEvery::Duration(Duration::from_secs(600))), [0..2, 2..4]);
+    }
+    
+    #[test]
+    fn keeps_paragraph_breaks_when_joining_segments() {
+        let segments = [cue(0.0, 1.0, "Speaker A: Hi."), cue(1.0, 2.0, "How are you?"), cue(2.0, 3.0, "Speaker B: Fine."), cue(6.0, 7.0, "Later.")];
+        let text = "Speaker A: Hi. How are you?\n\nSpeaker B: Fine. Later.";
+        
+        let starts = paragraph_starts(text, &segments, None);
+        assert_eq!(starts, [false, false, true, false]);
+        assert_eq!(join_segments(&segments[1..], &starts[1..]), "How are you?\n\nSpeaker B: Fine. Later.");
+        
+        let starts = paragraph_starts(text, &segments, Some(PARAGRAPH_PAUSE_SECS));
+        assert_eq!(starts, [false, false, true, true]);
+    }
+    
+    fn boilerplate() -> Boilerplate {
+        Boilerplate {
+            header: Some("Episode 12\n\nRecorded --> edited".to_string()),
+            footer: Some("Thanks for listening".to_string()),
+        }
+    }
+    
+    #[test]
+    fn wraps_text_with_the_header_and_footer() {
+        let dir = tempfile::tempdir().unwrap();
+        let transcript = dir.path().join("transcript.txt");
+        fs::write(&transcript, "Hello there.\n").unwrap();
+        
+        wrap_transcript(&transcript, &boilerplate()).unwrap();
+        assert_eq!(
+            fs::read_to_string(&transcript).unwrap(),
+            "Episode 12\n\nRecorded --> edited\n\nHello there.\n\nThanks for listening\n"
+        );
+    }
+    
+    #[test]
+    fn wraps_vtt_with_note_blocks() {
+        let dir = tempfile::tempdir().unwrap();
+        let vtt = dir.path().join("transcript.vtt");
+        fs::write(&vtt, "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello there.\n\n").unwrap();
+        
+        wrap_vtt(&vtt, &boilerplate()).unwrap();
+        assert_eq!(
+            fs::read_to_string(&vtt).unwrap(),
+            "WEBVTT\n\nNOTE\nEpisode 12\n\nNOTE\nRecorded -> edited\n\n\
+             00:00:00.000 --> 00:00:01.000\nHello there.\n\nNOTE\nThanks for listening\n\n"
+        );
+    }
+    
+    #[test]
+    fn fills_in_the_template() {
+        let dir = tempfile::tempdir().unwrap();
+        let header = dir.path().join("header.txt");
+        fs::write(&header, "{filename} on {date} with {model}\n").unwrap();
+        let vars = TemplateVars { filename: "ep12.mp3", date: "2024-05-01".to_string(), model: "whisper-1" };
+        
+        let boilerplate = Boilerplate::load(Some(&header), None, &vars).unwrap().unwrap();
+        assert_eq!(boilerplate.header.as_deref(), Some("ep12.mp3 on 2024-05-01 with whisper-1"));
+        assert!(boilerplate.footer.is_none());
+        assert!(Boilerplate::load(None, None, &vars).unwrap().is_none());
+    }
+    
+    #[test]
+    fn splits_plain_text_by_sentences_keeping_paragraphs() {
+        let dir = tempfile::tempdir().unwrap();
+        let transcript = dir.path().join("transcript.txt");
+        fs::write(&transcript, "One two. Three four.\n\nFive six. Seven eight.").unwrap();
+        
+        let parts = split_transcript(&transcript, 3).unwrap();
+        
+        assert_eq!(parts, [part_path(&transcript, 1), part_path(&transcript, 2)]);
+        assert_eq!(fs::read_to_string(&parts[0]).unwrap(), "One two. Three four.");
+        assert_eq!(fs::read_to_string(&parts[1]).unwrap(), "Five six. Seven eight.");
+        
+        // A part can span a paragraph break
+        fs::write(&transcript, "One two.\n\nThree four. Five six.").unwrap();
+        let parts = split_transcript(&transcript, 3).unwrap();
+        assert_eq!(fs::read_to_string(&parts[0]).unwrap(), "One two.\n\nThree four.");
+        
+        // Splitting into fewer parts removes the old ones
+        split_transcript(&transcript, 100).unwrap();
+        assert!(part_files(&transcript).is_empty());
+    }
+    
+    fn redacted(text: &str) -> String {
+        PiiRedactor::new(text).redact(text).0
+    }
+    
+    #[test]
+    fn redacts_emails() {
+        assert_eq!(redacted("Write to jane.doe+news@example.co.uk today"), "Write to [EMAIL] today");
+    }
+    
+    #[test]
+    fn redacts_phone_numbers() {
+        assert_eq!(redacted("Call 555-123-4567 now"), "Call [PHONE] now");
+        assert_eq!(redacted("Call (555) 123-4567 now"), "Call [PHONE] now");
+        assert_eq!(redacted("Call +1 555.123.4567 now"), "Call [PHONE] now");
+    }
+    
+    #[test]
+    fn redacts_card_numbers_with_spaces_or_dashes() {
+        assert_eq!(redacted("Card 4111 1111 1111 1111 expires"), "Card [CARD] expires");
+        assert_eq!(redacted("Card 4111-1111-1111-1111 expires"), "Card [CARD] expires");
+        assert_eq!(redacted("Card 4111111111111111 expires"), "Card [CARD] expires");
+    }
+    
+    #[test]
+    fn redacts_social_security_numbers() {
+        assert_eq!(redacted("SSN 123-45-6789."), "SSN [SSN].");
+    }
+    
+    #[test]
+    fn leaves_numbers_that_are_not_pii() {
+        // Fails the Luhn check, so it isn't a card; too long to be a phone number
+        assert_eq!(redacted("Order 4111 1111 1111 1112 shipped"), "Order 4111 1111 1111 1112 shipped");
+        assert_eq!(redacted("In 2024 we sold 1,500 units at 10.30 each"), "In 2024 we sold 1,500 units at 10.30 each");
+        assert_eq!(redacted("Meet at noon on 12-05"), "Meet at noon on 12-05");
+    }
+    
+    #[test]
+    fn redacts_introduced_names_everywhere() {
+        let text = "Hi, my name is Jane Doe. Dr. Patel agreed. Thanks, Jane. Doe out.";
+        assert_eq!(redacted(text), "Hi, my name is [NAME]. Dr. [NAME] agreed. Thanks, [NAME]. [NAME] out.");
+    }
+    
+    #[test]
+    fn names_learned_from_the_transcript_apply_to_segments() {
+        let redactor = PiiRedactor::new("I'm Priya. Welcome back.");
+        let (segment, counts) = redactor.redact("Priya here, email priya@example.com");
+        
+        assert_eq!(segment, "[NAME] here, email [EMAIL]");
+        assert_eq!(counts, [("[EMAIL]", 1), ("[CARD]", 0), ("[SSN]", 0), ("[PHONE]", 0), ("[NAME]", 1)]);
+    }
+    
+    #[test]
+    fn leaves_capitalized_words_without_an_introduction() {
+        assert_eq!(redacted("Monday in Paris was great."), "Monday in Paris was great.");
+    }
+    
+    /// Run `trim_fillers` on `text`, returning the count and the result
+    fn trimmed(text: &str, fillers: &[&str]) -> (usize, String) {
+        let dir = tempfile::tempdir().unwrap();
+        let transcript = dir.path().join("transcript.txt");
+        fs::write(&transcript, text).unwrap();
+        
+        let fillers: Vec<String> = fillers.iter().map(|filler| filler.to_string()).collect();
+        let count = trim_fillers(&transcript, &fillers).unwrap();
+        (count, fs::read_to_string(&transcript).unwrap())
+    }
+    
+    #[test]
+    fn trims_fillers_with_their_commas() {
+        assert_eq!(
+            trimmed("So it was, um, great. You know, I like it.", &["um", "you know"]),
+            (2, "So it was great. I like it.".to_string())
+        );
+    }
+    
+    #[test]
+    fn trims_fillers_case_insensitively_and_on_whole_words() {
+        assert_eq!(
+            trimmed("UM, the umbrella, uh, stayed home.\nUh yes.", &["um", "uh"]),
+            (3, "the umbrella stayed home.\nyes.".to_string())
+        );
+    }
+    
+    #[test]
+    fn prefers_the_longest_filler_phrase() {
+        assert_eq!(trimmed("It was, I mean, fine.", &["I", "I mean"]), (1, "It was fine.".to_string()));
+    }
+    
+    #[test]
+    fn leaves_a_transcript_without_fillers_untouched() {
+        assert_eq!(trimmed("Hmm,  spacing  kept.", &["um"]), (0, "Hmm,  spacing  kept.".to_string()));
+    }
+    
+    /// Run `truncate_transcript` on `text`, returning the sizes and the result
+    fn truncated(text: &str, max_bytes: usize) -> (Option<(usize, usize)>, String) {
+        let dir = tempfile::tempdir().unwrap();
+        let transcript = dir.path().join("transcript.txt");
+        fs::write(&transcript, text).unwrap();
+        
+        let sizes = truncate_transcript(&transcript, max_bytes).unwrap();
+        (sizes, fs::read_to_string(&transcript).unwrap())
+    }
+    
+    #[test]
+    fn leaves_a_transcript_that_fits() {
+        let text = "First one here. Second one here.";
+        assert_eq!(truncated(text, text.len()), (None, text.to_string()));
+    }
+    
+    #[test]
+    fn truncates_at_a_sentence_boundary() {
+        let (sizes, text) = truncated("First one here. Second one here. Third one here.", TRUNCATION_MARKER.len() + 22);
+        assert_eq!(text, format!("First one here.{}", TRUNCATION_MARKER));
+        assert_eq!(sizes, Some((48, text.len())));
+    }
+    
+    #[test]
+    fn truncates_at_a_word_boundary_when_no_sentence_fits() {
+        let (_, text) = truncated("A very long opening sentence without an end", TRUNCATION_MARKER.len() + 12);
+        assert_eq!(text, format!("A very long{}", TRUNCATION_MARKER));
+        
+        // The cut lands inside the two bytes of "è"
+        let (_, text) = truncated("Café crème brûlée et chocolat chaud", TRUNCATION_MARKER.len() + 9);
+        assert_eq!(text, format!("Café{}", TRUNCATION_MARKER));
+    }
+}
@@ -0,0 +1,76 @@
+use anyhow::Result;
+use log::info;
+use std::path::{Path, PathBuf};
+
+use crate::captions::{self, Cue, InputEncoding};
+use crate::utils;
+
+/// Silence between cues (in seconds) that starts a new paragraph
+const PARAGRAPH_GAP_SECONDS: f64 = 2.0;
+
+/// Cues per paragraph when the captions have no pauses long enough to split on
+const MAX_CUES_PER_PARAGRAPH: usize = 12;
+
+/// Convert an SRT or VTT file to plain text without cue numbers or timestamps
+/// 
+/// The output is written next to the input as `<name>.plain.txt` unless an
+/// output path is given.
+pub fn run(input: &Path, output: Option<PathBuf>, encoding: Option<InputEncoding>) -> Result<PathBuf> {
+    let content = captions::read_caption_file(input, encoding)?;
+    let extension = input.extension().and_then(|ext| ext.to_str()).unwrap_or("").to_lowercase();
+    
+    let cues = match extension.as_str() {
+        "srt" => captions::parse_srt(&content)?,
+        "vtt" => captions::parse_vtt(&content)?,
+        _ => return Err(anyhow::anyhow!("Unsupported caption format {:?}: expected .srt or .vtt", input)),
+    };
+    
+    let paragraphs = merge_into_paragraphs(&cues);
+    
+    let output = output.unwrap_or_else(|| {
+        let stem = input.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+        input.with_file_name(format!("{}.plain.txt", stem))
+    });
+    utils::write_atomic(&output, paragraphs.join("\n\n") + "\n")?;
+    
+    info!("Wrote {} paragraphs (from {} cues) to {:?}", paragraphs.len(), cues.len(), output);
+    Ok(output)
+}
+
+/// Join cue text into paragraphs, breaking at pauses between cues
+fn merge_into_paragraphs(cues: &[Cue]) -> Vec<String> {
+    let mut paragraphs = Vec::new();
+    let mut current: Vec<&str> = Vec::new();
+    let mut cues_in_paragraph = 0;
+    let mut previous_end: Option<f64> = None;
+    
+    for cue in cues {
+        // Break on a long pause, or at a sentence end once the paragraph gets long
+        let pause = previous_end.map_or(false, |end| cue.start - end >= PARAGRAPH_GAP_SECONDS);
+        let long = cues_in_paragraph >= MAX_CUES_PER_PARAGRAPH
+            && current.last().map_or(false, |line| line.ends_with(['.', '?', '!']));
+        
+        if (pause || long) && !current.is_empty() {
+            paragraphs.push(current.join(" "));
+            current.clear();
+            cues_in_paragraph = 0;
+        }
+        
+        for line in cue.text.lines().map(str::trim).filter(|line| !line.is_empty()) {
+            // Rolling captions (e.g. YouTube) repeat the previous line at the top of each cue
+            if current.last() == Some(&line) {
+                continue;
+            }
+            current.push(line);
+        }
+        
+        cues_in_paragraph += 1;
+        previous_end = Some(cue.end);
+    }
+    
+    if !current.is_empty() {
+        paragraphs.push(current.join(" "));
+    }
+    
+    paragraphs
+}
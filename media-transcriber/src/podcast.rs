@@ -4,7 +4,6 @@ use log::{debug, error, info, warn};
 use rss::{Channel, Item};
 use std::fs;
 use std::path::{Path, PathBuf};
-use tempfile::tempdir;
 
 use crate::config::Config;
 use crate::transcription::TranscriptionService;
@@ -28,8 +27,8 @@ impl<'a> PodcastProcessor<'a> {
         Self { config }
     }
     
-    /// Process a podcast RSS feed
-    pub async fn process(&self, feed_url: &str) -> Result<()> {
+    /// Process a podcast RSS feed, returning the transcript files written
+    pub async fn process(&self, feed_url: &str) -> Result<Vec<PathBuf>> {
         info!("Processing podcast feed: {}", feed_url);
         
         // Download and parse RSS feed
@@ -49,6 +48,15 @@ impl<'a> PodcastProcessor<'a> {
             b.pub_date.unwrap_or_default().cmp(&a.pub_date.unwrap_or_default())
         });
         
+        // Drop episodes published before --since (undated ones can't be checked)
+        if let Some(since) = self.config.since {
+            let total = episodes.len();
+            episodes.retain(|episode| {
+                episode.pub_date.map_or(false, |date| date.date_naive() >= since)
+            });
+            info!("{} of {} episodes published on or after {}", episodes.len(), total, since);
+        }
+        
         // Apply limit if specified
         if let Some(limit) = self.config.limit {
             if episodes.len() > limit {
@@ -59,6 +67,7 @@ impl<'a> PodcastProcessor<'a> {
         
         // Process each episode
         let transcription_service = TranscriptionService::new(self.config);
+        let mut outputs = Vec::new();
         
         for (i, episode) in episodes.iter().enumerate() {
             info!("Processing episode {}/{}: {}", i + 1, episodes.len(), episode.title);
@@ -67,18 +76,32 @@ impl<'a> PodcastProcessor<'a> {
             let episode_dir = podcast_dir.join(utils::sanitize_filename(&episode.title));
             fs::create_dir_all(&episode_dir)?;
             
-            // Download audio file
-            let temp_dir = tempdir()?;
-            let audio_file = temp_dir.path().join("episode.mp3");
+            // Skip episodes finished by an earlier run
+            let transcript_file = episode_dir.join("transcript.txt");
+            if self.config.skip_existing && transcript_file.exists() {
+                info!("Skipping already transcribed episode: {}", episode.title);
+                continue;
+            }
+            
+            // Download audio file, with the same size and content checks as --source URLs
+            let download = utils::download_audio(
+                &episode.audio_url,
+                self.config.temp_dir.as_deref(),
+                "episode.mp3",
+                self.config.max_download_bytes,
+                None,
+                &self.config.retry,
+            ).await;
             
-            match utils::download_file(&episode.audio_url, &audio_file).await {
-                Ok(_) => {
+            match download {
+                Ok(spooled) => {
                     // Transcribe audio file
-                    let transcript_file = episode_dir.join("transcript.txt");
-                    
-                    if let Err(e) = transcription_service.transcribe_file(&audio_file, &transcript_file).await {
-                        error!("Failed to transcribe episode: {}", e);
-                        continue;
+                    match transcription_service.transcribe_file(&spooled.path_or("episode.mp3"), &transcript_file).await {
+                        Ok(files) => outputs.extend(files),
+                        Err(e) => {
+                            error!("Failed to transcribe episode: {}", e);
+                            continue;
+                        }
                     }
                     
                     info!("Successfully transcribed episode: {}", episode.title);
@@ -90,7 +113,7 @@ impl<'a> PodcastProcessor<'a> {
             }
         }
         
-        Ok(())
+        Ok(outputs)
     }
     
     /// Download and parse RSS feed
@@ -98,8 +121,7 @@ impl<'a> PodcastProcessor<'a> {
         debug!("Downloading RSS feed: {}", feed_url);
         
         // Download feed
-        let response = reqwest::get(feed_url).await?;
-        let content = response.bytes().await?;
+        let content = utils::fetch_bytes(feed_url, &self.config.retry).await?;
         
         // Parse feed
         let channel = Channel::read_from(&content[..])?;
@@ -154,7 +176,7 @@ impl<'a> PodcastProcessor<'a> {
             }
         }
         
-        info!("Extracted {} episodes", episodes.len());
+        debug!("Extracted {} episodes", episodes.len());
         Ok(episodes)
     }
     
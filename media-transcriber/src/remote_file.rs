@@ -0,0 +1,91 @@
+use anyhow::Result;
+use log::info;
+use std::fs;
+use std::path::PathBuf;
+
+use crate::config::Config;
+use crate::transcription::TranscriptionService;
+use crate::utils::{self, AUDIO_EXTENSIONS};
+
+/// Processor for audio files linked directly by URL
+pub struct RemoteFileProcessor<'a> {
+    /// Configuration for the processor
+    config: &'a Config,
+}
+
+impl<'a> RemoteFileProcessor<'a> {
+    /// Create a new remote file processor
+    pub fn new(config: &'a Config) -> Self {
+        Self { config }
+    }
+    
+    /// Process an audio file URL
+    /// 
+    /// This function:
+    /// 1. Downloads the file to a temporary directory (or memory, with --no-temp-disk), within --max-download-size
+    /// 2. Creates an output directory named after the file
+    /// 3. Transcribes the file using the Whisper API
+    /// 
+    /// The download is removed afterwards, whether or not transcription succeeded.
+    /// Returns the transcript files written.
+    pub async fn process(&self, url: &str) -> Result<Vec<PathBuf>> {
+        let file_name = Self::file_name(url).unwrap_or_else(|| "audio.mp3".to_string());
+        let extension = file_name.rsplit_once('.').map(|(_, ext)| ext.to_lowercase()).unwrap_or_default();
+        let file_stem = file_name.rsplit_once('.').map(|(stem, _)| stem).unwrap_or(&file_name);
+        
+        // Keep the real extension, which the API uses to recognize the format
+        let download_name = format!("remote_audio.{}", extension);
+        
+        info!("Downloading audio from {}", url);
+        let mut download = utils::download_audio(
+            url,
+            self.config.temp_dir.as_deref(),
+            &download_name,
+            self.config.max_download_bytes,
+            self.config.memory_spool_limit(),
+            &self.config.retry,
+        ).await?;
+        let audio_file = download.path_or(&download_name);
+        
+        // Create output directory
+        let output_dir = self.config.output_dir
+            .join("remote_files")
+            .join(utils::sanitize_filename(file_stem));
+        fs::create_dir_all(&output_dir)?;
+        
+        // Save file info
+        let file_info = format!(
+            "URL: {}\nSize: {} bytes\nTranscribed: {}",
+            url,
+            download.len(),
+            chrono::Local::now().to_rfc3339()
+        );
+        fs::write(output_dir.join("file_info.txt"), file_info)?;
+        
+        let transcript_path = output_dir.join("transcript.txt");
+        let transcription_service = TranscriptionService::new(self.config).holding(download.take_memory());
+        
+        info!("Transcribing remote file: {}", url);
+        transcription_service.transcribe_file(&audio_file, &transcript_path).await
+    }
+    
+    /// Check if a source is an http(s) URL of an audio file, judging by its extension
+    pub fn is_audio_url(source: &str) -> bool {
+        if !source.starts_with("http://") && !source.starts_with("https://") {
+            return false;
+        }
+        
+        Self::file_name(source)
+            .and_then(|name| name.rsplit_once('.').map(|(_, ext)| ext.to_lowercase()))
+            .map_or(false, |ext| AUDIO_EXTENSIONS.contains(&ext.as_str()))
+    }
+    
+    /// Last path segment of a URL, ignoring the query and fragment
+    fn file_name(source: &str) -> Option<String> {
+        let url = url::Url::parse(source).ok()?;
+        url.path_segments()?
+            .last()
+            .filter(|name| !name.is_empty())
+            .map(str::to_string)
+    }
+}
@@ -0,0 +1,186 @@
+use anyhow::Result;
+use chrono::Local;
+use log::{info, warn};
+use serde::Serialize;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::{Duration, Instant};
+
+use crate::transcription;
+use crate::utils::{self, RetryPolicy};
+
+/// Summary of a transcription run, used for notifications
+#[derive(Debug, Serialize)]
+pub struct RunReport {
+    /// "completed" if the run finished (even with failed sources), "failed" if it was aborted
+    pub status: &'static str,
+    /// When the run started (RFC 3339)
+    pub started_at: String,
+    /// When the run finished (RFC 3339)
+    pub finished_at: Option<String>,
+    /// Wall-clock duration of the run in seconds
+    pub duration_seconds: f64,
+    /// Aggregate counts
+    pub totals: RunTotals,
+    /// Result for each source, in processing order
+    pub sources: Vec<SourceResult>,
+    /// Error that aborted the run, if any
+    pub error: Option<String>,
+    /// Requests, retries, bytes and latency of the run's HTTP traffic (--metrics only)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub http: Option<utils::HttpMetrics>,
+    /// Monotonic start time for measuring the duration
+    #[serde(skip)]
+    started: Instant,
+}
+
+/// Aggregate counts for a run
+#[derive(Debug, Default, Serialize)]
+pub struct RunTotals {
+    /// Number of sources processed
+    pub sources: usize,
+    /// Sources processed without error
+    pub succeeded: usize,
+    /// Sources that failed
+    pub failed: usize,
+    /// Transcript files written
+    pub transcripts: usize,
+    /// Transcripts that came back empty (included in `transcripts`)
+    pub empty_transcripts: usize,
+}
+
+/// Result of processing a single source
+#[derive(Debug, Serialize)]
+pub struct SourceResult {
+    /// Source URL or path
+    pub source: String,
+    /// "succeeded" or "failed"
+    pub status: &'static str,
+    /// Transcript files written for the source
+    pub outputs: Vec<PathBuf>,
+    /// Why the source failed
+    pub error: Option<String>,
+    /// How many of the outputs are empty transcripts
+    pub empty_transcripts: usize,
+    /// Detected language and audio duration of each output, where the provider reported them
+    pub details: Vec<transcription::TranscriptDetails>,
+}
+
+impl RunReport {
+    /// Start a new report
+    pub fn new() -> Self {
+        Self {
+            status: "running",
+            started_at: Local::now().to_rfc3339(),
+            finished_at: None,
+            duration_seconds: 0.0,
+            totals: RunTotals::default(),
+            sources: Vec::new(),
+            error: None,
+            http: None,
+            started: Instant::now(),
+        }
+    }
+    
+    /// Record the result of processing a source
+    pub fn record(&mut self, source: &str, result: &Result<Vec<PathBuf>>) {
+        self.totals.sources += 1;
+        
+        let empty_transcripts = match result {
+            Ok(outputs) => outputs.iter().filter(|output| transcription::is_empty_transcript(output)).count(),
+            Err(_) => 0,
+        };
+        self.totals.empty_transcripts += empty_transcripts;
+        
+        let details = match result {
+            Ok(outputs) => outputs.iter().filter_map(|output| transcription::transcript_details(output)).collect(),
+            Err(_) => Vec::new(),
+        };
+        
+        let entry = match result {
+            Ok(outputs) => {
+                self.totals.succeeded += 1;
+                self.totals.transcripts += outputs.len();
+                SourceResult {
+                    source: source.to_string(),
+                    status: "succeeded",
+                    outputs: outputs.clone(),
+                    error: None,
+                    empty_transcripts,
+                    details,
+                }
+            }
+            Err(e) => {
+                self.totals.failed += 1;
+                SourceResult {
+                    source: source.to_string(),
+                    status: "failed",
+                    outputs: Vec::new(),
+                    error: Some(format!("{:#}", e)),
+                    empty_transcripts,
+                    details,
+                }
+            }
+        };
+        
+        self.sources.push(entry);
+    }
+    
+    /// Mark the run as finished, with the error that aborted it if any
+    pub fn finish(&mut self, error: Option<&anyhow::Error>) {
+        self.status = if error.is_some() { "failed" } else { "completed" };
+        self.duration_seconds = self.started.elapsed().as_secs_f64();
+        self.finished_at = Some(Local::now().to_rfc3339());
+        self.error = error.map(|e| format!("{:#}", e));
+        self.http = utils::http_metrics();
+    }
+    
+    /// Write the failed sources to a file that can be re-run with --file, returning how many were written
+    pub fn write_failed_sources(&self, path: &Path) -> Result<usize> {
+        let mut content = String::new();
+        let mut count = 0;
+        
+        for entry in self.sources.iter().filter(|entry| entry.status == "failed") {
+            // The reason goes on a comment line, which --file skips
+            let reason = entry.error.as_deref().unwrap_or("unknown error");
+            content.push_str(&format!("# {}\n", reason.lines().collect::<Vec<_>>().join(" ")));
+            content.push_str(&format!("{}\n", entry.source));
+            count += 1;
+        }
+        
+        fs::write(path, content)
+            .map_err(|e| anyhow::anyhow!("Failed to write errors file {:?}: {}", path, e))?;
+        
+        Ok(count)
+    }
+}
+
+/// POST the run report as JSON to a webhook, retrying transient failures
+pub async fn send_webhook(url: &str, report: &RunReport, retry: &RetryPolicy) -> Result<()> {
+    let client = utils::http_client();
+    let mut attempt = 0;
+    
+    loop {
+        let result = client
+            .post(url)
+            .json(report)
+            .send()
+            .await
+            .and_then(|response| response.error_for_status());
+        
+        match result {
+            Ok(_) => {
+                info!("Sent completion notification to webhook");
+                return Ok(());
+            }
+            Err(e) if attempt < retry.retries && utils::is_retryable_error(&e, &retry.status_codes) => {
+                attempt += 1;
+                let delay = Duration::from_secs(1 << attempt.min(5));
+                warn!("Webhook delivery failed ({}), retrying in {:?} (attempt {}/{})", e, delay, attempt, retry.retries);
+                utils::record_http_retry();
+                tokio::time::sleep(delay).await;
+            }
+            Err(e) => return Err(anyhow::anyhow!("Webhook delivery failed: {}", e)),
+        }
+    }
+}
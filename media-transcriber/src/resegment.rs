@@ -0,0 +1,143 @@
+use anyhow::Result;
+use log::info;
+use std::path::{Path, PathBuf};
+
+use crate::captions::{self, Cue, InputEncoding, Word};
+use crate::utils;
+
+/// Abbreviations whose trailing period doesn't end a sentence
+const ABBREVIATIONS: &[&str] = &[
+    "dr.", "mr.", "mrs.", "ms.", "prof.", "st.", "jr.", "sr.", "vs.", "etc.",
+    "e.g.", "i.e.", "inc.", "ltd.", "co.", "no.", "approx.", "u.s.", "u.k.",
+];
+
+/// A transcript token with estimated timing
+struct TimedToken {
+    text: String,
+    start: f64,
+    end: f64,
+}
+
+/// Re-split a verbose_json or SRT transcript into sentence-level segments
+/// 
+/// The output has the same format as the input and is written next to it as
+/// `<name>.sentences.<ext>` unless an output path is given.
+pub fn run(input: &Path, output: Option<PathBuf>, encoding: Option<InputEncoding>) -> Result<PathBuf> {
+    let content = captions::read_caption_file(input, encoding)?;
+    let extension = input.extension().and_then(|ext| ext.to_str()).unwrap_or("").to_lowercase();
+    
+    // Load segments, plus word timings when the transcript has them
+    let (segments, words) = match extension.as_str() {
+        "json" => {
+            let transcript = captions::parse_verbose_json(&content)?;
+            (transcript.segments, transcript.words)
+        }
+        "srt" => (captions::parse_srt(&content)?, Vec::new()),
+        _ => return Err(anyhow::anyhow!("Unsupported transcript format {:?}: expected .json (verbose_json) or .srt", input)),
+    };
+    
+    let sentences = split_into_sentences(&segments, &words);
+    
+    let rendered = if extension == "json" {
+        captions::write_segments_json(&sentences)?
+    } else {
+        captions::write_srt(&sentences)
+    };
+    
+    let output = output.unwrap_or_else(|| {
+        let stem = input.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+        input.with_file_name(format!("{}.sentences.{}", stem, extension))
+    });
+    utils::write_atomic(&output, rendered)?;
+    
+    info!("Wrote {} sentence segments (from {} segments) to {:?}", sentences.len(), segments.len(), output);
+    Ok(output)
+}
+
+/// Assign a start and end time to every token in the transcript
+/// 
+/// Word timestamps are used when they line up one-to-one with the tokens;
+/// otherwise times are interpolated across each segment by character offset.
+fn timed_tokens(segments: &[Cue], words: &[Word]) -> Vec<TimedToken> {
+    let tokens: Vec<(usize, &str)> = segments
+        .iter()
+        .enumerate()
+        .flat_map(|(i, segment)| segment.text.split_whitespace().map(move |token| (i, token)))
+        .collect();
+    
+    if !words.is_empty() && words.len() == tokens.len() {
+        return tokens
+            .iter()
+            .zip(words)
+            .map(|((_, token), word)| TimedToken { text: token.to_string(), start: word.start, end: word.end })
+            .collect();
+    }
+    
+    let mut timed = Vec::with_capacity(tokens.len());
+    
+    for segment in segments {
+        let text_len = segment.text.trim().chars().count().max(1) as f64;
+        let duration = segment.end - segment.start;
+        let mut offset = 0usize;
+        
+        for token in segment.text.split_whitespace() {
+            let token_len = token.chars().count();
+            timed.push(TimedToken {
+                text: token.to_string(),
+                start: segment.start + duration * offset as f64 / text_len,
+                end: segment.start + duration * (offset + token_len) as f64 / text_len,
+            });
+            offset += token_len + 1;
+        }
+    }
+    
+    timed
+}
+
+/// Check whether a token ends a sentence
+pub fn ends_sentence(token: &str) -> bool {
+    let trimmed = token.trim_end_matches(['"', '\'', ')', ']']);
+    
+    if !trimmed.ends_with(['.', '!', '?']) {
+        return false;
+    }
+    
+    // Abbreviations and single-letter initials ("J.") don't end sentences
+    let lower = trimmed.to_lowercase();
+    if ABBREVIATIONS.contains(&lower.as_str()) {
+        return false;
+    }
+    
+    !(trimmed.len() == 2 && trimmed.chars().next().map_or(false, |c| c.is_alphabetic()) && trimmed.ends_with('.'))
+}
+
+/// Group timed tokens into one cue per sentence
+fn split_into_sentences(segments: &[Cue], words: &[Word]) -> Vec<Cue> {
+    let mut sentences = Vec::new();
+    let mut current: Vec<TimedToken> = Vec::new();
+    
+    for token in timed_tokens(segments, words) {
+        let is_end = ends_sentence(&token.text);
+        current.push(token);
+        
+        if is_end {
+            sentences.push(tokens_to_cue(&current));
+            current.clear();
+        }
+    }
+    
+    if !current.is_empty() {
+        sentences.push(tokens_to_cue(&current));
+    }
+    
+    sentences
+}
+
+/// Build a cue spanning a run of tokens
+fn tokens_to_cue(tokens: &[TimedToken]) -> Cue {
+    Cue {
+        start: tokens.first().map_or(0.0, |token| token.start),
+        end: tokens.last().map_or(0.0, |token| token.end),
+        text: tokens.iter().map(|token| token.text.as_str()).collect::<Vec<_>>().join(" "),
+    }
+}
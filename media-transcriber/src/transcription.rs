@@ -1,17 +1,106 @@
 use anyhow::Result;
-use log::{debug, info};
+use futures::stream::{self, StreamExt};
+use log::{debug, info, warn};
+use reqwest::multipart::{Form, Part};
 use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
 use std::fs;
+use std::io::Write;
 use std::path::{Path, PathBuf};
-use std::process::Command;
-use tempfile::tempdir;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex, OnceLock};
+use std::collections::{HashMap, HashSet};
+use std::future::Future;
+use std::time::Duration;
+use thiserror::Error;
+use tokio::process::Command;
 
-use crate::config::Config;
-use crate::utils;
+use crate::assemblyai::AssemblyAi;
+use crate::captions::{self, Cue, Word};
+use crate::config::{self, AudioStreamSelection, Config, ModelPolicy, OutputFormat, Provider, SplitEvery, TextWrap};
+use crate::output;
+use crate::utils::{self, AudioStream};
+use crate::whisper_cpp::WhisperCpp;
+
+/// podscript binary that performs the transcription requests
+pub const PODSCRIPT_BINARY: &str = "../podscript";
+
+/// Longest chunk when splitting large files, in seconds (shorter if --chunk-size requires)
+const CHUNK_DURATION: u64 = 1000;
+
+/// Longest prompt built by --carry-context, in characters (about Whisper's 224-token window)
+const PROMPT_MAX_CHARS: usize = 800;
+
+/// Transcript files that came back empty in this run, for the run summary
+fn empty_transcripts() -> &'static Mutex<HashSet<PathBuf>> {
+    static EMPTY: OnceLock<Mutex<HashSet<PathBuf>>> = OnceLock::new();
+    EMPTY.get_or_init(|| Mutex::new(HashSet::new()))
+}
+
+/// Check whether a transcript written in this run came back empty
+pub fn is_empty_transcript(output_file: &Path) -> bool {
+    empty_transcripts().lock().unwrap().contains(output_file)
+}
+
+/// Detected language and audio duration of a transcript, as reported by the provider
+#[derive(Debug, Clone, Serialize)]
+pub struct TranscriptDetails {
+    /// Transcript file
+    pub path: PathBuf,
+    /// Language the provider detected (or was told)
+    pub language: Option<String>,
+    /// Length of the transcribed audio in seconds
+    pub duration_seconds: Option<f64>,
+}
+
+/// Details of the transcripts written in this run, for the run report
+fn transcript_details_registry() -> &'static Mutex<HashMap<PathBuf, TranscriptDetails>> {
+    static DETAILS: OnceLock<Mutex<HashMap<PathBuf, TranscriptDetails>>> = OnceLock::new();
+    DETAILS.get_or_init(|| Mutex::new(HashMap::new()))
+}
+
+/// Detected language and duration of a transcript written in this run, if the provider reported them
+pub fn transcript_details(output_file: &Path) -> Option<TranscriptDetails> {
+    transcript_details_registry().lock().unwrap().get(output_file).cloned()
+}
+
+/// Remember and log what the provider reported about a transcript's audio
+fn record_transcript_details(output_file: &Path, language: Option<String>, duration_seconds: Option<f64>) {
+    if language.is_none() && duration_seconds.is_none() {
+        return;
+    }
+    
+    debug!(
+        "{:?}: language {}, {} of audio",
+        output_file,
+        language.as_deref().unwrap_or("unknown"),
+        duration_seconds.map_or("unknown length".to_string(), |seconds| format!("{:.1}s", seconds))
+    );
+    
+    transcript_details_registry().lock().unwrap().insert(
+        output_file.to_path_buf(),
+        TranscriptDetails { path: output_file.to_path_buf(), language, duration_seconds },
+    );
+}
+
+/// Transcription failures that callers may want to tell apart
+#[derive(Debug, Error)]
+pub enum TranscriptionError {
+    #[error("Audio file does not exist: {file:?}")]
+    FileNotFound { file: PathBuf },
+    #[error("Transcription failed with HTTP {status}: {message}")]
+    Api { status: reqwest::StatusCode, message: String },
+    #[error("Transcription of {file:?} timed out after {seconds} seconds; raise --timeout if the provider is just slow")]
+    Timeout { file: PathBuf, seconds: u64 },
+}
 
 /// Transcription service for audio files
 pub struct TranscriptionService<'a> {
     config: &'a Config,
+    /// Client for provider requests; the shared one outside tests
+    client: reqwest::Client,
+    /// The audio itself, when --no-temp-disk kept it in memory instead of writing the file
+    held_audio: Option<Arc<[u8]>>,
 }
 
 /// Transcription request parameters
@@ -23,129 +112,2257 @@ struct TranscriptionRequest {
     prompt: Option<String>,
     response_format: String,
     temperature: f32,
+    /// Timing detail requested with verbose_json (empty for none)
+    timestamp_granularities: Vec<String>,
+    /// API endpoint under the base URL: "transcriptions", or "translations" for English output
+    endpoint: &'static str,
+    /// Contents of `file` when they're held in memory and the file doesn't exist
+    #[serde(skip)]
+    audio: Option<Arc<[u8]>>,
 }
 
-/// Transcription response
-#[derive(Debug, Deserialize)]
+/// Transcription response, also written as the --timestamps file
+#[derive(Debug, Deserialize, Serialize)]
 struct TranscriptionResponse {
     text: String,
+    /// Detected language (verbose_json only)
+    #[serde(default)]
+    language: Option<String>,
+    /// Length of the audio in seconds (verbose_json only)
+    #[serde(default)]
+    duration: Option<f64>,
+    /// Segment timings (verbose_json only)
+    #[serde(default)]
+    segments: Vec<Cue>,
+    /// Word timings (verbose_json with word granularity only)
+    #[serde(default)]
+    words: Vec<Word>,
+    /// Waveform of the audio, added with --include-peaks
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    peaks: Option<utils::Peaks>,
+}
+
+/// Seconds of audio --auto-language detects the language on
+const AUTO_LANGUAGE_SAMPLE_SECS: u64 = 60;
+
+/// Version of the podscript-json schema; bumped when a field changes meaning or is removed
+const ENVELOPE_SCHEMA_VERSION: u32 = 1;
+
+/// Self-describing transcript written with --response-format podscript-json
+/// 
+/// Unlike Whisper's verbose_json, the fields don't depend on the provider or
+/// endpoint, so batch outputs can be indexed without knowing how they were made.
+#[derive(Debug, Serialize)]
+struct TranscriptEnvelope<'a> {
+    schema_version: u32,
+    /// File name of the transcribed audio
+    source: &'a str,
+    /// When the transcript was written (RFC 3339), or $SOURCE_DATE_EPOCH
+    created_at: String,
+    /// Provider name, or "fanout" when several were raced
+    provider: &'static str,
+    model: &'a str,
+    /// Detected (or requested) language
+    language: Option<&'a str>,
+    /// Length of the audio in seconds
+    duration: Option<f64>,
+    parameters: EnvelopeParameters<'a>,
+    text: &'a str,
+    segments: &'a [Cue],
+    words: &'a [Word],
+    /// Waveform of the audio (--include-peaks only)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    peaks: Option<&'a utils::Peaks>,
+}
+
+/// Request options recorded in a podscript-json envelope
+#[derive(Debug, Serialize)]
+struct EnvelopeParameters<'a> {
+    /// Language requested with --language (None to detect)
+    language: Option<&'a str>,
+    prompt: Option<&'a str>,
+    translate: bool,
+    /// Finest timestamp granularity requested with --timestamps or --granularity
+    timestamps: Option<&'static str>,
 }
 
 impl<'a> TranscriptionService<'a> {
     /// Create a new transcription service
     pub fn new(config: &'a Config) -> Self {
-        Self { config }
+        Self { config, client: utils::http_client(), held_audio: None }
+    }
+    
+    /// Transcribe `audio` from memory rather than reading the file it's named after
+    /// 
+    /// Only for sources that `Config::needs_audio_file` lets skip the disk; the
+    /// name still supplies the upload's file name and format.
+    pub fn holding(mut self, audio: Option<Vec<u8>>) -> Self {
+        self.held_audio = audio.map(Arc::from);
+        self
     }
     
     /// Transcribe an audio file
-    pub async fn transcribe_file(&self, audio_file: &Path, output_file: &Path) -> Result<()> {
+    /// 
+    /// Returns the transcript files written, which is normally just
+    /// `output_file` but is one file per stream for multi-track input.
+    /// --post-hook runs on each of them afterwards, or --post-hook-on-error
+    /// if transcription failed; a hook's own failure is only reported.
+    pub async fn transcribe_file(&self, audio_file: &Path, output_file: &Path) -> Result<Vec<PathBuf>> {
+        let result = self.transcribe_file_outputs(audio_file, output_file).await;
+        
+        match &result {
+            Ok(outputs) => {
+                if let Some(command) = &self.config.post_hook {
+                    for output in outputs {
+                        output::run_post_hook(command, output, None).await;
+                    }
+                }
+            }
+            Err(e) => {
+                if let Some(command) = &self.config.post_hook_on_error {
+                    output::run_post_hook(command, output_file, Some(&format!("{:#}", e))).await;
+                }
+            }
+        }
+        
+        result
+    }
+    
+    /// Transcribe an audio file and post-process the transcripts
+    async fn transcribe_file_outputs(&self, audio_file: &Path, output_file: &Path) -> Result<Vec<PathBuf>> {
         info!("Transcribing audio file: {:?}", audio_file);
         
         // Check if file exists
-        if !audio_file.exists() {
-            return Err(anyhow::anyhow!("Audio file does not exist: {:?}", audio_file));
+        if self.held_audio.is_none() && !audio_file.exists() {
+            return Err(TranscriptionError::FileNotFound { file: audio_file.to_path_buf() }.into());
+        }
+        
+        // Checked before anything is uploaded, so a refused source costs nothing
+        if self.config.no_clobber && output_file.exists() {
+            return Err(anyhow::anyhow!(
+                "Transcript {:?} already exists; pass --force to overwrite it or --skip-existing to skip such sources",
+                output_file
+            ));
+        }
+        
+        // Wrong file types would otherwise fail with a cryptic API 400 (--transcode converts whatever ffmpeg reads)
+        if !self.config.skip_format_check && self.config.transcode.is_none() {
+            match &self.held_audio {
+                Some(audio) => utils::check_audio_bytes(audio_file, audio)?,
+                None => utils::check_audio_format(audio_file)?,
+            }
+        }
+        
+        // Catch truncated or corrupt files before paying for an API call, and DRM-protected
+        // ones, which would otherwise fail with a cryptic decode error
+        if self.config.verify_integrity {
+            utils::check_drm(audio_file).await?;
+            utils::verify_audio_integrity(audio_file).await?;
+        }
+        
+        // --auto-model picks this file's model, then it's transcribed as if --model had named it
+        if let Some(policy) = &self.config.model_policy {
+            let config = Config { model: self.choose_model(policy, audio_file).await, model_policy: None, ..self.config.clone() };
+            let service = TranscriptionService { config: &config, client: self.client.clone(), held_audio: self.held_audio.clone() };
+            return service.transcribe_checked(audio_file, output_file).await;
+        }
+        
+        self.transcribe_checked(audio_file, output_file).await
+    }
+    
+    /// Transcribe a file that passed the checks in `transcribe_file_outputs`
+    async fn transcribe_checked(&self, audio_file: &Path, output_file: &Path) -> Result<Vec<PathBuf>> {
+        // Only identify the language from the opening seconds, writing no transcript
+        if let Some(seconds) = self.config.detect_language_only {
+            self.detect_language(audio_file, output_file, seconds).await?;
+            return Ok(Vec::new());
+        }
+        
+        // Multi-track recordings can be transcribed one stream at a time
+        if let Some(selection) = &self.config.audio_stream {
+            let streams = utils::probe_audio_streams(audio_file).await?;
+            
+            if streams.len() > 1 {
+                return self.transcribe_streams(audio_file, output_file, selection, &streams).await;
+            }
+        }
+        
+        self.transcribe_audio(audio_file, output_file).await?;
+        self.finish_output(audio_file, output_file)?;
+        
+        Ok(vec![output_file.to_path_buf()])
+    }
+    
+    /// Model --auto-model picks for a file, with the reason printed to stderr
+    /// 
+    /// Rules whose model can't serve the requested options (such as captions
+    /// from a model without verbose_json) are passed over. With no match the
+    /// configured model is kept.
+    async fn choose_model(&self, policy: &ModelPolicy, audio_file: &Path) -> String {
+        let duration = utils::get_audio_duration(audio_file).await.ok();
+        let language = self.config.language.as_deref();
+        let usable = |model: &str| {
+            let candidate = Config { model: model.to_string(), ..self.config.clone() };
+            candidate.check_model_capabilities().is_ok()
+        };
+        
+        let (model, reason) = match policy.choose(duration, language, usable) {
+            Some(rule) => (rule.model.clone(), format!("matched \"{}\"", rule.text)),
+            None => (self.config.model.clone(), "no rule matched".to_string()),
+        };
+        
+        if !self.config.quiet {
+            eprintln!(
+                "Using model {} for {} ({}, language {}): {}",
+                model,
+                audio_file.display(),
+                duration.map_or_else(|| "unknown length".to_string(), |seconds| format!("{:.0}s of audio", seconds)),
+                language.unwrap_or("not given"),
+                reason
+            );
+        }
+        model
+    }
+    
+    /// Transcribe the selected audio streams of a multi-track file into separate transcripts
+    /// 
+    /// Each transcript is named after its stream (e.g. transcript.stream2.txt, or
+    /// transcript.stream2_Alice.txt when the stream has a title).
+    async fn transcribe_streams(
+        &self,
+        audio_file: &Path,
+        output_file: &Path,
+        selection: &AudioStreamSelection,
+        streams: &[AudioStream],
+    ) -> Result<Vec<PathBuf>> {
+        let selected: Vec<&AudioStream> = match selection {
+            AudioStreamSelection::All => streams.iter().collect(),
+            AudioStreamSelection::Index(index) => {
+                let stream = streams.get(index - 1).ok_or_else(|| anyhow::anyhow!(
+                    "Audio stream {} requested but {:?} only has {} audio streams",
+                    index, audio_file, streams.len()
+                ))?;
+                vec![stream]
+            }
+        };
+        
+        let temp_dir = utils::create_temp_dir(self.config.temp_dir.as_deref())?;
+        let mut outputs = Vec::with_capacity(selected.len());
+        
+        for stream in selected {
+            let number = stream.position + 1;
+            info!("Transcribing audio stream {}/{} of {:?}", number, streams.len(), audio_file);
+            
+            // Extract the stream into its own file
+            let stream_file = temp_dir.path().join(format!("stream_{}.mp3", number));
+            utils::extract_audio_stream(audio_file, stream.position, &stream_file).await?;
+            
+            // Name the transcript after the stream index and title
+            let label = match stream.title.as_deref().map(utils::sanitize_filename) {
+                Some(title) if !title.is_empty() => format!("stream{}_{}", number, title),
+                _ => format!("stream{}", number),
+            };
+            let stem = output_file.file_stem().and_then(|stem| stem.to_str()).unwrap_or("transcript");
+            let stream_output = output_file.with_file_name(format!("{}.{}.txt", stem, label));
+            
+            self.transcribe_audio(&stream_file, &stream_output).await?;
+            self.finish_output(audio_file, &stream_output)?;
+            outputs.push(stream_output);
+        }
+        
+        Ok(outputs)
+    }
+    
+    /// Transcribe the first `seconds` of a file and print the language the provider detects
+    /// 
+    /// The sample always goes straight to the provider's API, since podscript
+    /// doesn't report the language.
+    async fn detect_language(&self, audio_file: &Path, output_file: &Path, seconds: u64) -> Result<()> {
+        let language = self.sample_language(audio_file, seconds).await?;
+        record_transcript_details(output_file, Some(language.clone()), utils::get_audio_duration(audio_file).await.ok());
+        
+        // Downloads live in temp dirs, so name those by where their transcript would have gone
+        let source = if utils::is_temp_file(audio_file) { output_file.parent().unwrap_or(output_file) } else { audio_file };
+        println!("{}\t{}", language, source.display());
+        
+        Ok(())
+    }
+    
+    /// Language the provider detects in the first `seconds` of a file
+    async fn sample_language(&self, audio_file: &Path, seconds: u64) -> Result<String> {
+        let temp_dir = utils::create_temp_dir(self.config.temp_dir.as_deref())?;
+        let sample_file = temp_dir.path().join("sample.mp3");
+        let sample = utils::ChunkSpec { index: 0, start: 0.0, duration: Some(seconds as f64) };
+        utils::extract_chunk(audio_file, &sample, &sample_file).await?;
+        
+        let request = TranscriptionRequest {
+            file: sample_file,
+            model: self.config.model.clone(),
+            language: None,
+            prompt: None,
+            response_format: "verbose_json".to_string(),
+            temperature: 0.0,
+            timestamp_granularities: Vec::new(),
+            endpoint: "transcriptions",
+            audio: None,
+        };
+        
+        let response = if self.config.fanout.is_empty() {
+            self.with_timeout(audio_file, self.transcribe_via_api(self.config.provider, &request)).await?
+        } else {
+            self.with_timeout(audio_file, self.transcribe_fanout(&request)).await?
+        };
+        response.language.ok_or_else(|| {
+            anyhow::anyhow!("{} didn't report a language for {:?}", self.config.provider.label(), audio_file)
+        })
+    }
+    
+    /// Detect the language on the opening minute for --auto-language, as the code the API takes
+    async fn auto_language(&self, audio_file: &Path) -> Result<String> {
+        let detected = self.sample_language(audio_file, AUTO_LANGUAGE_SAMPLE_SECS).await?;
+        let code = utils::language_code(&detected).ok_or_else(|| {
+            anyhow::anyhow!("Detected language {:?} in {:?} isn't one Whisper accepts; pass --language", detected, audio_file)
+        })?;
+        
+        if !self.config.quiet {
+            eprintln!("Detected language {} ({}) in {}", code, detected, audio_file.display());
         }
+        Ok(code.to_string())
+    }
+    
+    /// Transcribe an audio file, splitting it first if it's too large for the API
+    async fn transcribe_audio(&self, audio_file: &Path, output_file: &Path) -> Result<()> {
+        let source_name = audio_file.file_name().and_then(|name| name.to_str()).unwrap_or("").to_string();
+        
+        // Upload a small 16 kHz mono copy instead; the original is only read, and the copy
+        // is deleted with its temp dir when this returns
+        let transcode_dir = match self.config.transcode {
+            Some(format) => {
+                let temp_dir = utils::create_temp_dir(self.config.temp_dir.as_deref())?;
+                let transcoded = temp_dir.path().join(format!("transcoded.{}", format.extension()));
+                utils::transcode_audio(audio_file, &transcoded, format).await?;
+                debug!(
+                    "Transcoded {:?} from {} to {} bytes",
+                    audio_file, fs::metadata(audio_file)?.len(), fs::metadata(&transcoded)?.len()
+                );
+                Some((temp_dir, transcoded))
+            }
+            None => None,
+        };
+        let audio_file = transcode_dir.as_ref().map_or(audio_file, |(_, transcoded)| transcoded.as_path());
+        
+        // Pin the language found on a sample, rather than letting every request (or chunk) guess
+        let detected_language = match &self.config.language {
+            None if self.config.auto_language && !self.config.translate => Some(self.auto_language(audio_file).await?),
+            _ => None,
+        };
+        let language = detected_language.as_deref().or(self.config.language.as_deref());
         
         // Check file size
-        let file_size = fs::metadata(audio_file)?.len();
+        let file_size = match &self.held_audio {
+            Some(audio) => audio.len() as u64,
+            _ => fs::metadata(audio_file)?.len(),
+        };
         debug!("Audio file size: {} bytes", file_size);
         
-        // OpenAI's limit is 25MB
-        const MAX_SIZE: u64 = 25 * 1024 * 1024;
-        
-        if file_size <= MAX_SIZE {
+        // OpenAI's limit is 25MB; --chunk-size leaves some headroom below it. AssemblyAI takes
+        // whole files, and splitting them would restart its speaker labels in every chunk
+        if file_size <= self.config.chunk_size_mb * 1024 * 1024 || self.config.provider == Provider::Assemblyai {
             // File is small enough, transcribe directly
-            self.transcribe_single_file(audio_file, output_file).await?;
+            self.transcribe_single_file(
+                audio_file,
+                output_file,
+                language,
+                self.config.prompt.as_deref(),
+            ).await?;
         } else {
             // File is too large, split and transcribe in chunks
-            self.transcribe_large_file(audio_file, output_file).await?;
+            self.transcribe_large_file(audio_file, output_file, language).await?;
+        }
+        
+        if self.config.needs_segments() {
+            if let Some(per_second) = self.config.peaks_per_second {
+                self.add_peaks(audio_file, output_file, per_second).await?;
+            }
+            self.write_formats(&source_name, output_file)?;
+        }
+        
+        Ok(())
+    }
+    
+    /// Add the waveform of the audio to the timings saved with a transcript, for --include-peaks
+    async fn add_peaks(&self, audio_file: &Path, output_file: &Path, per_second: u32) -> Result<()> {
+        let timestamps_file = timestamps_path(output_file);
+        let mut response: TranscriptionResponse = serde_json::from_str(&fs::read_to_string(&timestamps_file)?)?;
+        
+        let peaks = utils::waveform_peaks(audio_file, per_second).await?;
+        debug!("Read {} waveform peaks from {:?}", peaks.values.len(), audio_file);
+        response.peaks = Some(peaks);
+        
+        utils::write_atomic(&timestamps_file, serde_json::to_string_pretty(&response)?)
+    }
+    
+    /// Derive the extra --response-format and --split-segments files from the timings saved with a transcript
+    /// 
+    /// --wrap pauses and --include-segments also rewrite the text here. The timings file itself is only kept when --timestamps asked for it.
+    fn write_formats(&self, source_name: &str, output_file: &Path) -> Result<()> {
+        let timestamps_file = timestamps_path(output_file);
+        let mut response: TranscriptionResponse = serde_json::from_str(&fs::read_to_string(&timestamps_file)?)?;
+        
+        // Every format is built from the segments, so they're redacted first; the timings are untouched
+        if self.config.redact_pii {
+            let redactor = output::PiiRedactor::new(&response.text);
+            response.text = redactor.redact(&response.text).0;
+            for segment in &mut response.segments {
+                segment.text = redactor.redact(&segment.text).0;
+            }
+            utils::write_atomic(&timestamps_file, serde_json::to_string_pretty(&response)?)?;
+        }
+        
+        // Captions are re-flowed to the size limits; every other output keeps the segments as returned
+        let cues = if self.config.max_line_length.is_some() || self.config.max_cue_duration.is_some() {
+            captions::constrain_cues(&response.segments, self.config.max_line_length, self.config.max_cue_duration)
+        } else {
+            response.segments.clone()
+        };
+        
+        for format in &self.config.output_formats {
+            let Some(rendered) = self.render(*format, source_name, &response, &cues)? else {
+                continue;
+            };
+            
+            let path = output_file.with_extension(format.extension());
+            utils::write_atomic(&path, rendered)?;
+            debug!("Wrote {} transcript {:?}", format.extension(), path);
+        }
+        
+        for spec in &self.config.output_specs {
+            let path = spec.path_for(output_file);
+            let rendered = match spec.format {
+                OutputFormat::Text if spec.paragraphs => {
+                    let starts = output::paragraph_starts(&response.text, &response.segments, Some(output::PARAGRAPH_PAUSE_SECS));
+                    output::join_segments(&response.segments, &starts)
+                }
+                OutputFormat::Text => response.text.trim().to_string(),
+                format => {
+                    let max_line_length = spec.max_line_length.or(self.config.max_line_length);
+                    let max_cue_duration = spec.max_cue_duration.or(self.config.max_cue_duration);
+                    let cues = if max_line_length.is_some() || max_cue_duration.is_some() {
+                        captions::constrain_cues(&response.segments, max_line_length, max_cue_duration)
+                    } else {
+                        response.segments.clone()
+                    };
+                    self.render(format, source_name, &response, &cues)?.unwrap_or_default()
+                }
+            };
+            
+            if let Some(parent) = path.parent() {
+                fs::create_dir_all(parent)?;
+            }
+            utils::write_atomic(&path, rendered)?;
+            debug!("Wrote --output-spec {} transcript {:?}", spec.format.extension(), path);
+        }
+        
+        if self.config.split_segments {
+            output::write_segment_files(output_file, &response.segments)?;
+        }
+        
+        if let Some(every) = self.config.split_output_every {
+            self.write_parts(source_name, output_file, &response, &cues, every)?;
+        }
+        
+        // Only the plain text is laid out; caption timings stay as they were
+        if self.config.wrap == Some(TextWrap::Pauses) {
+            output::paragraph_on_pauses(output_file, &response.segments, output::PARAGRAPH_PAUSE_SECS)?;
+        }
+        
+        if self.config.include_segments {
+            output::append_segment_listing(output_file, &response.segments)?;
+        }
+        
+        if self.config.timestamps.is_none() {
+            fs::remove_file(&timestamps_file)?;
+        }
+        
+        Ok(())
+    }
+    
+    /// Render a response in one of the extra formats; None for text, which is written as it's cleaned up
+    fn render(&self, format: OutputFormat, source_name: &str, response: &TranscriptionResponse, cues: &[Cue]) -> Result<Option<String>> {
+        Ok(Some(match format {
+            OutputFormat::Text => return Ok(None),
+            OutputFormat::Srt => captions::write_srt(cues),
+            OutputFormat::Vtt => captions::write_vtt(cues),
+            OutputFormat::Json => serde_json::to_string_pretty(response)?,
+            OutputFormat::PodscriptJson => serde_json::to_string_pretty(&self.envelope(source_name, response))?,
+        }))
+    }
+    
+    /// Write every format as numbered parts split on segment boundaries, for --split-output-every
+    /// 
+    /// Each part is a standalone file of its format: captions are numbered
+    /// from 1 and keep their times, and JSON parts hold only their own
+    /// segments and words. Text parts keep the transcript's paragraph breaks
+    /// and are cleaned up along with it in `finish_output`. Without segments
+    /// the text is split by sentences instead.
+    fn write_parts(
+        &self,
+        source_name: &str,
+        output_file: &Path,
+        response: &TranscriptionResponse,
+        cues: &[Cue],
+        every: SplitEvery,
+    ) -> Result<()> {
+        if response.segments.is_empty() {
+            match every {
+                SplitEvery::Words(words) => {
+                    output::split_transcript(output_file, words)?;
+                }
+                SplitEvery::Duration(_) => warn!("{:?} has no segment timings to split by duration; it's written whole", output_file),
+            }
+            return Ok(());
+        }
+        
+        // A transcript that fits in one part gets none, which also clears parts from earlier runs
+        let groups = output::split_points(&response.segments, every);
+        let groups = if groups.len() > 1 { groups } else { Vec::new() };
+        
+        // Each part covers the time from its first segment to the next part's first segment
+        let bounds: Vec<(f64, f64)> = groups.iter().enumerate().map(|(i, range)| {
+            let start = if i == 0 { f64::NEG_INFINITY } else { response.segments[range.start].start };
+            let end = groups.get(i + 1).map_or(f64::INFINITY, |next| response.segments[next.start].start);
+            (start, end)
+        }).collect();
+        
+        let pause = (self.config.wrap == Some(TextWrap::Pauses)).then_some(output::PARAGRAPH_PAUSE_SECS);
+        let paragraph_starts = output::paragraph_starts(&response.text, &response.segments, pause);
+        
+        let parts: Vec<(TranscriptionResponse, Vec<Cue>)> = groups.iter().zip(&bounds).map(|(range, &(start, end))| {
+            let segments = &response.segments[range.clone()];
+            let part = TranscriptionResponse {
+                text: output::join_segments(segments, &paragraph_starts[range.clone()]),
+                language: response.language.clone(),
+                duration: Some(segments[segments.len() - 1].end - segments[0].start),
+                segments: segments.to_vec(),
+                words: response.words.iter().filter(|word| word.start >= start && word.start < end).cloned().collect(),
+                peaks: response.peaks.as_ref().map(|peaks| peaks.slice(start, end)),
+            };
+            let cues = cues.iter().filter(|cue| cue.start >= start && cue.start < end).cloned().collect();
+            (part, cues)
+        }).collect();
+        
+        let text_parts: Vec<String> = parts.iter().map(|(part, _)| part.text.clone()).collect();
+        for (path, (part, _)) in output::write_parts(output_file, &text_parts)?.iter().zip(&parts) {
+            if self.config.include_segments {
+                output::append_segment_listing(path, &part.segments)?;
+            }
+        }
+        
+        for format in &self.config.output_formats {
+            let rendered = parts.iter()
+                .filter_map(|(part, cues)| self.render(*format, source_name, part, cues).transpose())
+                .collect::<Result<Vec<_>>>()?;
+            if *format != OutputFormat::Text {
+                output::write_parts(&output_file.with_extension(format.extension()), &rendered)?;
+            }
+        }
+        
+        if !groups.is_empty() {
+            info!("Split {:?} into {} parts", output_file, groups.len());
+        }
+        Ok(())
+    }
+    
+    /// Wrap a response in the stable --response-format podscript-json schema
+    fn envelope<'r>(&'r self, source_name: &'r str, response: &'r TranscriptionResponse) -> TranscriptEnvelope<'r> {
+        TranscriptEnvelope {
+            schema_version: ENVELOPE_SCHEMA_VERSION,
+            source: source_name,
+            created_at: utils::output_time(self.config.output_epoch).to_rfc3339(),
+            provider: if self.config.fanout.is_empty() { self.config.provider.name() } else { "fanout" },
+            model: &self.config.model,
+            language: response.language.as_deref(),
+            duration: response.duration,
+            parameters: EnvelopeParameters {
+                language: self.config.language.as_deref(),
+                prompt: self.config.prompt.as_deref(),
+                translate: self.config.translate,
+                timestamps: self.config.requested_granularities().first().copied(),
+            },
+            text: response.text.trim(),
+            segments: &response.segments,
+            words: &response.words,
+            peaks: response.peaks.as_ref(),
+        }
+    }
+    
+    /// Apply the requested post-processing to a finished transcript
+    fn finish_output(&self, audio_file: &Path, output_file: &Path) -> Result<()> {
+        // An empty result usually means silence, the wrong stream or a corrupt file
+        if fs::read_to_string(output_file)?.trim().is_empty() {
+            if self.config.fail_on_empty {
+                return Err(anyhow::anyhow!(
+                    "Transcription of {:?} is empty; check that the audio isn't silent or corrupt",
+                    audio_file
+                ));
+            }
+            empty_transcripts().lock().unwrap().insert(output_file.to_path_buf());
+            warn!(
+                "Transcription of {:?} is empty; check that the audio isn't silent or corrupt (--fail-on-empty makes this an error)",
+                audio_file
+            );
+        }
+        
+        // Without timings the text is split by sentences here; write_formats splits on segments otherwise
+        if let Some(SplitEvery::Words(words_per_part)) = self.config.split_output_every {
+            if !self.config.needs_segments() {
+                output::split_transcript(output_file, words_per_part)?;
+            }
+        }
+        let parts = if self.config.split_output_every.is_some() {
+            output::part_files(output_file)
+        } else {
+            Vec::new()
+        };
+        
+        // Parts and --output-spec text files get the same cleanup and size cap as the full transcript
+        let text_files: Vec<PathBuf> = std::iter::once(output_file.to_path_buf())
+            .chain(parts.iter().cloned())
+            .chain(self.config.output_specs.iter()
+                .filter(|spec| spec.format == OutputFormat::Text)
+                .map(|spec| spec.path_for(output_file)))
+            .collect();
+        for file in &text_files {
+            self.clean_text(file)?;
+        }
+        
+        // Cap each text file's size for size-limited consumers
+        if let Some(max_bytes) = self.config.max_output_bytes {
+            for file in &text_files {
+                if let Some((original, truncated)) = output::truncate_transcript(file, max_bytes)? {
+                    warn!(
+                        "Truncated {:?} from {} to {} bytes to fit --max-output-bytes",
+                        file, original, truncated
+                    );
+                }
+            }
+        }
+        
+        // Break long lines for reading
+        if let Some(TextWrap::Columns(columns)) = self.config.wrap {
+            for file in std::iter::once(output_file).chain(parts.iter().map(PathBuf::as_path)) {
+                output::wrap_lines(file, columns)?;
+            }
+        }
+        
+        // Add header/footer boilerplate to the text and VTT files, parts included (main refuses SRT)
+        let vars = output::TemplateVars {
+            filename: audio_file.file_name().and_then(|name| name.to_str()).unwrap_or(""),
+            date: utils::output_time(self.config.output_epoch).format("%Y-%m-%d").to_string(),
+            model: &self.config.model,
+        };
+        let boilerplate = output::Boilerplate::load(
+            self.config.prepend_file.as_deref(),
+            self.config.append_file.as_deref(),
+            &vars,
+        )?;
+        if let Some(boilerplate) = &boilerplate {
+            for file in std::iter::once(output_file).chain(parts.iter().map(PathBuf::as_path)) {
+                output::wrap_transcript(file, boilerplate)?;
+            }
+            
+            if self.config.output_formats.contains(&OutputFormat::Vtt) {
+                let vtt_file = output_file.with_extension(OutputFormat::Vtt.extension());
+                let vtt_parts = if self.config.split_output_every.is_some() {
+                    output::part_files(&vtt_file)
+                } else {
+                    Vec::new()
+                };
+                for file in std::iter::once(vtt_file).chain(vtt_parts) {
+                    output::wrap_vtt(&file, boilerplate)?;
+                }
+            }
+            
+            for spec in &self.config.output_specs {
+                match spec.format {
+                    OutputFormat::Text => output::wrap_transcript(&spec.path_for(output_file), boilerplate)?,
+                    OutputFormat::Vtt => output::wrap_vtt(&spec.path_for(output_file), boilerplate)?,
+                    _ => {}
+                }
+            }
+        }
+        
+        if self.config.validate_output {
+            self.validate_outputs(output_file)?;
+        }
+        
+        Ok(())
+    }
+    
+    /// Parse back every SRT, VTT and JSON file written for a transcript, parts and --output-spec files included, for --validate-output
+    fn validate_outputs(&self, output_file: &Path) -> Result<()> {
+        let mut files = Vec::new();
+        for format in self.config.output_formats.iter().filter(|format| **format != OutputFormat::Text) {
+            let file = output_file.with_extension(format.extension());
+            let parts = if self.config.split_output_every.is_some() {
+                output::part_files(&file)
+            } else {
+                Vec::new()
+            };
+            files.extend(std::iter::once(file).chain(parts).map(|file| (*format, file)));
+        }
+        files.extend(self.config.output_specs.iter().map(|spec| (spec.format, spec.path_for(output_file))));
+        
+        for (format, file) in files {
+            let content = fs::read_to_string(&file)?;
+            let checked = match format {
+                OutputFormat::Text => Ok(()),
+                OutputFormat::Srt => captions::validate_srt(&content),
+                OutputFormat::Vtt => captions::validate_vtt(&content),
+                OutputFormat::Json => serde_json::from_str::<TranscriptionResponse>(&content).map(|_| ()).map_err(Into::into),
+                OutputFormat::PodscriptJson => serde_json::from_str::<serde_json::Map<String, serde_json::Value>>(&content)
+                    .map(|_| ())
+                    .map_err(Into::into),
+            };
+            checked.map_err(|e| anyhow::anyhow!("{:?} is malformed: {}", file, e))?;
+            debug!("Validated {:?}", file);
+        }
+        
+        Ok(())
+    }
+    
+    /// Redact, trim fillers from and post-process a plain-text transcript or part
+    fn clean_text(&self, text_file: &Path) -> Result<()> {
+        // Redact personal information before anything else sees the text
+        if self.config.redact_pii {
+            let counts = output::redact_pii(text_file)?;
+            let summary: Vec<String> = counts
+                .iter()
+                .map(|(tag, count)| format!("{} {}", count, tag))
+                .collect();
+            info!("Redacted PII in {:?}: {}", text_file, summary.join(", "));
+        }
+        
+        // Drop filler words for a cleaner read
+        if let Some(fillers) = &self.config.trim_fillers {
+            let removed = output::trim_fillers(text_file, fillers)?;
+            info!("Removed {} filler words from {:?}", removed, text_file);
+        }
+        
+        // Hand the transcript to the user's own cleanup script
+        if let Some(command) = &self.config.postprocess_command {
+            output::postprocess_transcript(text_file, command)?;
         }
         
         Ok(())
     }
     
     /// Transcribe a single audio file (less than 25MB)
-    async fn transcribe_single_file(&self, audio_file: &Path, output_file: &Path) -> Result<()> {
-        info!("Direct transcription of file: {:?}", audio_file);
+    /// 
+    /// `language` and `prompt` override the configured ones; a `None` language
+    /// lets the model detect it.
+    async fn transcribe_single_file(
+        &self,
+        audio_file: &Path,
+        output_file: &Path,
+        language: Option<&str>,
+        prompt: Option<&str>,
+    ) -> Result<()> {
+        debug!("Direct transcription of file: {:?}", audio_file);
         
         // Create output directory if it doesn't exist
         if let Some(parent) = output_file.parent() {
             fs::create_dir_all(parent)?;
         }
         
-        // Use podscript command for transcription
+        // verbose_json reports the detected language and duration, for the log and run report, and
+        // the timings; podscript only returns text, and models without verbose_json get their own default
+        let detect_language = language.is_none() && self.config.detect_language_per_chunk;
+        let verbose = detect_language || self.config.needs_segments() || !self.config.uses_podscript();
+        let request = TranscriptionRequest {
+            file: audio_file.to_path_buf(),
+            model: self.config.model.clone(),
+            // Translations are always English and take no language
+            language: language.filter(|_| !self.config.translate).map(str::to_string),
+            prompt: prompt.map(str::to_string),
+            response_format: if verbose { config::model_capabilities(&self.config.model).default_format } else { "text" }.to_string(),
+            temperature: self.config.temperature,
+            timestamp_granularities: self.config.timestamp_granularities().iter().map(|value| value.to_string()).collect(),
+            endpoint: if self.config.translate { "translations" } else { "transcriptions" },
+            audio: self.held_audio.clone(),
+        };
+        
+        // Show the equivalent API request for debugging and bug reports
+        if self.config.print_command {
+            println!("{}", self.curl_command(&request));
+        }
+        
+        // The same audio sent with the same parameters gets the stored result
+        let cache_file = if self.config.use_cache {
+            Some(self.result_cache_file(&request)?)
+        } else {
+            None
+        };
+        if let Some(cached) = cache_file.as_deref().and_then(load_cached_response) {
+            info!("Using the cached transcription of {:?} (--no-cache to request it again)", audio_file);
+            self.write_response(output_file, &cached)?;
+            return Ok(());
+        }
+        
+        // Race several providers and keep the first successful result
+        if !self.config.fanout.is_empty() {
+            let response = self.with_timeout(audio_file, self.transcribe_fanout(&request)).await?;
+            store_cached_response(cache_file.as_deref(), &response);
+            self.write_response(output_file, &response)?;
+            
+            debug!("Transcription completed successfully: {:?}", output_file);
+            return Ok(());
+        }
+        
+        // Other providers, custom base URLs and timings go directly over HTTP
+        if !self.config.uses_podscript() {
+            let response = self.with_timeout(audio_file, self.transcribe_via_api(self.config.provider, &request)).await?;
+            if let Some(language) = &response.language {
+                debug!("Detected language {:?} for {:?}", language, audio_file);
+            }
+            store_cached_response(cache_file.as_deref(), &response);
+            self.write_response(output_file, &response)?;
+            
+            debug!("Transcription completed successfully: {:?}", output_file);
+            return Ok(());
+        }
+        
+        // Use podscript command for transcription, renaming its output into place once it's complete
+        let partial_file = utils::partial_path(output_file);
         let mut args = vec![
             "open-ai-whisper",
             audio_file.to_str().unwrap(),
-            "--output", output_file.to_str().unwrap(),
+            "--output", partial_file.to_str().unwrap(),
         ];
         
         // Add language if provided
-        if let Some(lang) = &self.config.language {
+        if let Some(lang) = &request.language {
             args.extend_from_slice(&["--language", lang]);
         }
         
         // Add prompt if provided
-        if let Some(prompt) = &self.config.prompt {
+        if let Some(prompt) = &request.prompt {
             args.extend_from_slice(&["--prompt", prompt]);
         }
         
+        if detect_language {
+            debug!("podscript doesn't report the detected language for {:?}", audio_file);
+        }
+        
         // Set environment variable for API key
         // Use the podscript binary from the parent directory
-        let mut command = Command::new("../podscript");
+        // Killed if the transcription is cancelled (e.g. by Ctrl-C in a batch)
+        let mut command = Command::new(PODSCRIPT_BINARY);
         command.args(&args)
-               .env("OPENAI_API_KEY", &self.config.api_key);
+               .env("OPENAI_API_KEY", &self.config.api_key)
+               .kill_on_drop(true);
         
-        let output = command.output()?;
+        let progress = utils::spinner(
+            self.config.progress,
+            format!("Transcribing {} with podscript", audio_file.file_name().and_then(|name| name.to_str()).unwrap_or("audio")),
+        );
+        let output = self.with_timeout(audio_file, async { Ok(command.output().await?) }).await;
+        progress.finish_and_clear();
         
-        if !output.status.success() {
-            return Err(anyhow::anyhow!(
-                "Transcription failed: {}",
-                String::from_utf8_lossy(&output.stderr)
-            ));
+        match output {
+            Ok(output) if output.status.success() => {}
+            result => {
+                let _ = fs::remove_file(&partial_file);
+                let output = result?;
+                return Err(anyhow::anyhow!(
+                    "Transcription failed: {}",
+                    String::from_utf8_lossy(&output.stderr)
+                ));
+            }
         }
+        fs::rename(&partial_file, output_file)?;
         
-        info!("Transcription completed successfully: {:?}", output_file);
+        store_cached_response(cache_file.as_deref(), &TranscriptionResponse {
+            text: fs::read_to_string(output_file)?,
+            language: None,
+            duration: None,
+            segments: Vec::new(),
+            words: Vec::new(),
+            peaks: None,
+        });
+        
+        debug!("Transcription completed successfully: {:?}", output_file);
         Ok(())
     }
     
+    /// Run one transcription request under the --timeout deadline
+    /// 
+    /// On timeout the request is dropped, which aborts the connection or kills
+    /// the child process; temp files go with their directories as the error
+    /// propagates.
+    async fn with_timeout<T>(&self, audio_file: &Path, request: impl Future<Output = Result<T>>) -> Result<T> {
+        tokio::time::timeout(self.config.timeout, request)
+            .await
+            .map_err(|_| TranscriptionError::Timeout {
+                file: audio_file.to_path_buf(),
+                seconds: self.config.timeout.as_secs(),
+            })?
+    }
+    
+    /// Result cache entry for a request, keyed on the audio's content and every parameter sent
+    fn result_cache_file(&self, request: &TranscriptionRequest) -> Result<PathBuf> {
+        let mut hasher = Sha256::new();
+        hasher.update(match &request.audio {
+            Some(audio) => hex::encode(Sha256::digest(audio)),
+            None => utils::hash_file(&request.file)?,
+        });
+        hasher.update(serde_json::to_string(&(
+            self.backend_key(),
+            request.endpoint,
+            &request.model,
+            &request.language,
+            &request.prompt,
+            &request.response_format,
+            request.temperature,
+            &request.timestamp_granularities,
+        ))?);
+        
+        Ok(result_cache_dir().join(format!("{}.json", hex::encode(hasher.finalize()))))
+    }
+    
+    /// What produces the transcripts, for cache keys: different backends can give different results for the same request
+    fn backend_key(&self) -> String {
+        let backend = if let Some(model) = &self.config.whisper_model {
+            format!("whisper.cpp {}", model.display())
+        } else if self.config.fanout.is_empty() {
+            format!("{} {}", self.config.provider.name(), self.config.api_base)
+        } else {
+            self.config.fanout.iter().map(|provider| provider.name()).collect::<Vec<_>>().join(",")
+        };
+        // Speaker labels change the text itself
+        if self.config.diarize { format!("{} diarized", backend) } else { backend }
+    }
+    
+    /// Send the request to every --fanout provider at once and return the first success
+    /// 
+    /// All providers are called over HTTP (OpenAI included). The losing
+    /// requests are dropped, which aborts their connections, as soon as one
+    /// provider succeeds.
+    async fn transcribe_fanout(&self, request: &TranscriptionRequest) -> Result<TranscriptionResponse> {
+        let attempts = self.config.fanout.iter().map(|&provider| {
+            Box::pin(async move {
+                self.transcribe_via_api(provider, request)
+                    .await
+                    .map(|response| (provider, response))
+                    .map_err(|e| {
+                        warn!("Provider {} failed: {}", provider.name(), e);
+                        e
+                    })
+            })
+        });
+        
+        let ((winner, response), _) = futures::future::select_ok(attempts)
+            .await
+            .map_err(|e| anyhow::anyhow!("All fan-out providers failed; last error: {}", e))?;
+        
+        info!("Provider {} returned first", winner.name());
+        Ok(response)
+    }
+    
+    /// Send a transcription request to the provider's OpenAI-compatible endpoint
+    /// 
+    /// With --whisper-model the local provider runs whisper.cpp on the file
+    /// instead of calling a server, and AssemblyAI has its own job-based API.
+    /// Other requests are retried with backoff on the --retry-status-codes
+    /// statuses, and paced by --adaptive-rate when it's on. Every attempt
+    /// carries the same Idempotency-Key, so a provider that already has the
+    /// upload doesn't transcribe it twice.
+    async fn transcribe_via_api(&self, provider: Provider, request: &TranscriptionRequest) -> Result<TranscriptionResponse> {
+        if let (Provider::Local, Some(model), Some(binary)) = (provider, &self.config.whisper_model, &self.config.whisper_binary) {
+            return self.transcribe_with_whisper_cpp(binary, model, request).await;
+        }
+        if provider == Provider::Assemblyai {
+            return self.transcribe_with_assemblyai(request).await;
+        }
+        
+        let rate = self.config.adaptive_rate.map(dispatch_rate);
+        let retries = self.config.retry.retries;
+        let idempotency_key = utils::idempotency_key();
+        let mut attempt = 0;
+        loop {
+            if let Some(rate) = rate {
+                rate.acquire().await;
+            }
+            
+            let e = match self.send_transcription(provider, request, &idempotency_key).await {
+                Ok(response) => {
+                    if let Some(rate) = rate {
+                        rate.succeeded();
+                    }
+                    return Ok(response);
+                }
+                Err(e) => e,
+            };
+            
+            // Under --adaptive-rate a 429 is always worth another try at the lowered rate
+            let rate_limited = is_rate_limited(&e);
+            if let Some(rate) = rate.filter(|_| rate_limited) {
+                rate.throttled();
+            }
+            if attempt >= retries || !(self.is_retryable(&e) || (rate.is_some() && rate_limited)) {
+                return Err(e);
+            }
+            
+            attempt += 1;
+            utils::record_http_retry();
+            match rate.filter(|_| rate_limited) {
+                Some(rate) => warn!(
+                    "{} rate limited {:?}; retrying at {:.0} requests per minute (attempt {}/{})",
+                    provider.label(), request.file, rate.rate(), attempt, retries
+                ),
+                None => {
+                    let delay = Duration::from_secs(1 << attempt.min(5));
+                    warn!(
+                        "{} request for {:?} failed ({}), retrying in {:?} (attempt {}/{})",
+                        provider.label(), request.file, e, delay, attempt, retries
+                    );
+                    tokio::time::sleep(delay).await;
+                }
+            }
+        }
+    }
+    
+    /// Whether a failed transcription request is worth sending again
+    /// 
+    /// That's a retryable status, or a dropped connection or DNS failure
+    /// like those on flaky mobile networks, classified as for downloads.
+    fn is_retryable(&self, error: &anyhow::Error) -> bool {
+        if let Some(request_error) = error.downcast_ref::<reqwest::Error>() {
+            return utils::is_retryable_error(request_error, &self.config.retry.status_codes);
+        }
+        
+        matches!(
+            error.downcast_ref::<TranscriptionError>(),
+            Some(TranscriptionError::Api { status, .. }) if self.config.retry.status_codes.contains(&status.as_u16())
+        )
+    }
+    
+    /// Upload the request's file to an OpenAI-compatible endpoint and read the transcription
+    async fn send_transcription(&self, provider: Provider, request: &TranscriptionRequest, idempotency_key: &str) -> Result<TranscriptionResponse> {
+        let api_base = self.api_base_for(provider);
+        let url = endpoint_url(api_base, request.endpoint);
+        debug!("Sending transcription request to {}", url);
+        
+        let file_name = self.api_filename(&request.file);
+        let mime_type = mime_type_for(&request.file, &file_name);
+        let audio = match &request.audio {
+            Some(audio) => audio.to_vec(),
+            None => tokio::fs::read(&request.file).await?,
+        };
+        let size = audio.len() as u64;
+        
+        // Fan-out uploads run side by side, so only a single provider's upload gets a progress bar
+        let label = request.file.file_name().and_then(|name| name.to_str()).unwrap_or("audio");
+        let (body, progress) = utils::upload_body(self.config.progress && self.config.fanout.is_empty(), audio, label).await;
+        
+        let mut form = Form::new()
+            .part("file", Part::stream_with_length(body, size).file_name(file_name).mime_str(mime_type)?)
+            .text("model", request.model.clone())
+            .text("response_format", request.response_format.clone())
+            .text("temperature", request.temperature.to_string());
+        
+        if let Some(language) = &request.language {
+            form = form.text("language", language.clone());
+        }
+        
+        if let Some(prompt) = &request.prompt {
+            form = form.text("prompt", prompt.clone());
+        }
+        
+        for granularity in &request.timestamp_granularities {
+            form = form.text("timestamp_granularities[]", granularity.clone());
+        }
+        
+        let mut http_request = self.client.post(&url).multipart(form).header("Idempotency-Key", idempotency_key);
+        if !self.config.api_key.is_empty() {
+            http_request = if config::is_azure_endpoint(api_base) {
+                http_request.header("api-key", &self.config.api_key)
+            } else {
+                http_request.bearer_auth(&self.config.api_key)
+            };
+        }
+        
+        let response = utils::send_request(http_request, Some(&progress), &self.config.http_timeouts).await;
+        progress.finish_and_clear();
+        
+        // The context keeps the underlying error, so retries can still tell what went wrong
+        let response = response.map_err(|e| match provider {
+            Provider::Local => {
+                let message = format!(
+                    "Could not reach the local whisper.cpp server at {} ({}). Start it with whisper.cpp's server binary, pass --api-base, \
+                     or pass --whisper-model to run whisper.cpp without a server",
+                    api_base, e
+                );
+                e.context(message)
+            }
+            _ => e,
+        })?;
+        
+        let status = response.status();
+        let body = utils::read_body(response, &self.config.http_timeouts).await?;
+        
+        // Keep the exact bytes, errors included, for auditing and bug reports
+        if let Some(dir) = &self.config.save_raw_response {
+            Self::save_raw_response(dir, provider, &request.file, &body)?;
+        }
+        
+        let body = String::from_utf8_lossy(&body).into_owned();
+        
+        if !status.is_success() {
+            return Err(Self::api_error(provider, api_base, status, &body));
+        }
+        
+        // JSON formats wrap the transcript; text is returned as-is, also by servers that ignore response_format
+        if request.response_format.ends_with("json") {
+            match serde_json::from_str(&body) {
+                Ok(response) => return Ok(response),
+                Err(e) if body.trim_start().starts_with('{') => return Err(e.into()),
+                Err(_) => debug!("{} returned text instead of {}", provider.label(), request.response_format),
+            }
+        }
+        
+        Ok(TranscriptionResponse {
+            text: body,
+            language: None,
+            duration: None,
+            segments: Vec::new(),
+            words: Vec::new(),
+            peaks: None,
+        })
+    }
+    
+    /// Run whisper.cpp on the request's file, shaping its segments like a verbose_json response
+    async fn transcribe_with_whisper_cpp(&self, binary: &Path, model: &Path, request: &TranscriptionRequest) -> Result<TranscriptionResponse> {
+        let whisper = WhisperCpp {
+            binary,
+            model,
+            temp_dir: self.config.temp_dir.as_deref(),
+            progress: self.config.progress && self.config.fanout.is_empty(),
+        };
+        
+        let segments = whisper.transcribe(
+            &request.file,
+            request.language.as_deref(),
+            request.prompt.as_deref(),
+            request.temperature,
+            request.endpoint == "translations",
+        ).await?;
+        
+        Ok(TranscriptionResponse {
+            text: segments.iter().map(|segment| segment.text.as_str()).collect::<Vec<_>>().join(" "),
+            language: request.language.clone(),
+            duration: segments.last().map(|segment| segment.end),
+            segments,
+            words: Vec::new(),
+            peaks: None,
+        })
+    }
+    
+    /// Transcribe the request's file with AssemblyAI, which has no prompt and no translations
+    async fn transcribe_with_assemblyai(&self, request: &TranscriptionRequest) -> Result<TranscriptionResponse> {
+        if request.prompt.is_some() {
+            debug!("AssemblyAI doesn't take a prompt; ignoring it for {:?}", request.file);
+        }
+        
+        // Remembered uploads live with the other caches, and go with them under --no-cache
+        let resume_dir = self.config.use_cache.then(|| utils::cache_dir().join("uploads"));
+        let assemblyai = AssemblyAi {
+            client: &self.client,
+            api_base: self.api_base_for(Provider::Assemblyai),
+            api_key: &self.config.api_key,
+            model: &request.model,
+            poll_interval: self.config.poll_interval,
+            diarize: self.config.diarize,
+            progress: self.config.progress && self.config.fanout.is_empty(),
+            timeouts: self.config.http_timeouts,
+            resume_dir: resume_dir.as_deref(),
+        };
+        let transcript = assemblyai.transcribe(&request.file, request.language.as_deref()).await?;
+        
+        Ok(TranscriptionResponse {
+            text: transcript.text,
+            language: transcript.language,
+            duration: transcript.duration,
+            segments: transcript.segments,
+            words: transcript.words,
+            peaks: None,
+        })
+    }
+    
+    /// Write the transcript text, plus the timings file if --timestamps or --response-format needs it
+    fn write_response(&self, output_file: &Path, response: &TranscriptionResponse) -> Result<()> {
+        utils::write_atomic(output_file, response.text.trim())?;
+        record_transcript_details(output_file, response.language.clone(), response.duration);
+        
+        if self.config.needs_segments() {
+            let timestamps_file = timestamps_path(output_file);
+            utils::write_atomic(&timestamps_file, serde_json::to_string_pretty(response)?)?;
+            debug!(
+                "Wrote {} segment and {} word timings to {:?}",
+                response.segments.len(), response.words.len(), timestamps_file
+            );
+        }
+        
+        Ok(())
+    }
+    
+    /// Write a provider's response body to the next numbered file in `dir`
+    fn save_raw_response(dir: &Path, provider: Provider, audio_file: &Path, body: &[u8]) -> Result<()> {
+        static RESPONSE_COUNT: AtomicUsize = AtomicUsize::new(0);
+        
+        let number = RESPONSE_COUNT.fetch_add(1, Ordering::SeqCst) + 1;
+        let extension = if body.first() == Some(&b'{') { "json" } else { "txt" };
+        let path = dir.join(format!("response-{:04}-{}.{}", number, provider.name(), extension));
+        
+        fs::write(&path, body)
+            .map_err(|e| anyhow::anyhow!("Failed to save raw response to {:?}: {}", path, e))?;
+        
+        info!("Saved raw response for {:?} to {:?}", audio_file, path);
+        Ok(())
+    }
+    
+    /// Turn an error response into a helpful message
+    fn api_error(provider: Provider, api_base: &str, status: reqwest::StatusCode, body: &str) -> anyhow::Error {
+        // whisper.cpp serves /inference unless started with an OpenAI-style path
+        if provider == Provider::Local && status.as_u16() == 404 {
+            return anyhow::anyhow!(
+                "The whisper.cpp server at {} has no /audio/transcriptions endpoint. Start it with \
+                 --inference-path /v1/audio/transcriptions",
+                api_base
+            );
+        }
+        
+        // OpenAI-compatible servers nest the message; whisper.cpp returns {"error": "..."}
+        let message = serde_json::from_str::<serde_json::Value>(body)
+            .ok()
+            .and_then(|json| {
+                json["error"]["message"].as_str()
+                    .or_else(|| json["error"].as_str())
+                    .map(str::to_string)
+            })
+            .unwrap_or_else(|| body.trim().to_string());
+        
+        TranscriptionError::Api { status, message }.into()
+    }
+    
+    /// Base URL for a provider: --api-base applies to the selected provider only
+    fn api_base_for(&self, provider: Provider) -> &str {
+        if provider == self.config.provider {
+            &self.config.api_base
+        } else {
+            provider.default_api_base()
+        }
+    }
+    
+    /// Filename sent with the upload: --api-filename, or the file's own name
+    /// 
+    /// Some servers infer the audio format from it, which temp names like
+    /// fifo_capture.mp3 or chunk_3.mp3 can confuse.
+    fn api_filename(&self, audio_file: &Path) -> String {
+        self.config.api_filename.clone().unwrap_or_else(|| {
+            audio_file.file_name()
+                .and_then(|name| name.to_str())
+                .unwrap_or("audio.mp3")
+                .to_string()
+        })
+    }
+    
+    /// Build a curl command equivalent to the transcription request for a file
+    /// 
+    /// The API key is redacted so the command can be shared safely.
+    fn curl_command(&self, request: &TranscriptionRequest) -> String {
+        let mut fields = vec![
+            format!("file=@{};filename={}", request.file.display(), self.api_filename(&request.file)),
+            format!("model={}", request.model),
+            format!("response_format={}", request.response_format),
+            format!("temperature={}", request.temperature),
+        ];
+        
+        if let Some(lang) = &request.language {
+            fields.push(format!("language={}", lang));
+        }
+        
+        if let Some(prompt) = &request.prompt {
+            fields.push(format!("prompt={}", prompt));
+        }
+        
+        for granularity in &request.timestamp_granularities {
+            fields.push(format!("timestamp_granularities[]={}", granularity));
+        }
+        
+        let mut command = format!("curl {}", utils::shell_quote(&endpoint_url(&self.config.api_base, request.endpoint)));
+        
+        if !self.config.api_key.is_empty() {
+            let header = if config::is_azure_endpoint(&self.config.api_base) {
+                "api-key: [REDACTED]"
+            } else {
+                "Authorization: Bearer [REDACTED]"
+            };
+            command.push_str(&format!(" \\\n  -H {}", utils::shell_quote(header)));
+        }
+        
+        for field in fields {
+            command.push_str(&format!(" \\\n  -F {}", utils::shell_quote(&field)));
+        }
+        
+        command
+    }
+    
     /// Transcribe a large audio file by splitting it into chunks
-    async fn transcribe_large_file(&self, audio_file: &Path, output_file: &Path) -> Result<()> {
+    /// 
+    /// Chunk transcripts are cached under a key derived from the file's content
+    /// hash and the chunking settings, so a re-run after an interruption only
+    /// transcribes the chunks that hadn't finished.
+    async fn transcribe_large_file(&self, audio_file: &Path, output_file: &Path, language: Option<&str>) -> Result<()> {
         info!("Splitting and transcribing large file: {:?}", audio_file);
         
         // Create temporary directory for chunks
-        let temp_dir = tempdir()?;
+        let temp_dir = utils::create_temp_dir(self.config.temp_dir.as_deref())?;
         let chunks_dir = temp_dir.path().join("chunks");
-        let transcripts_dir = temp_dir.path().join("transcripts");
-        
         fs::create_dir_all(&chunks_dir)?;
-        fs::create_dir_all(&transcripts_dir)?;
         
-        // Split audio file into chunks (20MB each)
-        let chunk_files = utils::split_audio_file(audio_file, &chunks_dir, 1000)?;
+        // Plan chunk boundaries, overlapping each chunk with the end of the previous one
+        let duration = utils::get_audio_duration(audio_file).await?;
+        let chunks = utils::plan_chunks(
+            duration,
+            self.chunk_duration(),
+            self.config.min_chunk_duration,
+            self.config.chunk_overlap,
+        );
+        debug!("Audio duration: {} seconds, splitting into {} chunks", duration, chunks.len());
+        
+        // Locate the per-chunk transcript cache for this file and these settings
+        let cache_dir = self.chunk_cache_dir(audio_file, language)?;
+        fs::create_dir_all(&cache_dir)?;
+        
+        // Chunks detect their own language for code-switching recordings
+        let language = if self.config.detect_language_per_chunk {
+            None
+        } else {
+            language
+        };
+        
+        // Carrying context feeds each chunk the previous transcript, so those chunks must run in order
+        let concurrency = if self.config.carry_context { 1 } else { self.config.chunk_concurrency.max(1) };
+        
+        // Transcribe the chunks up to `concurrency` at a time. Returning on the first error drops
+        // the stream, which cancels the chunks still in flight
+        let mut pending = stream::iter(&chunks)
+            .map(|chunk| {
+                let (chunks_dir, cache_dir, total) = (&chunks_dir, &cache_dir, chunks.len());
+                async move {
+                    self.transcribe_chunk(audio_file, chunk, total, chunks_dir, cache_dir, language).await
+                        .map_err(|e| e.context(format!("Chunk {}/{} of {:?} failed", chunk.index + 1, total, audio_file)))
+                }
+            })
+            .buffered(concurrency);
         
-        // Transcribe each chunk
+        // With --stream, the transcript is appended to as chunks finish, then replaced by the final one
+        if let Some(parent) = output_file.parent() {
+            fs::create_dir_all(parent)?;
+        }
+        let mut streamed = if self.config.stream { Some(fs::File::create(output_file)?) } else { None };
+        
+        // Results arrive in chunk order (later chunks that finish first wait in the buffer), so
+        // the language is the first one detected and each transcript can be joined as it comes
+        let mut detected_language: Option<String> = None;
         let mut all_transcripts = String::new();
+        let mut previous_transcript: Option<String> = None;
         
-        for (i, chunk_file) in chunk_files.iter().enumerate() {
-            let transcript_file = transcripts_dir.join(format!("transcript_{}.txt", i + 1));
+        for chunk in &chunks {
+            let chunk_language = pending.next().await.expect("one result per chunk")?;
+            detected_language = detected_language.or(chunk_language);
             
-            info!("Transcribing chunk {}/{}", i + 1, chunk_files.len());
-            self.transcribe_single_file(chunk_file, &transcript_file).await?;
-            
-            // Read transcript and append to combined transcript
-            let transcript = fs::read_to_string(&transcript_file)?;
-            all_transcripts.push_str(&transcript);
+            // Join the chunk transcripts, minus words repeated from the overlap
+            let transcript = fs::read_to_string(chunk_transcript_path(&cache_dir, chunk.index))?;
+            let new_text = match &previous_transcript {
+                Some(previous) if self.config.chunk_overlap > 0 => output::strip_overlap(previous, &transcript),
+                _ => transcript.as_str(),
+            };
+            all_transcripts.push_str(new_text);
             all_transcripts.push_str("\n\n");
+            
+            if let (Some(file), false) = (&mut streamed, new_text.trim().is_empty()) {
+                let text = format!("{}\n\n", new_text.trim());
+                file.write_all(text.as_bytes())?;
+                let mut stdout = std::io::stdout().lock();
+                stdout.write_all(text.as_bytes())?;
+                stdout.flush()?;
+            }
+            previous_transcript = Some(transcript);
         }
+        drop(streamed);
         
         // Write combined transcript to output file
-        if let Some(parent) = output_file.parent() {
-            fs::create_dir_all(parent)?;
+        utils::write_atomic(output_file, all_transcripts.trim())?;
+        record_transcript_details(output_file, detected_language, Some(duration));
+        
+        // Shift each chunk's timings onto the whole file, dropping those repeated in the overlap
+        if self.config.needs_segments() {
+            let mut combined = TranscriptionResponse {
+                text: all_transcripts.trim().to_string(),
+                language: None,
+                duration: Some(duration),
+                segments: Vec::new(),
+                words: Vec::new(),
+                peaks: None,
+            };
+            
+            for chunk in &chunks {
+                let chunk_timestamps = timestamps_path(&chunk_transcript_path(&cache_dir, chunk.index));
+                let part: TranscriptionResponse = serde_json::from_str(&fs::read_to_string(&chunk_timestamps)?)?;
+                merge_chunk_timings(&mut combined, part, chunk.start);
+            }
+            
+            utils::write_atomic(timestamps_path(output_file), serde_json::to_string_pretty(&combined)?)?;
+        }
+        
+        // The job is complete, so the chunk transcripts are no longer needed
+        if let Err(e) = fs::remove_dir_all(&cache_dir) {
+            debug!("Failed to remove chunk cache {:?}: {}", cache_dir, e);
         }
-        fs::write(output_file, all_transcripts.trim())?;
         
         info!("Combined transcript saved to: {:?}", output_file);
         Ok(())
     }
+    
+    /// Transcribe one chunk of a large file into the chunk cache, unless an earlier run already did
+    /// 
+    /// Returns the language detected in the chunk, if it was transcribed now.
+    async fn transcribe_chunk(
+        &self,
+        audio_file: &Path,
+        chunk: &utils::ChunkSpec,
+        total: usize,
+        chunks_dir: &Path,
+        cache_dir: &Path,
+        language: Option<&str>,
+    ) -> Result<Option<String>> {
+        let transcript_file = chunk_transcript_path(cache_dir, chunk.index);
+        if transcript_file.exists() {
+            info!("Reusing transcript of chunk {}/{} from a previous run", chunk.index + 1, total);
+            return Ok(None);
+        }
+        
+        let chunk_file = chunks_dir.join(format!("chunk_{}.mp3", chunk.index + 1));
+        utils::extract_chunk(audio_file, chunk, &chunk_file).await?;
+        
+        info!("Transcribing chunk {}/{}", chunk.index + 1, total);
+        
+        // Only move the transcript into the cache once it's complete
+        let partial_file = transcript_file.with_extension("partial");
+        
+        // Carry the end of the previous chunk over for continuity of names and terms
+        let previous_transcript = chunk.index.checked_sub(1)
+            .filter(|_| self.config.carry_context)
+            .and_then(|previous| fs::read_to_string(chunk_transcript_path(cache_dir, previous)).ok());
+        let prompt = match &previous_transcript {
+            Some(previous) => Some(carry_context_prompt(self.config.prompt.as_deref(), previous)),
+            None => self.config.prompt.clone(),
+        };
+        
+        self.transcribe_single_file(&chunk_file, &partial_file, language, prompt.as_deref()).await?;
+        let chunk_language = transcript_details(&partial_file).and_then(|details| details.language);
+        fs::rename(&partial_file, &transcript_file)?;
+        fs::remove_file(&chunk_file)?;
+        
+        Ok(chunk_language)
+    }
+    
+    /// Chunk length in seconds, short enough that a chunk plus its overlap fits --chunk-size
+    fn chunk_duration(&self) -> u64 {
+        let size_seconds = (self.config.chunk_size_mb * 1024 * 1024) as f64 / utils::CHUNK_BYTES_PER_SECOND;
+        let fitting = (size_seconds as u64).saturating_sub(self.config.chunk_overlap).max(1);
+        CHUNK_DURATION.min(fitting)
+    }
+    
+    /// Directory holding cached chunk transcripts for a file
+    /// 
+    /// The key covers the file contents and every setting that affects the
+    /// chunk plan or the chunk transcripts, so changing any of them starts over.
+    fn chunk_cache_dir(&self, audio_file: &Path, language: Option<&str>) -> Result<PathBuf> {
+        let mut hasher = Sha256::new();
+        hasher.update(utils::hash_file(audio_file)?);
+        hasher.update(self.chunk_duration().to_le_bytes());
+        hasher.update(self.config.chunk_overlap.to_le_bytes());
+        hasher.update(self.config.min_chunk_duration.to_le_bytes());
+        hasher.update(language.unwrap_or(""));
+        hasher.update([0, self.config.detect_language_per_chunk as u8]);
+        hasher.update(self.config.prompt.as_deref().unwrap_or(""));
+        hasher.update([0, self.config.carry_context as u8]);
+        hasher.update(format!("{:?} {} {}", self.config.timestamp_granularities(), self.config.needs_segments(), self.config.translate));
+        hasher.update(serde_json::to_string(&(self.backend_key(), &self.config.model, self.config.temperature))?);
+        
+        Ok(utils::cache_dir().join("chunks").join(hex::encode(hasher.finalize())))
+    }
+}
+
+/// Directory of cached transcription results
+pub fn result_cache_dir() -> PathBuf {
+    utils::cache_dir().join("results")
+}
+
+/// Read a cached result, treating a missing or unreadable entry as a miss
+fn load_cached_response(cache_file: &Path) -> Option<TranscriptionResponse> {
+    let content = fs::read_to_string(cache_file).ok()?;
+    serde_json::from_str(&content).ok()
+}
+
+/// Store a result in the cache; a failure only costs a repeat request later, so it's just logged
+fn store_cached_response(cache_file: Option<&Path>, response: &TranscriptionResponse) {
+    // An empty result is more likely a glitch than the real transcript
+    let Some(cache_file) = cache_file.filter(|_| !response.text.trim().is_empty()) else {
+        return;
+    };
+    
+    let stored = fs::create_dir_all(result_cache_dir())
+        .map_err(anyhow::Error::from)
+        .and_then(|_| Ok(serde_json::to_string(response)?))
+        .and_then(|json| Ok(fs::write(cache_file, json)?));
+    
+    if let Err(e) = stored {
+        debug!("Failed to cache transcription result in {:?}: {}", cache_file, e);
+    }
+}
+
+/// Cached transcript of a chunk (0-based index) of a large file
+fn chunk_transcript_path(cache_dir: &Path, index: usize) -> PathBuf {
+    cache_dir.join(format!("transcript_{}.txt", index + 1))
+}
+
+/// Timings file written next to a transcript with --timestamps (e.g. transcript.timestamps.json)
+pub fn timestamps_path(output_file: &Path) -> PathBuf {
+    output_file.with_extension("timestamps.json")
+}
+
+/// Pacing shared by every provider request of the run, for --adaptive-rate
+fn dispatch_rate(limits: utils::RateLimits) -> &'static utils::AdaptiveRate {
+    static RATE: OnceLock<utils::AdaptiveRate> = OnceLock::new();
+    RATE.get_or_init(|| utils::AdaptiveRate::new(limits))
+}
+
+/// Whether the provider turned a request away with 429 Too Many Requests
+fn is_rate_limited(error: &anyhow::Error) -> bool {
+    matches!(
+        error.downcast_ref::<TranscriptionError>(),
+        Some(TranscriptionError::Api { status, .. }) if *status == reqwest::StatusCode::TOO_MANY_REQUESTS
+    )
+}
+
+/// Audio endpoint under a base URL, keeping any query (e.g. Azure's api-version) at the end
+fn endpoint_url(api_base: &str, endpoint: &str) -> String {
+    match api_base.split_once('?') {
+        Some((base, query)) => format!("{}/audio/{}?{}", base.trim_end_matches('/'), endpoint, query),
+        None => format!("{}/audio/{}", api_base, endpoint),
+    }
+}
+
+/// Read a --prompt-file, cutting it at a word boundary to fit Whisper's prompt window
+pub fn load_prompt_file(path: &Path) -> Result<String> {
+    let content = fs::read_to_string(path)
+        .map_err(|e| anyhow::anyhow!("Failed to read prompt file {:?}: {}", path, e))?;
+    let prompt = content.trim();
+    
+    if prompt.is_empty() {
+        return Err(anyhow::anyhow!("Prompt file {:?} is empty", path));
+    }
+    
+    let length = prompt.chars().count();
+    if length <= PROMPT_MAX_CHARS {
+        return Ok(prompt.to_string());
+    }
+    
+    // Cut before the word that crosses the limit
+    let end = prompt.char_indices().nth(PROMPT_MAX_CHARS).map_or(prompt.len(), |(offset, _)| offset);
+    let kept = prompt[..end].rsplit_once(char::is_whitespace).map_or(&prompt[..end], |(kept, _)| kept).trim_end();
+    
+    warn!(
+        "Prompt file {:?} has {} characters but Whisper only uses about {}; the rest was dropped",
+        path, length, PROMPT_MAX_CHARS
+    );
+    Ok(kept.to_string())
+}
+
+/// Append a chunk's segments and words to the whole file's, shifted by the chunk's start
+/// 
+/// Timings the previous chunk already covered are dropped: one is kept only
+/// when its midpoint falls after the end of what's been merged so far, so
+/// the overlap between chunks is transcribed once.
+fn merge_chunk_timings(combined: &mut TranscriptionResponse, part: TranscriptionResponse, chunk_start: f64) {
+    combined.language = combined.language.take().or(part.language);
+    
+    let segments_end = combined.segments.last().map_or(0.0, |segment| segment.end);
+    combined.segments.extend(part.segments.into_iter().filter_map(|mut segment| {
+        segment.start += chunk_start;
+        segment.end += chunk_start;
+        ((segment.start + segment.end) / 2.0 >= segments_end).then_some(segment)
+    }));
+    
+    let words_end = combined.words.last().map_or(0.0, |word| word.end);
+    combined.words.extend(part.words.into_iter().filter_map(|mut word| {
+        word.start += chunk_start;
+        word.end += chunk_start;
+        ((word.start + word.end) / 2.0 >= words_end).then_some(word)
+    }));
+}
+
+/// Build a chunk prompt from the base prompt and the tail of the previous chunk's transcript
+/// 
+/// Whisper only looks at the last 224 tokens of a prompt, so the result is
+/// capped at roughly that many characters. The base prompt is kept whole and
+/// the carried tail is shortened, starting at a word boundary.
+fn carry_context_prompt(base_prompt: Option<&str>, previous_transcript: &str) -> String {
+    let base = base_prompt.unwrap_or("").trim();
+    let budget = PROMPT_MAX_CHARS.saturating_sub(base.chars().count() + 1);
+    
+    let previous = previous_transcript.trim();
+    let skip = previous.chars().count().saturating_sub(budget);
+    let mut tail: &str = previous.char_indices().nth(skip).map_or("", |(offset, _)| &previous[offset..]);
+    if skip > 0 {
+        tail = tail.split_once(char::is_whitespace).map_or("", |(_, rest)| rest.trim_start());
+    }
+    
+    match (base.is_empty(), tail.is_empty()) {
+        (true, _) => tail.to_string(),
+        (false, true) => base.to_string(),
+        (false, false) => format!("{} {}", base, tail),
+    }
+}
+
+/// MIME type for an uploaded audio file, from its contents or else the name it's sent as
+/// 
+/// Strict gateways check the part's content type, and temp files and
+/// --api-filename names don't always match what the file holds, so the
+/// magic bytes win when they're recognizably audio.
+fn mime_type_for(audio_file: &Path, file_name: &str) -> &'static str {
+    let sniffed = utils::sniff_file_type(audio_file)
+        .ok()
+        .flatten()
+        .filter(|file_type| utils::AUDIO_EXTENSIONS.contains(file_type));
+    
+    match sniffed {
+        Some(file_type) => mime_type_for_extension(file_type),
+        None => mime_type_for_extension(
+            &Path::new(file_name).extension().and_then(|ext| ext.to_str()).unwrap_or("").to_lowercase(),
+        ),
+    }
+}
+
+/// MIME type for an audio file extension, or application/octet-stream if it's unknown
+fn mime_type_for_extension(extension: &str) -> &'static str {
+    match extension {
+        "mp3" | "mpga" | "mpeg" => "audio/mpeg",
+        "wav" => "audio/wav",
+        "m4a" | "mp4" => "audio/mp4",
+        "ogg" | "oga" => "audio/ogg",
+        "flac" => "audio/flac",
+        "webm" => "audio/webm",
+        _ => "application/octet-stream",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::utils::stub_server::{self, Reply};
+    
+    /// Config pointing the OpenAI provider at a stub server, with its output directory
+    /// 
+    /// The result cache is off, so every test reaches its server and none write to the user's cache.
+    fn stub_config(api_base: &str) -> (Config, tempfile::TempDir) {
+        let dir = tempfile::tempdir().unwrap();
+        let mut config = Config::new(
+            Some("sk-test".to_string()),
+            None,
+            None,
+            None,
+            dir.path(),
+            Provider::Openai,
+            Some(format!("{}/v1", api_base)),
+        ).unwrap();
+        config.use_cache = false;
+        (config, dir)
+    }
+    
+    /// A short file with an MP3 (ID3) header to upload
+    fn audio_file(dir: &Path) -> PathBuf {
+        let path = dir.join("clip.mp3");
+        fs::write(&path, b"ID3\x04\x00\x00\x00\x00\x00\x00fake audio").unwrap();
+        path
+    }
+    
+    /// A whisper-1 transcription request for `file` with no optional fields
+    fn request_for(file: &Path, response_format: &str) -> TranscriptionRequest {
+        TranscriptionRequest {
+            file: file.to_path_buf(),
+            model: "whisper-1".to_string(),
+            language: None,
+            prompt: None,
+            response_format: response_format.to_string(),
+            temperature: 0.0,
+            timestamp_granularities: Vec::new(),
+            endpoint: "transcriptions",
+            audio: None,
+        }
+    }
+    
+    /// A field of a multipart/form-data body
+    struct FormPart {
+        name: String,
+        file_name: Option<String>,
+        content_type: Option<String>,
+        data: Vec<u8>,
+    }
+    
+    impl FormPart {
+        fn text(&self) -> &str {
+            std::str::from_utf8(&self.data).unwrap()
+        }
+    }
+    
+    /// Split a received multipart body into its parts, using the boundary from its content type
+    fn form_parts(request: &stub_server::Request) -> Vec<FormPart> {
+        let content_type = request.header("content-type").unwrap();
+        let boundary = format!("--{}", content_type.split_once("boundary=").unwrap().1.trim_matches('"'));
+        let body = &request.body;
+        
+        let starts: Vec<usize> = body.windows(boundary.len())
+            .enumerate()
+            .filter(|(_, window)| *window == boundary.as_bytes())
+            .map(|(index, _)| index)
+            .collect();
+        assert!(body[*starts.last().unwrap() + boundary.len()..].starts_with(b"--"), "missing closing boundary");
+        
+        starts.windows(2).map(|bounds| {
+            // Each part is "\r\n<headers>\r\n\r\n<data>\r\n" between two boundaries
+            let part = &body[bounds[0] + boundary.len() + 2..bounds[1] - 2];
+            let split = part.windows(4).position(|window| window == b"\r\n\r\n").unwrap();
+            let headers = String::from_utf8_lossy(&part[..split]).into_owned();
+            
+            let disposition = headers.lines().find(|line| line.to_lowercase().starts_with("content-disposition")).unwrap();
+            let attribute = |key: &str| {
+                disposition.split(';')
+                    .filter_map(|item| item.trim().strip_prefix(key))
+                    .find_map(|value| value.strip_prefix('='))
+                    .map(|value| value.trim_matches('"').to_string())
+            };
+            FormPart {
+                name: attribute("name").unwrap(),
+                file_name: attribute("filename"),
+                content_type: headers.lines()
+                    .find_map(|line| line.split_once(':').filter(|(key, _)| key.eq_ignore_ascii_case("content-type")))
+                    .map(|(_, value)| value.trim().to_string()),
+                data: part[split + 4..].to_vec(),
+            }
+        }).collect()
+    }
+    
+    /// Send `request` to a stub server and return the form fields it received
+    async fn sent_form(request: &TranscriptionRequest) -> Vec<FormPart> {
+        let (url, server) = stub_server::serve_once(Reply::ok("application/json", r#"{"text": "ok"}"#)).await;
+        let (config, _dir) = stub_config(&url);
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        
+        service.transcribe_via_api(Provider::Openai, request).await.unwrap();
+        form_parts(&server.await.unwrap())
+    }
+    
+    #[tokio::test]
+    async fn sends_every_multipart_field() {
+        let dir = tempfile::tempdir().unwrap();
+        let audio = audio_file(dir.path());
+        let request = TranscriptionRequest {
+            language: Some("de".to_string()),
+            prompt: Some("Hallo, Welt".to_string()),
+            temperature: 0.4,
+            timestamp_granularities: vec!["segment".to_string()],
+            ..request_for(&audio, "verbose_json")
+        };
+        
+        let parts = sent_form(&request).await;
+        let field = |name: &str| parts.iter().find(|part| part.name == name).map(FormPart::text);
+        
+        let file = parts.iter().find(|part| part.name == "file").unwrap();
+        assert_eq!(file.file_name.as_deref(), Some("clip.mp3"));
+        assert_eq!(file.content_type.as_deref(), Some("audio/mpeg"));
+        assert_eq!(file.data, fs::read(&audio).unwrap());
+        
+        assert_eq!(field("model"), Some("whisper-1"));
+        assert_eq!(field("language"), Some("de"));
+        assert_eq!(field("prompt"), Some("Hallo, Welt"));
+        assert_eq!(field("response_format"), Some("verbose_json"));
+        assert_eq!(field("temperature"), Some("0.4"));
+        assert_eq!(field("timestamp_granularities[]"), Some("segment"));
+    }
+    
+    #[tokio::test]
+    async fn sends_zero_temperature_and_omits_unset_fields() {
+        let dir = tempfile::tempdir().unwrap();
+        let request = request_for(&audio_file(dir.path()), "json");
+        
+        let parts = sent_form(&request).await;
+        let names: Vec<&str> = parts.iter().map(|part| part.name.as_str()).collect();
+        
+        assert_eq!(names, ["file", "model", "response_format", "temperature"]);
+        assert_eq!(parts[3].text(), "0");
+    }
+    
+    #[test]
+    fn redacts_captions_and_keeps_their_timings() {
+        let (mut config, dir) = stub_config("http://unused");
+        config.redact_pii = true;
+        config.output_formats = vec![OutputFormat::Text, OutputFormat::Srt];
+        let service = TranscriptionService::new(&config);
+        
+        let output_file = dir.path().join("call.txt");
+        fs::write(&output_file, "Call me Sam. My number is 555-123-4567.").unwrap();
+        fs::write(timestamps_path(&output_file), r#"{"text": "Call me Sam. My number is 555-123-4567.", "segments": [
+            {"start": 0.0, "end": 1.5, "text": "Call me Sam."},
+            {"start": 1.5, "end": 4.0, "text": "My number is 555-123-4567."}]}"#).unwrap();
+        
+        service.write_formats("call.mp3", &output_file).unwrap();
+        
+        let srt = fs::read_to_string(output_file.with_extension("srt")).unwrap();
+        assert!(srt.contains("00:00:00,000 --> 00:00:01,500\nCall me [NAME].\n"), "{}", srt);
+        assert!(srt.contains("00:00:01,500 --> 00:00:04,000\nMy number is [PHONE].\n"), "{}", srt);
+    }
+    
+    #[test]
+    fn content_type_comes_from_the_magic_bytes() {
+        let dir = tempfile::tempdir().unwrap();
+        let cases: [(&str, &[u8], &str); 6] = [
+            ("clip.bin", b"ID3\x04\x00\x00\x00\x00", "audio/mpeg"),
+            ("clip.mp3", b"RIFF\x24\x00\x00\x00WAVEfmt ", "audio/wav"),
+            ("clip", b"fLaC\x00\x00\x00\x22", "audio/flac"),
+            ("clip", b"OggS\x00\x02\x00\x00", "audio/ogg"),
+            ("clip", b"\x1a\x45\xdf\xa3\x01\x00", "audio/webm"),
+            ("clip", b"\x00\x00\x00\x20ftypM4A ", "audio/mp4"),
+        ];
+        
+        for (name, header, expected) in cases {
+            let path = dir.path().join(name);
+            fs::write(&path, header).unwrap();
+            assert_eq!(mime_type_for(&path, name), expected, "{}", name);
+        }
+    }
+    
+    #[test]
+    fn content_type_falls_back_to_the_extension() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("upload");
+        fs::write(&path, [0u8, 1, 2, 3]).unwrap();
+        
+        assert_eq!(mime_type_for(&path, "episode.M4A"), "audio/mp4");
+        assert_eq!(mime_type_for(&path, "episode.oga"), "audio/ogg");
+        assert_eq!(mime_type_for(&path, "episode.mpga"), "audio/mpeg");
+        assert_eq!(mime_type_for(&path, "episode.wav"), "audio/wav");
+        assert_eq!(mime_type_for(&path, "episode.xyz"), "application/octet-stream");
+        assert_eq!(mime_type_for(&path, "episode"), "application/octet-stream");
+    }
+    
+    #[tokio::test]
+    async fn sends_the_request_to_the_configured_api_base() {
+        let (url, server) = stub_server::serve_once(Reply::ok("text/plain", "Hello there")).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        
+        let response = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap();
+        let received = server.await.unwrap();
+        
+        assert_eq!(response.text, "Hello there");
+        assert_eq!(received.request_line(), "POST /v1/audio/transcriptions HTTP/1.1");
+        assert_eq!(received.header("authorization"), Some("Bearer sk-test"));
+        assert!(received.header("content-type").unwrap().starts_with("multipart/form-data; boundary="));
+    }
+    
+    #[tokio::test]
+    async fn parses_verbose_json_and_writes_the_transcript() {
+        let body = r#"{"text": " Hello there. ", "language": "english", "duration": 2.5,
+            "segments": [{"start": 0.0, "end": 2.5, "text": " Hello there."}]}"#;
+        let (url, server) = stub_server::serve_once(Reply::ok("application/json", body)).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        
+        let response = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "verbose_json")).await.unwrap();
+        server.await.unwrap();
+        
+        assert_eq!(response.language.as_deref(), Some("english"));
+        assert_eq!(response.duration, Some(2.5));
+        assert_eq!(response.segments.len(), 1);
+        
+        let output_file = dir.path().join("clip.txt");
+        service.write_response(&output_file, &response).unwrap();
+        assert_eq!(fs::read_to_string(&output_file).unwrap(), "Hello there.");
+    }
+    
+    #[tokio::test]
+    async fn reports_the_provider_error_message() {
+        let reply = Reply {
+            status: 400,
+            ..Reply::ok("application/json", r#"{"error": {"message": "Invalid file format."}}"#)
+        };
+        let (url, server) = stub_server::serve_once(reply).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        
+        let error = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap_err();
+        server.await.unwrap();
+        
+        match error.downcast_ref::<TranscriptionError>() {
+            Some(TranscriptionError::Api { status, message }) => {
+                assert_eq!(status.as_u16(), 400);
+                assert_eq!(message, "Invalid file format.");
+            }
+            other => panic!("expected an API error, got {:?}", other),
+        }
+    }
+    
+    #[test]
+    fn chunk_size_changes_invalidate_the_chunk_cache() {
+        let (mut config, dir) = stub_config("http://127.0.0.1:9");
+        let file = audio_file(dir.path());
+        
+        let cache_dir = |config: &Config| {
+            TranscriptionService { config, client: reqwest::Client::new(), held_audio: None }.chunk_cache_dir(&file, None).unwrap()
+        };
+        let default_dir = cache_dir(&config);
+        assert_eq!(cache_dir(&config), default_dir);
+        
+        config.chunk_size_mb = 10;
+        let smaller_dir = cache_dir(&config);
+        assert_ne!(smaller_dir, default_dir);
+        
+        // A larger size still plans the longest chunks, so the cache stays valid
+        config.chunk_size_mb = 25;
+        assert_eq!(cache_dir(&config), default_dir);
+    }
+    
+    #[test]
+    fn model_and_provider_changes_invalidate_the_chunk_cache() {
+        let (mut config, dir) = stub_config("http://127.0.0.1:9");
+        let file = audio_file(dir.path());
+        
+        let cache_dir = |config: &Config| {
+            TranscriptionService { config, client: reqwest::Client::new(), held_audio: None }.chunk_cache_dir(&file, None).unwrap()
+        };
+        let default_dir = cache_dir(&config);
+        
+        config.model = "gpt-4o-transcribe".to_string();
+        let other_model = cache_dir(&config);
+        assert_ne!(other_model, default_dir);
+        
+        config.provider = Provider::Groq;
+        config.api_base = Provider::Groq.default_api_base().to_string();
+        let other_provider = cache_dir(&config);
+        assert_ne!(other_provider, other_model);
+        
+        config.diarize = true;
+        let diarized = cache_dir(&config);
+        assert_ne!(diarized, other_provider);
+        
+        config.temperature = 0.2;
+        assert_ne!(cache_dir(&config), diarized);
+    }
+    
+    #[test]
+    fn carries_the_previous_transcript_after_the_base_prompt() {
+        assert_eq!(
+            carry_context_prompt(Some("Glossary: Kubernetes, Istio. "), "  Priya set up the Istio mesh.\n"),
+            "Glossary: Kubernetes, Istio. Priya set up the Istio mesh."
+        );
+        assert_eq!(carry_context_prompt(None, "Priya set up the mesh."), "Priya set up the mesh.");
+        assert_eq!(carry_context_prompt(Some("Glossary: Istio."), "   "), "Glossary: Istio.");
+    }
+    
+    #[test]
+    fn carries_only_the_tail_that_fits_the_prompt_limit() {
+        let previous: String = (0..300).map(|i| format!("café{} ", i)).collect();
+        let prompt = carry_context_prompt(Some("Glossary: Istio."), &previous);
+        
+        assert!(prompt.chars().count() <= PROMPT_MAX_CHARS);
+        assert!(prompt.ends_with("café299"));
+        
+        // The base prompt is kept whole and the tail starts on a whole word
+        let tail = prompt.strip_prefix("Glossary: Istio. ").unwrap();
+        assert!(previous.contains(&format!(" {} ", tail)));
+    }
+    
+    /// A chunk's verbose_json timings, relative to the chunk's start
+    fn chunk_part(segments: &[(f64, f64, &str)]) -> TranscriptionResponse {
+        TranscriptionResponse {
+            text: segments.iter().map(|(_, _, text)| *text).collect::<Vec<_>>().join(" "),
+            language: Some("en".to_string()),
+            duration: segments.last().map(|(_, end, _)| *end),
+            segments: segments.iter().map(|&(start, end, text)| Cue { start, end, text: text.to_string() }).collect(),
+            words: segments.iter().map(|&(start, end, text)| Word { word: text.to_string(), start, end }).collect(),
+            peaks: None,
+        }
+    }
+    
+    #[test]
+    fn merges_two_chunks_into_sequential_captions() {
+        let mut combined = TranscriptionResponse { text: String::new(), language: None, duration: Some(7.0), segments: Vec::new(), words: Vec::new(), peaks: None };
+        
+        // The second chunk starts a second early, so it hears "Second." again
+        merge_chunk_timings(&mut combined, chunk_part(&[(0.0, 2.0, "First."), (2.0, 4.0, "Second.")]), 0.0);
+        merge_chunk_timings(&mut combined, chunk_part(&[(0.0, 1.0, "Second."), (1.0, 4.0, "Third.")]), 3.0);
+        
+        let texts: Vec<&str> = combined.segments.iter().map(|segment| segment.text.as_str()).collect();
+        assert_eq!(texts, ["First.", "Second.", "Third."]);
+        assert_eq!(combined.words.len(), 3);
+        assert_eq!(combined.language.as_deref(), Some("en"));
+        
+        assert_eq!(
+            captions::write_srt(&combined.segments),
+            "1\n00:00:00,000 --> 00:00:02,000\nFirst.\n\n\
+             2\n00:00:02,000 --> 00:00:04,000\nSecond.\n\n\
+             3\n00:00:04,000 --> 00:00:07,000\nThird.\n\n"
+        );
+        assert!(captions::write_vtt(&combined.segments).ends_with("00:00:04.000 --> 00:00:07.000\nThird.\n\n"));
+    }
+    
+    /// verbose_json for a silent file: no text and no segments
+    const SILENT_RESPONSE: &str = r#"{"text": "  ", "language": "english", "duration": 4.0, "segments": []}"#;
+    
+    #[tokio::test]
+    async fn warns_on_an_empty_transcript_and_counts_it() {
+        let (url, _server) = stub_server::serve_once(Reply::ok("application/json", SILENT_RESPONSE)).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        let audio = audio_file(dir.path());
+        let output_file = dir.path().join("silent.txt");
+        
+        service.transcribe_single_file(&audio, &output_file, None, None).await.unwrap();
+        service.finish_output(&audio, &output_file).unwrap();
+        assert!(is_empty_transcript(&output_file));
+        
+        let mut report = crate::report::RunReport::new();
+        report.record("silent.mp3", &Ok(vec![output_file]));
+        assert_eq!(report.totals.transcripts, 1);
+        assert_eq!(report.totals.empty_transcripts, 1);
+    }
+    
+    #[tokio::test]
+    async fn fails_on_an_empty_transcript_when_asked() {
+        let (url, _server) = stub_server::serve_once(Reply::ok("application/json", SILENT_RESPONSE)).await;
+        let (mut config, dir) = stub_config(&url);
+        config.fail_on_empty = true;
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None };
+        let audio = audio_file(dir.path());
+        let output_file = dir.path().join("silent.txt");
+        
+        service.transcribe_single_file(&audio, &output_file, None, None).await.unwrap();
+        let error = service.finish_output(&audio, &output_file).unwrap_err();
+        assert!(error.to_string().contains("is empty"), "{}", error);
+        assert!(!is_empty_transcript(&output_file));
+    }
+    
+    /// Formats with a golden file under testdata/golden
+    const GOLDEN_FORMATS: [OutputFormat; 4] = [OutputFormat::Srt, OutputFormat::Vtt, OutputFormat::Json, OutputFormat::PodscriptJson];
+    
+    /// Render every format from the verbose_json fixture into a fresh directory
+    fn render_golden_fixture() -> Vec<(OutputFormat, String)> {
+        let golden = Path::new(env!("CARGO_MANIFEST_DIR")).join("testdata/golden");
+        let (mut config, dir) = stub_config("http://unused");
+        // Pins podscript-json's created_at, as SOURCE_DATE_EPOCH=1700000000 would
+        config.output_epoch = Some(1_700_000_000);
+        config.output_formats = [OutputFormat::Text].into_iter().chain(GOLDEN_FORMATS).collect();
+        let service = TranscriptionService::new(&config);
+        
+        let output_file = dir.path().join("episode.txt");
+        fs::write(&output_file, "Welcome back to the show.").unwrap();
+        fs::copy(golden.join("verbose.json"), timestamps_path(&output_file)).unwrap();
+        service.write_formats("episode.mp3", &output_file).unwrap();
+        
+        GOLDEN_FORMATS.iter()
+            .map(|format| (*format, fs::read_to_string(output_file.with_extension(format.extension())).unwrap()))
+            .collect()
+    }
+    
+    #[test]
+    fn every_format_matches_its_golden_file_on_every_run() {
+        let golden = Path::new(env!("CARGO_MANIFEST_DIR")).join("testdata/golden");
+        
+        let first = render_golden_fixture();
+        assert_eq!(first, render_golden_fixture());
+        
+        for (format, rendered) in first {
+            let path = golden.join(format!("episode.{}", format.extension()));
+            // PODSCRIPT_UPDATE_GOLDEN=1 rewrites the files after an intended change
+            if std::env::var_os("PODSCRIPT_UPDATE_GOLDEN").is_some() {
+                fs::write(&path, &rendered).unwrap();
+            }
+            assert_eq!(rendered, fs::read_to_string(&path).unwrap(), "{:?} differs from its golden file", format);
+        }
+    }
+    
+    #[test]
+    fn output_specs_get_their_own_options() {
+        let (mut config, dir) = stub_config("http://unused");
+        config.max_cue_duration = Some(60.0);
+        config.output_formats = vec![OutputFormat::Text, OutputFormat::Srt];
+        config.output_specs = vec![
+            "srt:{name}.short.srt:maxcue=2s".parse().unwrap(),
+            "txt:read/{name}.txt:paragraphs".parse().unwrap(),
+        ];
+        let service = TranscriptionService::new(&config);
+        
+        let output_file = dir.path().join("episode.txt");
+        fs::write(&output_file, "Welcome back. Let's start.").unwrap();
+        fs::write(timestamps_path(&output_file), r#"{"text": "Welcome back. Let's start.", "segments": [
+            {"start": 0.0, "end": 4.0, "text": " Welcome back."},
+            {"start": 6.0, "end": 7.0, "text": " Let's start."}]}"#).unwrap();
+        
+        service.write_formats("episode.mp3", &output_file).unwrap();
+        
+        // --max-cue-duration applies to the regular SRT, maxcue=2s to the spec's
+        let srt = fs::read_to_string(dir.path().join("episode.srt")).unwrap();
+        assert_eq!(captions::parse_srt(&srt).unwrap().len(), 2);
+        let short = fs::read_to_string(dir.path().join("episode.short.srt")).unwrap();
+        assert_eq!(captions::parse_srt(&short).unwrap().len(), 3, "{}", short);
+        
+        let read = fs::read_to_string(dir.path().join("read/episode.txt")).unwrap();
+        assert_eq!(read, "Welcome back.\n\nLet's start.");
+    }
+    
+    #[test]
+    fn max_output_bytes_caps_parts_and_spec_text_files() {
+        let (mut config, dir) = stub_config("http://unused");
+        config.max_output_bytes = Some(40);
+        config.split_output_every = Some(SplitEvery::Words(12));
+        config.output_specs = vec!["txt:{name}.copy.txt".parse().unwrap()];
+        let service = TranscriptionService::new(&config);
+        
+        let sentence = "This sentence has exactly eight words in it.";
+        let segments: Vec<String> = (0..4)
+            .map(|i| format!(r#"{{"start": {}.0, "end": {}.5, "text": " {}"}}"#, i * 5, i * 5 + 4, sentence))
+            .collect();
+        let text = vec![sentence; 4].join(" ");
+        let output_file = dir.path().join("episode.txt");
+        fs::write(&output_file, &text).unwrap();
+        fs::write(timestamps_path(&output_file), format!(r#"{{"text": "{}", "segments": [{}]}}"#, text, segments.join(", "))).unwrap();
+        
+        service.write_formats("episode.mp3", &output_file).unwrap();
+        service.finish_output(Path::new("episode.mp3"), &output_file).unwrap();
+        
+        let parts = output::part_files(&output_file);
+        assert_eq!(parts.len(), 2);
+        for file in [output_file.clone(), dir.path().join("episode.copy.txt")].iter().chain(&parts) {
+            let written = fs::read_to_string(file).unwrap();
+            assert!(written.len() <= 40, "{:?} is {} bytes", file, written.len());
+            assert!(written.starts_with("This sentence"), "{:?}: {}", file, written);
+        }
+    }
+    
+    #[tokio::test]
+    async fn adaptive_rate_retries_a_rate_limited_request_more_slowly() {
+        let limited = Reply {
+            status: 429,
+            ..Reply::ok("application/json", r#"{"error": {"message": "Rate limit reached"}}"#)
+        };
+        let (url, server) = stub_server::serve_each(vec![limited, Reply::ok("text/plain", "Hello there.")]).await;
+        let (mut config, dir) = stub_config(&url);
+        config.adaptive_rate = Some(utils::RateLimits { initial: 3000.0, min: 100.0, max: 6000.0 });
+        let service = TranscriptionService::new(&config);
+        
+        let response = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap();
+        
+        assert_eq!(response.text, "Hello there.");
+        assert_eq!(server.await.unwrap().len(), 2);
+        // Halved by the 429, then one back for the success
+        assert_eq!(dispatch_rate(config.adaptive_rate.unwrap()).rate(), 1501.0);
+    }
+    
+    #[tokio::test]
+    async fn retries_a_transcription_on_a_configured_status() {
+        let unavailable = Reply {
+            status: 503,
+            ..Reply::ok("application/json", r#"{"error": {"message": "Service unavailable"}}"#)
+        };
+        let (url, server) = stub_server::serve_each(vec![unavailable, Reply::ok("text/plain", "Hello there.")]).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService::new(&config);
+        
+        let response = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap();
+        
+        assert_eq!(response.text, "Hello there.");
+        let requests = server.await.unwrap();
+        assert_eq!(requests.len(), 2);
+        let file = |request: &stub_server::Request| form_parts(request).into_iter().find(|part| part.name == "file").unwrap().data;
+        assert_eq!(file(&requests[0]), file(&requests[1]));
+        // The retry is the same request as far as the provider is concerned
+        let key = requests[0].header("idempotency-key").unwrap();
+        assert_eq!(key.len(), 32);
+        assert_eq!(requests[1].header("idempotency-key"), Some(key));
+    }
+    
+    #[tokio::test]
+    async fn separate_transcriptions_get_separate_idempotency_keys() {
+        let (url, server) = stub_server::serve_each(vec![Reply::ok("text/plain", "One."), Reply::ok("text/plain", "Two.")]).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService::new(&config);
+        let request = request_for(&audio_file(dir.path()), "text");
+        
+        service.transcribe_via_api(Provider::Openai, &request).await.unwrap();
+        service.transcribe_via_api(Provider::Openai, &request).await.unwrap();
+        
+        let requests = server.await.unwrap();
+        assert_ne!(requests[0].header("idempotency-key"), requests[1].header("idempotency-key"));
+    }
+    
+    #[tokio::test]
+    async fn does_not_retry_a_transcription_on_other_statuses() {
+        let (url, server) = stub_server::serve_once(Reply {
+            status: 400,
+            ..Reply::ok("application/json", r#"{"error": {"message": "Invalid file format"}}"#)
+        }).await;
+        let (mut config, dir) = stub_config(&url);
+        config.retry = utils::RetryPolicy::new(2, vec![503]).unwrap();
+        let service = TranscriptionService::new(&config);
+        
+        let error = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap_err();
+        
+        assert!(error.to_string().contains("Invalid file format"), "{}", error);
+        server.await.unwrap();
+    }
+    
+    #[tokio::test]
+    async fn retries_a_transcription_after_a_reset_connection() {
+        let (url, server) = stub_server::serve_after_resets(1, Reply::ok("text/plain", "Hello there.")).await;
+        let (config, dir) = stub_config(&url);
+        let service = TranscriptionService::new(&config);
+        
+        let response = service.transcribe_via_api(Provider::Openai, &request_for(&audio_file(dir.path()), "text")).await.unwrap();
+        
+        assert_eq!(response.text, "Hello there.");
+        server.await.unwrap();
+    }
+    
+    #[tokio::test]
+    async fn transcribes_audio_held_in_memory() {
+        let (url, server) = stub_server::serve_once(Reply::ok("application/json", r#"{"text": "held"}"#)).await;
+        let (config, dir) = stub_config(&url);
+        let output = dir.path().join("transcript.txt");
+        let audio = b"ID3\x04\x00\x00\x00\x00\x00\x00held audio".to_vec();
+        
+        // The name only supplies the upload's file name; nothing exists there
+        let service = TranscriptionService { config: &config, client: reqwest::Client::new(), held_audio: None }.holding(Some(audio.clone()));
+        service.transcribe_file(Path::new("stdin_capture.mp3"), &output).await.unwrap();
+        assert_eq!(fs::read_to_string(&output).unwrap().trim(), "held");
+        
+        let parts = form_parts(&server.await.unwrap());
+        let file = parts.iter().find(|part| part.name == "file").unwrap();
+        assert_eq!(file.file_name.as_deref(), Some("stdin_capture.mp3"));
+        assert_eq!(file.data, audio);
+    }
 }
@@ -1,9 +1,22 @@
 use anyhow::Result;
-use log::debug;
+use futures::StreamExt;
+use indicatif::{MultiProgress, ProgressBar, ProgressDrawTarget, ProgressStyle};
+use log::{debug, warn};
 use regex::Regex;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
 use std::fs;
 use std::path::{Path, PathBuf};
 use std::process::Command;
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, Mutex, OnceLock};
+use std::time::{Duration, Instant};
+use thiserror::Error;
+use tokio::io::AsyncReadExt;
+use tokio::sync::{OwnedSemaphorePermit, Semaphore};
+
+use crate::config::TranscodeFormat;
 
 /// Sanitize a string for use as a filename or directory name
 /// 
@@ -28,116 +41,2057 @@ pub fn sanitize_filename(input: &str) -> String {
     re_trailing.replace_all(&with_underscores, "").to_string()
 }
 
-/// Download a file from a URL
-pub async fn download_file(url: &str, output_path: &Path) -> Result<()> {
-    debug!("Downloading file from {} to {:?}", url, output_path);
+/// HTTP status codes retried by default
+pub const DEFAULT_RETRY_STATUS_CODES: [u16; 5] = [429, 500, 502, 503, 504];
+
+/// How transient request failures are retried
+#[derive(Debug, Clone)]
+pub struct RetryPolicy {
+    /// Number of retries after the first attempt
+    pub retries: u32,
+    /// HTTP status codes that are worth retrying
+    pub status_codes: Vec<u16>,
+}
+
+impl RetryPolicy {
+    /// Create a retry policy, validating the status codes
+    pub fn new(retries: u32, status_codes: Vec<u16>) -> Result<Self> {
+        if let Some(code) = status_codes.iter().find(|code| !(100..=599).contains(*code)) {
+            return Err(anyhow::anyhow!("Invalid retry status code: {} (must be between 100 and 599)", code));
+        }
+        
+        Ok(Self { retries, status_codes })
+    }
+}
+
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        Self {
+            retries: 3,
+            status_codes: DEFAULT_RETRY_STATUS_CODES.to_vec(),
+        }
+    }
+}
+
+/// Bounds of --adaptive-rate, in provider requests per minute
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct RateLimits {
+    pub initial: f64,
+    pub min: f64,
+    pub max: f64,
+}
+
+/// Requests per minute the adaptive rate gains back with each success
+const RATE_INCREASE_PER_SUCCESS: f64 = 1.0;
+
+/// Pacing of provider requests that adapts to rate limiting
+/// 
+/// Requests are spaced evenly at the current rate. Each success adds
+/// `RATE_INCREASE_PER_SUCCESS` requests per minute up to the maximum, and
+/// each 429 halves the rate down to the minimum (additive increase,
+/// multiplicative decrease), so a batch settles just under the account's limit.
+#[derive(Debug)]
+pub struct AdaptiveRate {
+    limits: RateLimits,
+    state: Mutex<RateState>,
+}
+
+#[derive(Debug)]
+struct RateState {
+    /// Requests per minute
+    rate: f64,
+    /// Earliest time the next request may be sent
+    next_slot: Option<Instant>,
+}
+
+impl AdaptiveRate {
+    pub fn new(limits: RateLimits) -> Self {
+        Self { limits, state: Mutex::new(RateState { rate: limits.initial, next_slot: None }) }
+    }
+    
+    /// Current rate in requests per minute
+    pub fn rate(&self) -> f64 {
+        self.state.lock().unwrap().rate
+    }
+    
+    /// Wait for a request's turn
+    pub async fn acquire(&self) {
+        let wait = self.reserve(Instant::now());
+        if !wait.is_zero() {
+            tokio::time::sleep(wait).await;
+        }
+    }
+    
+    /// Take the next free slot, returning how long until it comes up
+    fn reserve(&self, now: Instant) -> Duration {
+        let mut state = self.state.lock().unwrap();
+        let slot = state.next_slot.map_or(now, |next| next.max(now));
+        state.next_slot = Some(slot + Duration::from_secs_f64(60.0 / state.rate));
+        slot - now
+    }
+    
+    /// Ramp back up after a request got through
+    pub fn succeeded(&self) {
+        let mut state = self.state.lock().unwrap();
+        let rate = (state.rate + RATE_INCREASE_PER_SUCCESS).min(self.limits.max);
+        if rate != state.rate {
+            debug!("Raising the request rate from {:.1} to {:.1} per minute", state.rate, rate);
+            state.rate = rate;
+        }
+    }
+    
+    /// Back off after the provider answered 429
+    pub fn throttled(&self) {
+        let mut state = self.state.lock().unwrap();
+        let rate = (state.rate / 2.0).max(self.limits.min);
+        debug!("Rate limited; lowering the request rate from {:.1} to {:.1} per minute", state.rate, rate);
+        state.rate = rate;
+        
+        // Requests already waiting keep their slots, but the next one waits a full interval
+        let now = Instant::now();
+        state.next_slot = Some(state.next_slot.map_or(now, |next| next.max(now)) + Duration::from_secs_f64(60.0 / rate));
+    }
+}
+
+/// Audio file extensions the Whisper API accepts
+pub const AUDIO_EXTENSIONS: &[&str] = &["mp3", "mp4", "mpeg", "mpga", "m4a", "wav", "webm", "ogg", "oga", "flac"];
+
+/// Recognize a file's type from its first bytes, as a file extension ("text" for plain text)
+/// 
+/// Returns None when the type isn't one of the few recognized signatures.
+pub fn sniff_file_type(path: &Path) -> Result<Option<&'static str>> {
+    let mut header = [0u8; 16];
+    let read = std::io::Read::read(&mut fs::File::open(path)?, &mut header)?;
+    Ok(sniff_bytes(&header[..read]))
+}
+
+/// Recognize data's type from its first bytes, like `sniff_file_type`
+pub fn sniff_bytes(data: &[u8]) -> Option<&'static str> {
+    let header = &data[..data.len().min(16)];
+    match header {
+        [b'I', b'D', b'3', ..] => Some("mp3"),
+        [0xFF, second, ..] if second & 0xE0 == 0xE0 && second & 0x06 != 0 => Some("mp3"),
+        [b'R', b'I', b'F', b'F', _, _, _, _, b'W', b'A', b'V', b'E', ..] => Some("wav"),
+        [b'f', b'L', b'a', b'C', ..] => Some("flac"),
+        [b'O', b'g', b'g', b'S', ..] => Some("ogg"),
+        [0x1A, 0x45, 0xDF, 0xA3, ..] => Some("webm"),
+        [_, _, _, _, b'f', b't', b'y', b'p', ..] => Some("m4a"),
+        [b'P', b'K', 0x03, 0x04, ..] => Some("zip"),
+        [b'%', b'P', b'D', b'F', ..] => Some("pdf"),
+        [0x1F, 0x8B, ..] => Some("gz"),
+        [0x89, b'P', b'N', b'G', ..] => Some("png"),
+        [0xFF, 0xD8, 0xFF, ..] => Some("jpg"),
+        [b'G', b'I', b'F', b'8', ..] => Some("gif"),
+        [b'R', b'a', b'r', b'!', ..] => Some("rar"),
+        [b'7', b'z', 0xBC, 0xAF, ..] => Some("7z"),
+        _ if !header.is_empty() && header.iter().all(|&byte| byte.is_ascii_graphic() || byte.is_ascii_whitespace()) => Some("text"),
+        _ => None,
+    }
+}
+
+/// Refuse files the Whisper API won't accept, before uploading them
+/// 
+/// The extension must be a supported one, and the contents must not be a
+/// recognizably different, non-audio type (an archive, document, image or
+/// text). Audio whose contents don't match its extension is let through.
+pub fn check_audio_format(path: &Path) -> Result<()> {
+    check_audio_type(path, sniff_file_type(path)?)
+}
+
+/// Refuse audio held in memory that the Whisper API won't accept, as `check_audio_format` does for files
+pub fn check_audio_bytes(path: &Path, data: &[u8]) -> Result<()> {
+    check_audio_type(path, sniff_bytes(data))
+}
+
+/// Check the extension of `path` and the type its contents were `sniffed` as
+fn check_audio_type(path: &Path, sniffed: Option<&'static str>) -> Result<()> {
+    let extension = path.extension()
+        .and_then(|ext| ext.to_str())
+        .unwrap_or("")
+        .to_lowercase();
     
-    // Create parent directory if it doesn't exist
-    if let Some(parent) = output_path.parent() {
-        fs::create_dir_all(parent)?;
+    if !AUDIO_EXTENSIONS.contains(&extension.as_str()) {
+        let detected = match sniffed {
+            Some(file_type) => format!(" (its contents look like {})", file_type),
+            None => String::new(),
+        };
+        return Err(anyhow::anyhow!(
+            "{:?} has an unsupported file type {:?}{}; supported types are {} (--skip-format-check to send it anyway)",
+            path, extension, detected, AUDIO_EXTENSIONS.join(", ")
+        ));
     }
     
-    // Download file using reqwest
-    let response = reqwest::get(url).await?;
-    let bytes = response.bytes().await?;
-    fs::write(output_path, &bytes)?;
+    if let Some(file_type) = sniffed.filter(|file_type| !AUDIO_EXTENSIONS.contains(file_type)) {
+        return Err(anyhow::anyhow!(
+            "{:?} is named .{} but its contents look like {}, not audio (--skip-format-check to send it anyway)",
+            path, extension, file_type
+        ));
+    }
     
     Ok(())
 }
 
-/// Check if a command is available
-pub fn check_command(command: &str) -> bool {
-    let output = if cfg!(target_os = "windows") {
-        Command::new("where")
-            .arg(command)
-            .output()
+/// Default limit on establishing a connection (TCP and TLS), in seconds
+pub const DEFAULT_CONNECT_TIMEOUT_SECS: u64 = 30;
+
+/// Default deadline for a single transcription request (one file or chunk), in seconds
+pub const DEFAULT_REQUEST_TIMEOUT_SECS: u64 = 30 * 60;
+
+/// Default wait for response headers once a provider request is fully sent, in seconds
+pub const DEFAULT_RESPONSE_TIMEOUT_SECS: u64 = 10 * 60;
+
+/// Default limit on reading a provider's response body, in seconds
+pub const DEFAULT_READ_TIMEOUT_SECS: u64 = 2 * 60;
+
+/// Limits on the phases of a provider request after the connection is made
+/// 
+/// They're separate so a slow upload isn't cut off by a short response
+/// timeout, and a server that answers quickly but then stalls isn't waited
+/// on for the whole request deadline.
+#[derive(Debug, Clone, Copy)]
+pub struct HttpTimeouts {
+    /// Wait for the response headers, counted from when the upload finishes
+    pub response: Duration,
+    /// Reading the whole response body once the headers are in
+    pub read: Duration,
+}
+
+impl Default for HttpTimeouts {
+    fn default() -> Self {
+        Self {
+            response: Duration::from_secs(DEFAULT_RESPONSE_TIMEOUT_SECS),
+            read: Duration::from_secs(DEFAULT_READ_TIMEOUT_SECS),
+        }
+    }
+}
+
+/// A provider request phase that ran past its limit
+#[derive(Debug, Error)]
+pub enum HttpTimeoutError {
+    #[error("{url} sent no response within {seconds} seconds of receiving the request; raise --response-timeout if the provider is just slow")]
+    Response { url: String, seconds: u64 },
+    #[error("Reading the response from {url} took more than {seconds} seconds; raise --read-timeout if the connection is just slow")]
+    Read { url: String, seconds: u64 },
+}
+
+/// Parse a duration like `90`, `90s`, `10m`, `1h` or `1h30m` (a bare number is seconds)
+pub fn parse_duration(value: &str) -> Result<Duration, String> {
+    let invalid = || format!("expected a duration like 90s, 10m or 1h30m, got '{}'", value);
+    let mut seconds = 0u64;
+    let mut digits = String::new();
+    
+    for c in value.trim().chars() {
+        if c.is_ascii_digit() {
+            digits.push(c);
+            continue;
+        }
+        
+        let unit = match c.to_ascii_lowercase() {
+            's' => 1,
+            'm' => 60,
+            'h' => 3600,
+            _ => return Err(invalid()),
+        };
+        let amount: u64 = digits.parse().map_err(|_| invalid())?;
+        seconds += amount * unit;
+        digits.clear();
+    }
+    
+    if !digits.is_empty() {
+        seconds += digits.parse::<u64>().map_err(|_| invalid())?;
+    }
+    
+    if seconds == 0 {
+        return Err(invalid());
+    }
+    
+    Ok(Duration::from_secs(seconds))
+}
+
+/// Parse a path argument, expanding a leading `~` to the home directory
+/// 
+/// The shell only does this for unquoted words, so values from `.env`, the
+/// environment or `--flag=~/...` would otherwise keep a literal `~`.
+pub fn parse_path(value: &str) -> Result<PathBuf, String> {
+    Ok(expand_home(value))
+}
+
+/// Replace a leading `~` or `~/` with $HOME (other paths are returned unchanged)
+pub fn expand_home(value: &str) -> PathBuf {
+    let home = std::env::var_os("HOME").filter(|home| !home.is_empty());
+    match (value.strip_prefix('~'), home) {
+        (Some(""), Some(home)) => PathBuf::from(home),
+        (Some(rest), Some(home)) if rest.starts_with('/') => PathBuf::from(home).join(&rest[1..]),
+        _ => PathBuf::from(value),
+    }
+}
+
+/// HTTP client shared by all requests, so connections are pooled and timeouts apply everywhere
+static HTTP_CLIENT: OnceLock<reqwest::Client> = OnceLock::new();
+
+/// Build the shared HTTP client; call once at startup before any request
+pub fn init_http_client(connect_timeout: Duration) -> Result<()> {
+    HTTP_CLIENT
+        .set(build_http_client(connect_timeout)?)
+        .map_err(|_| anyhow::anyhow!("HTTP client already initialized"))
+}
+
+/// The shared HTTP client (with default settings if it wasn't initialized)
+pub fn http_client() -> reqwest::Client {
+    HTTP_CLIENT
+        .get_or_init(|| build_http_client(Duration::from_secs(DEFAULT_CONNECT_TIMEOUT_SECS)).unwrap_or_default())
+        .clone()
+}
+
+/// An HTTP client whose connections must be set up within `connect_timeout`
+/// 
+/// reqwest applies the connect timeout to the whole connector: DNS, TCP and
+/// the TLS handshake (and a proxy's CONNECT) all count against it, so a
+/// server that stalls the handshake fails as a connect error and there's no
+/// separate TLS timeout. It only bounds connection setup, so slow uploads and
+/// long transcriptions aren't cut off by it; `send_request` and `read_body`
+/// limit those.
+fn build_http_client(connect_timeout: Duration) -> reqwest::Result<reqwest::Client> {
+    reqwest::Client::builder()
+        .connect_timeout(connect_timeout)
+        .build()
+}
+
+/// Send a request, failing if the response headers don't arrive within `timeouts.response`
+/// 
+/// With an `upload` bar from `upload_body`, the wait only starts once the
+/// whole body has been handed over, so large uploads on slow links aren't
+/// counted against it.
+pub async fn send_request(
+    request: reqwest::RequestBuilder,
+    upload: Option<&ProgressBar>,
+    timeouts: &HttpTimeouts,
+) -> Result<reqwest::Response> {
+    let (client, request) = request.build_split();
+    let request = request?;
+    let url = request.url().to_string();
+    // Streamed uploads only know their size from the progress bar
+    let sent_bytes = request.body().and_then(reqwest::Body::as_bytes).map(<[u8]>::len)
+        .or_else(|| upload.and_then(ProgressBar::length).map(|length| length as usize))
+        .unwrap_or(0);
+    let started = Instant::now();
+    let mut send = std::pin::pin!(client.execute(request));
+    
+    // Each wait that ends mid-upload starts another, so the last one begins after the upload
+    let uploading = || upload.is_some_and(|bar| bar.position() < bar.length().unwrap_or(0));
+    let mut was_uploading = uploading();
+    loop {
+        match tokio::time::timeout(timeouts.response, &mut send).await {
+            Ok(response) => {
+                record_http_request(started.elapsed(), sent_bytes as u64);
+                return Ok(response?);
+            }
+            Err(_) if was_uploading => was_uploading = uploading(),
+            Err(_) => return Err(HttpTimeoutError::Response { url, seconds: timeouts.response.as_secs() }.into()),
+        }
+    }
+}
+
+/// A new value for the Idempotency-Key header, to send unchanged on every retry of one request
+/// 
+/// Providers that honour it return the first result rather than transcribing
+/// (and billing) the audio again when a retried upload had in fact arrived.
+pub fn idempotency_key() -> String {
+    static SEQUENCE: AtomicU64 = AtomicU64::new(0);
+    // RandomState is seeded from the OS, so keys differ between hosts as well as runs
+    let random = std::hash::BuildHasher::hash_one(&std::collections::hash_map::RandomState::new(), SEQUENCE.fetch_add(1, Ordering::Relaxed));
+    let mut hasher = Sha256::new();
+    hasher.update(format!("{} {:?} {}", random, std::time::SystemTime::now(), std::process::id()));
+    hex::encode(&hasher.finalize()[..16])
+}
+
+/// Read a response body, failing if it takes longer than `timeouts.read`
+pub async fn read_body(response: reqwest::Response, timeouts: &HttpTimeouts) -> Result<Vec<u8>> {
+    let url = response.url().to_string();
+    match tokio::time::timeout(timeouts.read, response.bytes()).await {
+        Ok(body) => {
+            let body = body?;
+            record_http_download(body.len() as u64);
+            Ok(body.to_vec())
+        }
+        Err(_) => Err(HttpTimeoutError::Read { url, seconds: timeouts.read.as_secs() }.into()),
+    }
+}
+
+/// Counters behind --metrics, shared by every request
+#[derive(Debug, Default)]
+struct HttpCounters {
+    requests: AtomicU64,
+    retries: AtomicU64,
+    bytes_up: AtomicU64,
+    bytes_down: AtomicU64,
+    /// Time from sending each request to its response headers
+    latencies: Mutex<Vec<Duration>>,
+    /// Uploads that had to wait for a --max-concurrent-uploads slot, and the total wait in milliseconds
+    upload_waits: AtomicU64,
+    upload_wait_ms: AtomicU64,
+}
+
+/// Whether requests are counted (--metrics); off, recording is a single load
+static HTTP_METRICS_ENABLED: AtomicBool = AtomicBool::new(false);
+
+fn http_counters() -> Option<&'static HttpCounters> {
+    static COUNTERS: OnceLock<HttpCounters> = OnceLock::new();
+    HTTP_METRICS_ENABLED.load(Ordering::Relaxed).then(|| COUNTERS.get_or_init(HttpCounters::default))
+}
+
+/// Count requests, retries and bytes for `http_metrics`; call once at startup
+pub fn enable_http_metrics() {
+    HTTP_METRICS_ENABLED.store(true, Ordering::Relaxed);
+}
+
+/// Count a request that got a response after `latency`, having sent `bytes`
+fn record_http_request(latency: Duration, bytes: u64) {
+    if let Some(counters) = http_counters() {
+        counters.requests.fetch_add(1, Ordering::Relaxed);
+        counters.bytes_up.fetch_add(bytes, Ordering::Relaxed);
+        counters.latencies.lock().unwrap().push(latency);
+    }
+}
+
+/// Count response body bytes read
+fn record_http_download(bytes: u64) {
+    if let Some(counters) = http_counters() {
+        counters.bytes_down.fetch_add(bytes, Ordering::Relaxed);
+    }
+}
+
+/// Count the time an upload waited for a slot
+fn record_upload_wait(waited: Duration) {
+    if let Some(counters) = http_counters().filter(|_| !waited.is_zero()) {
+        counters.upload_waits.fetch_add(1, Ordering::Relaxed);
+        counters.upload_wait_ms.fetch_add(waited.as_millis() as u64, Ordering::Relaxed);
+    }
+}
+
+/// Count a request about to be retried
+pub fn record_http_retry() {
+    if let Some(counters) = http_counters() {
+        counters.retries.fetch_add(1, Ordering::Relaxed);
+    }
+}
+
+/// HTTP traffic of a run so far, included in the run report with --metrics
+#[derive(Debug, Clone, Serialize)]
+pub struct HttpMetrics {
+    pub requests: u64,
+    pub retries: u64,
+    pub bytes_uploaded: u64,
+    pub bytes_downloaded: u64,
+    /// Median time to the response headers, in milliseconds
+    pub latency_p50_ms: Option<f64>,
+    /// 95th percentile time to the response headers, in milliseconds
+    pub latency_p95_ms: Option<f64>,
+    /// Uploads that waited for a --max-concurrent-uploads slot
+    pub upload_waits: u64,
+    /// Total time uploads spent waiting for a slot, in seconds
+    pub upload_wait_seconds: f64,
+}
+
+/// The run's HTTP metrics, or None without --metrics
+pub fn http_metrics() -> Option<HttpMetrics> {
+    let counters = http_counters()?;
+    let mut latencies = counters.latencies.lock().unwrap().clone();
+    latencies.sort();
+    
+    Some(HttpMetrics {
+        requests: counters.requests.load(Ordering::Relaxed),
+        retries: counters.retries.load(Ordering::Relaxed),
+        bytes_uploaded: counters.bytes_up.load(Ordering::Relaxed),
+        bytes_downloaded: counters.bytes_down.load(Ordering::Relaxed),
+        latency_p50_ms: percentile(&latencies, 50),
+        latency_p95_ms: percentile(&latencies, 95),
+        upload_waits: counters.upload_waits.load(Ordering::Relaxed),
+        upload_wait_seconds: counters.upload_wait_ms.load(Ordering::Relaxed) as f64 / 1000.0,
+    })
+}
+
+/// Nearest-rank percentile of sorted durations, in milliseconds
+fn percentile(sorted: &[Duration], percent: usize) -> Option<f64> {
+    let rank = (sorted.len() * percent).div_ceil(100).max(1);
+    sorted.get(rank - 1).map(|latency| latency.as_secs_f64() * 1000.0)
+}
+
+impl std::fmt::Display for HttpMetrics {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(
+            f,
+            "{} requests, {} retries, {:.1} MB up, {:.1} MB down",
+            self.requests, self.retries,
+            self.bytes_uploaded as f64 / 1_048_576.0, self.bytes_downloaded as f64 / 1_048_576.0
+        )?;
+        if let (Some(p50), Some(p95)) = (self.latency_p50_ms, self.latency_p95_ms) {
+            write!(f, ", latency p50 {:.0} ms, p95 {:.0} ms", p50, p95)?;
+        }
+        if self.upload_waits > 0 {
+            write!(f, ", {} uploads waited {:.1}s for a slot", self.upload_waits, self.upload_wait_seconds)?;
+        }
+        Ok(())
+    }
+}
+
+/// Bytes handed to the HTTP client at a time when uploading, so progress moves smoothly
+const UPLOAD_CHUNK_BYTES: usize = 64 * 1024;
+
+/// Display shared by all progress bars, so concurrent transcriptions don't draw over each other
+fn progress_display() -> &'static MultiProgress {
+    static DISPLAY: OnceLock<MultiProgress> = OnceLock::new();
+    DISPLAY.get_or_init(|| MultiProgress::with_draw_target(ProgressDrawTarget::stderr()))
+}
+
+/// Spinner on stderr showing `message` and the elapsed time, or a hidden one if `enabled` is false
+pub fn spinner(enabled: bool, message: String) -> ProgressBar {
+    if !enabled {
+        return ProgressBar::hidden();
+    }
+    
+    let bar = progress_display().add(ProgressBar::new_spinner());
+    bar.set_style(ProgressStyle::with_template("{spinner} {msg} ({elapsed})").unwrap());
+    bar.set_message(message);
+    bar.enable_steady_tick(Duration::from_millis(120));
+    bar
+}
+
+/// Uploads allowed at once by --max-concurrent-uploads (unset for no limit)
+static UPLOAD_SLOTS: OnceLock<Arc<Semaphore>> = OnceLock::new();
+
+/// Let only `limit` request bodies upload at once, however many requests are in flight; call once at startup
+pub fn limit_concurrent_uploads(limit: usize) {
+    let _ = UPLOAD_SLOTS.set(Arc::new(Semaphore::new(limit.max(1))));
+}
+
+/// Wait for an upload slot, if uploads are limited, logging how long it took
+async fn upload_slot(label: &str) -> Option<OwnedSemaphorePermit> {
+    let slots = UPLOAD_SLOTS.get()?.clone();
+    let started = Instant::now();
+    let permit = slots.acquire_owned().await.expect("upload slots are never closed");
+    
+    let waited = started.elapsed();
+    if waited >= Duration::from_millis(10) {
+        debug!("Waited {:.1}s for an upload slot for {}", waited.as_secs_f64(), label);
+    }
+    record_upload_wait(waited);
+    Some(permit)
+}
+
+/// Request body that uploads `data` and reports progress on stderr
+/// 
+/// The bar counts bytes as the HTTP client takes them, then turns into a
+/// spinner while the server works on the response. Call `finish_and_clear`
+/// on the returned bar once the response arrives. With
+/// --max-concurrent-uploads this first waits for an upload slot, which is
+/// given back as soon as the last byte is handed over, so requests waiting on
+/// the provider don't hold up other uploads.
+pub async fn upload_body(enabled: bool, data: Vec<u8>, label: &str) -> (reqwest::Body, ProgressBar) {
+    let slot = upload_slot(label).await;
+    upload_body_holding(slot, enabled, data, label)
+}
+
+/// Request body for `upload_body`, holding `slot` until the last byte is handed over
+fn upload_body_holding(mut slot: Option<OwnedSemaphorePermit>, enabled: bool, data: Vec<u8>, label: &str) -> (reqwest::Body, ProgressBar) {
+    let size = data.len() as u64;
+    let bar = if enabled {
+        let bar = progress_display().add(ProgressBar::new(size));
+        bar.set_style(
+            ProgressStyle::with_template("Uploading {msg} {bar:30} {bytes}/{total_bytes} ({bytes_per_sec})").unwrap(),
+        );
+        bar.set_message(label.to_string());
+        bar
     } else {
-        Command::new("which")
-            .arg(command)
-            .output()
+        // Still counts the bytes sent, for send_request
+        ProgressBar::with_draw_target(Some(size), ProgressDrawTarget::hidden())
     };
     
-    output.map(|o| o.status.success()).unwrap_or(false)
+    // An empty body has no last chunk to give the slot back on
+    if size == 0 {
+        drop(slot.take());
+    }
+    
+    let chunks: Vec<Vec<u8>> = data.chunks(UPLOAD_CHUNK_BYTES).map(<[u8]>::to_vec).collect();
+    let progress = bar.clone();
+    let label = label.to_string();
+    let body = futures::stream::iter(chunks).map(move |chunk| {
+        progress.inc(chunk.len() as u64);
+        
+        // Everything is sent; the rest of the wait is the transcription itself
+        if progress.position() >= size {
+            drop(slot.take());
+            progress.set_style(ProgressStyle::with_template("{spinner} {msg} ({elapsed})").unwrap());
+            progress.set_message(format!("Waiting for the transcription of {}", label));
+            progress.enable_steady_tick(Duration::from_millis(120));
+        }
+        
+        Ok::<_, std::io::Error>(chunk)
+    });
+    
+    (reqwest::Body::wrap_stream(body), bar)
 }
 
-/// Run a shell command
-pub fn run_command(command: &str, args: &[&str]) -> Result<String> {
-    debug!("Running command: {} {:?}", command, args);
+/// Fetch the body of a URL, retrying transient failures with exponential backoff
+/// 
+/// Both transport-level failures (DNS errors, refused or reset connections,
+/// timeouts) and the policy's HTTP statuses are retried. Errors in building
+/// the request itself are returned immediately.
+pub async fn fetch_bytes(url: &str, retry: &RetryPolicy) -> Result<Vec<u8>> {
+    let mut attempt = 0;
     
-    let output = Command::new(command)
-        .args(args)
-        .output()?;
+    loop {
+        match try_fetch_bytes(url).await {
+            Ok(bytes) => return Ok(bytes),
+            Err(e) if attempt < retry.retries && is_retryable_error(&e, &retry.status_codes) => {
+                attempt += 1;
+                let delay = Duration::from_secs(1 << attempt.min(5));
+                warn!("Request to {} failed ({}), retrying in {:?} (attempt {}/{})", url, e, delay, attempt, retry.retries);
+                record_http_retry();
+                tokio::time::sleep(delay).await;
+            }
+            Err(e) => return Err(e.into()),
+        }
+    }
+}
+
+/// Perform a single GET request and read the response body
+async fn try_fetch_bytes(url: &str) -> reqwest::Result<Vec<u8>> {
+    let started = Instant::now();
+    let response = http_client().get(url).send().await?;
+    record_http_request(started.elapsed(), 0);
+    let bytes = response.error_for_status()?.bytes().await?;
+    record_http_download(bytes.len() as u64);
+    Ok(bytes.to_vec())
+}
+
+/// Longest a single audio download may take, in seconds
+const AUDIO_DOWNLOAD_TIMEOUT_SECS: u64 = 30 * 60;
+
+/// Download an audio file, refusing non-audio content and files over `max_bytes`
+/// 
+/// The body is streamed to a `Spool`, which keeps it in memory if it fits
+/// `memory_limit` (--no-temp-disk) and otherwise writes it to `file_name` in
+/// a temporary directory under `temp_base`. The download is abandoned as soon
+/// as it grows past the limit. Redirects are followed. Transient failures are
+/// retried like other downloads.
+pub async fn download_audio(
+    url: &str,
+    temp_base: Option<&Path>,
+    file_name: &str,
+    max_bytes: u64,
+    memory_limit: Option<u64>,
+    retry: &RetryPolicy,
+) -> Result<Spooled> {
+    let mut attempt = 0;
     
-    if output.status.success() {
-        Ok(String::from_utf8(output.stdout)?)
-    } else {
-        Err(anyhow::anyhow!(
-            "Command failed with exit code {}: {}",
-            output.status.code().unwrap_or(-1),
-            String::from_utf8_lossy(&output.stderr)
-        ))
+    loop {
+        match try_download_audio(url, Spool::new(temp_base, file_name, memory_limit), max_bytes).await {
+            Ok(spooled) => return Ok(spooled),
+            Err(e) => match e.downcast_ref::<reqwest::Error>() {
+                Some(request_error) if attempt < retry.retries && is_retryable_error(request_error, &retry.status_codes) => {
+                    attempt += 1;
+                    let delay = Duration::from_secs(1 << attempt.min(5));
+                    warn!("Download of {} failed ({}), retrying in {:?} (attempt {}/{})", url, e, delay, attempt, retry.retries);
+                    record_http_retry();
+                    tokio::time::sleep(delay).await;
+                }
+                _ => return Err(e),
+            },
+        }
     }
 }
 
-/// Split a large audio file into smaller chunks
-pub fn split_audio_file(
-    input_file: &Path,
-    output_dir: &Path,
-    chunk_duration: u64,
-) -> Result<Vec<PathBuf>> {
-    debug!("Splitting audio file: {:?}", input_file);
+/// Perform a single audio download
+async fn try_download_audio(url: &str, mut spool: Spool, max_bytes: u64) -> Result<Spooled> {
+    let started = Instant::now();
+    let response = http_client()
+        .get(url)
+        .timeout(Duration::from_secs(AUDIO_DOWNLOAD_TIMEOUT_SECS))
+        .send()
+        .await?;
+    record_http_request(started.elapsed(), 0);
+    let mut response = response.error_for_status()?;
     
-    // Create output directory
-    fs::create_dir_all(output_dir)?;
+    // Servers often label audio as a generic binary, but an HTML page means a wrong link
+    let content_type = response.headers()
+        .get(reqwest::header::CONTENT_TYPE)
+        .and_then(|value| value.to_str().ok())
+        .unwrap_or("")
+        .to_lowercase();
+    let is_media = content_type.is_empty()
+        || content_type.starts_with("audio/")
+        || content_type.starts_with("video/")
+        || content_type.starts_with("application/octet-stream");
+    if !is_media {
+        return Err(anyhow::anyhow!("{} returned {:?} content, not audio", url, content_type));
+    }
     
-    // Get audio duration using ffprobe
-    let duration_output = run_command(
-        "ffprobe",
-        &[
-            "-v", "error",
-            "-show_entries", "format=duration",
-            "-of", "default=noprint_wrappers=1:nokey=1",
-            input_file.to_str().unwrap(),
-        ],
-    )?;
+    let too_large = || anyhow::anyhow!(
+        "{} is larger than the {} MB download limit (raise it with --max-download-size)",
+        url, max_bytes / (1024 * 1024)
+    );
+    if response.content_length().map_or(false, |length| length > max_bytes) {
+        return Err(too_large());
+    }
+    // Known to be too large to hold, so it goes to disk from the start
+    if let Some(length) = response.content_length() {
+        spool.expect(length)?;
+    }
+    
+    while let Some(chunk) = response.chunk().await? {
+        if spool.len() + chunk.len() as u64 > max_bytes {
+            return Err(too_large());
+        }
+        std::io::Write::write_all(&mut spool, &chunk)?;
+    }
     
-    let duration: f64 = duration_output.trim().parse()?;
-    let chunk_count = (duration / chunk_duration as f64).ceil() as usize;
+    let spooled = spool.finish()?;
+    record_http_download(spooled.len());
+    debug!("Downloaded {} bytes from {} ({})", spooled.len(), url, spooled);
+    Ok(spooled)
+}
+
+/// Data read from a download or pipe, held in memory up to a limit and written to a file past it
+/// 
+/// Without a limit (no --no-temp-disk) everything goes to the file, as the
+/// transcription steps that run ffmpeg need one. The file and its temporary
+/// directory are only created once the data outgrows memory.
+pub struct Spool {
+    temp_base: Option<PathBuf>,
+    file_name: String,
+    memory_limit: u64,
+    data: Vec<u8>,
+    file: Option<(TempDir, PathBuf, fs::File)>,
+    len: u64,
+}
+
+/// Where a `Spool`'s data ended up
+pub enum Spooled {
+    /// Held in memory, with nothing written to disk
+    Memory(Vec<u8>),
+    /// Written to `path` in a temporary directory, removed when this is dropped
+    File { _dir: TempDir, path: PathBuf, len: u64 },
+}
+
+impl Spool {
+    /// Spool data that spills to a file named `file_name` in a new temporary directory under `temp_base`
+    pub fn new(temp_base: Option<&Path>, file_name: &str, memory_limit: Option<u64>) -> Self {
+        Self {
+            temp_base: temp_base.map(Path::to_path_buf),
+            file_name: file_name.to_string(),
+            memory_limit: memory_limit.unwrap_or(0),
+            data: Vec::new(),
+            file: None,
+            len: 0,
+        }
+    }
     
-    debug!("Audio duration: {} seconds, splitting into {} chunks", duration, chunk_count);
+    /// Bytes written so far
+    pub fn len(&self) -> u64 {
+        self.len
+    }
     
-    let mut chunk_files = Vec::with_capacity(chunk_count);
+    /// Prepare for `total` bytes, moving to the file now if they won't fit in memory
+    pub fn expect(&mut self, total: u64) -> Result<()> {
+        if self.file.is_none() && total > self.memory_limit {
+            self.spill()?;
+        }
+        Ok(())
+    }
     
-    for i in 0..chunk_count {
-        let start_time = i as f64 * chunk_duration as f64;
-        let chunk_file = output_dir.join(format!("chunk_{}.mp3", i + 1));
-        
-        // Convert values to strings before using them in args
-        let start_time_str = start_time.to_string();
-        let chunk_duration_str = chunk_duration.to_string();
-        let input_file_str = input_file.to_str().unwrap();
-        let chunk_file_str = chunk_file.to_str().unwrap();
-        
-        let mut args = vec![
-            "-nostdin", "-v", "quiet", "-y",
-            "-i", input_file_str,
-            "-ss", &start_time_str,
-        ];
-        
-        // For all chunks except the last one, set a specific duration
-        if i < chunk_count - 1 {
-            args.extend_from_slice(&["-t", &chunk_duration_str]);
+    /// Write what's held so far to the file and continue there
+    fn spill(&mut self) -> Result<()> {
+        if !self.data.is_empty() {
+            debug!("Spooling to disk after {} bytes in memory", self.data.len());
+        }
+        let dir = create_temp_dir(self.temp_base.as_deref())?;
+        let path = dir.path().join(&self.file_name);
+        let mut file = fs::File::create(&path)?;
+        std::io::Write::write_all(&mut file, &std::mem::take(&mut self.data))?;
+        self.file = Some((dir, path, file));
+        Ok(())
+    }
+    
+    pub fn finish(mut self) -> Result<Spooled> {
+        // Without a limit even empty data gets its file, for the errors that follow to name
+        if self.memory_limit == 0 && self.file.is_none() {
+            self.spill()?;
+        }
+        Ok(match self.file {
+            Some((dir, path, _)) => Spooled::File { _dir: dir, path, len: self.len },
+            None => Spooled::Memory(self.data),
+        })
+    }
+}
+
+impl std::io::Write for Spool {
+    fn write(&mut self, bytes: &[u8]) -> std::io::Result<usize> {
+        if self.file.is_none() && self.len + bytes.len() as u64 > self.memory_limit {
+            self.spill().map_err(|e| std::io::Error::other(format!("{:#}", e)))?;
+        }
+        match &mut self.file {
+            Some((_, _, file)) => std::io::Write::write_all(file, bytes)?,
+            None => self.data.extend_from_slice(bytes),
+        }
+        self.len += bytes.len() as u64;
+        Ok(bytes.len())
+    }
+    
+    fn flush(&mut self) -> std::io::Result<()> {
+        match &mut self.file {
+            Some((_, _, file)) => std::io::Write::flush(file),
+            None => Ok(()),
+        }
+    }
+}
+
+impl Spooled {
+    /// Number of bytes
+    pub fn len(&self) -> u64 {
+        match self {
+            Spooled::Memory(data) => data.len() as u64,
+            Spooled::File { len, .. } => *len,
+        }
+    }
+    
+    /// The file holding the data, or `name` (which doesn't exist) for data held in memory
+    pub fn path_or(&self, name: &str) -> PathBuf {
+        match self {
+            Spooled::Memory(_) => PathBuf::from(name),
+            Spooled::File { path, .. } => path.clone(),
         }
-        
-        args.extend_from_slice(&[
-            "-acodec", "libmp3lame",
-            "-b:a", "128k",
-            chunk_file_str,
-        ]);
-        
-        run_command("ffmpeg", &args)?;
-        chunk_files.push(chunk_file);
     }
     
-    Ok(chunk_files)
+    /// Take the data if it was kept in memory; a file stays where it is
+    pub fn take_memory(&mut self) -> Option<Vec<u8>> {
+        match self {
+            Spooled::Memory(data) => Some(std::mem::take(data)),
+            Spooled::File { .. } => None,
+        }
+    }
+}
+
+impl std::fmt::Display for Spooled {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Spooled::Memory(_) => write!(f, "held in memory"),
+            Spooled::File { path, .. } => write!(f, "written to {:?}", path),
+        }
+    }
+}
+
+/// Check whether a failed request is worth retrying
+pub fn is_retryable_error(error: &reqwest::Error, status_codes: &[u16]) -> bool {
+    // Malformed URLs and redirect loops won't fix themselves
+    if error.is_builder() || error.is_redirect() {
+        return false;
+    }
+    
+    // Only the configured statuses (rate limiting, gateway errors) are transient
+    if let Some(status) = error.status() {
+        return status_codes.contains(&status.as_u16());
+    }
+    
+    // DNS failures, connection resets and timeouts
+    error.is_connect() || error.is_timeout() || error.is_request() || error.is_body()
+}
+
+/// Make sure a directory exists and is writable, creating it if needed
+/// 
+/// Used to validate output and working directories up front, so a bad path
+/// fails the run immediately rather than after an expensive transcription.
+pub fn ensure_writable_dir(dir: &Path, description: &str) -> Result<()> {
+    if dir.exists() && !dir.is_dir() {
+        return Err(anyhow::anyhow!("{} {:?} exists but is not a directory", description, dir));
+    }
+    
+    fs::create_dir_all(dir)
+        .map_err(|e| anyhow::anyhow!("{} {:?} does not exist and could not be created: {}", description, dir, e))?;
+    
+    // Probe with a throwaway file, since permissions alone don't tell the whole story
+    tempfile::NamedTempFile::new_in(dir)
+        .map_err(|e| anyhow::anyhow!("{} {:?} is not writable: {}", description, dir, e))?;
+    
+    Ok(())
+}
+
+/// Whether temporary directories are left behind for inspection (--keep-temp)
+static KEEP_TEMP: AtomicBool = AtomicBool::new(false);
+
+/// Keep the directories made by `create_temp_dir` instead of removing them; call once at startup
+pub fn keep_temp_dirs(keep: bool) {
+    KEEP_TEMP.store(keep, Ordering::Relaxed);
+}
+
+/// A directory made by `create_temp_dir`, removed when dropped unless --keep-temp is set
+pub struct TempDir {
+    /// None only while being dropped
+    inner: Option<tempfile::TempDir>,
+    keep: bool,
+}
+
+impl TempDir {
+    /// Path of the directory
+    pub fn path(&self) -> &Path {
+        self.inner.as_ref().expect("temp dir is only taken on drop").path()
+    }
+}
+
+impl Drop for TempDir {
+    fn drop(&mut self) {
+        // Forgetting tempfile's handle skips its cleanup, leaving the directory in place
+        if self.keep {
+            std::mem::forget(self.inner.take());
+        }
+    }
+}
+
+/// Create a temporary directory for intermediate files
+/// 
+/// Uses the configured --temp-dir when set, since the OS temp dir is often a
+/// small tmpfs in containers; the directory is removed when dropped, unless
+/// --keep-temp asked for it to be kept, in which case its path is printed.
+pub fn create_temp_dir(base: Option<&Path>) -> Result<TempDir> {
+    let keep = KEEP_TEMP.load(Ordering::Relaxed);
+    let mut builder = tempfile::Builder::new();
+    builder.prefix(TEMP_DIR_PREFIX);
+    
+    let temp_dir = match base {
+        Some(base) => builder.tempdir_in(base)
+            .map_err(|e| anyhow::anyhow!("Failed to create a temporary directory in {:?}: {}", base, e))?,
+        None => builder.tempdir()?,
+    };
+    
+    if keep {
+        eprintln!("Keeping temporary files in {}", temp_dir.path().display());
+    }
+    Ok(TempDir { inner: Some(temp_dir), keep })
+}
+
+/// Name prefix of the directories made by `create_temp_dir`
+const TEMP_DIR_PREFIX: &str = "media-transcriber-";
+
+/// Whether a file lives in one of our temporary directories (a download or capture, not a user's file)
+pub fn is_temp_file(path: &Path) -> bool {
+    path.ancestors()
+        .filter_map(|dir| dir.file_name().and_then(|name| name.to_str()))
+        .any(|name| name.starts_with(TEMP_DIR_PREFIX))
+}
+
+/// Quote a string for safe use as a single POSIX shell word
+pub fn shell_quote(value: &str) -> String {
+    format!("'{}'", value.replace('\'', "'\\''"))
+}
+
+/// Check if a command is available
+pub fn check_command(command: &str) -> bool {
+    let output = if cfg!(target_os = "windows") {
+        Command::new("where")
+            .arg(command)
+            .output()
+    } else {
+        Command::new("which")
+            .arg(command)
+            .output()
+    };
+    
+    output.map(|o| o.status.success()).unwrap_or(false)
+}
+
+/// Run a shell command
+pub fn run_command(command: &str, args: &[&str]) -> Result<String> {
+    debug!("Running command: {} {:?}", command, args);
+    
+    let output = Command::new(command)
+        .args(args)
+        .output()?;
+    
+    command_output(output)
+}
+
+/// Run ffmpeg or ffprobe on a media file without blocking the runtime
+/// 
+/// Long decodes can then be cancelled by Ctrl-C or --timeout like any other
+/// step, and the process is killed when they are rather than left running.
+pub async fn run_media_command(command: &str, args: &[&str]) -> Result<String> {
+    debug!("Running command: {} {:?}", command, args);
+    
+    let output = tokio::process::Command::new(command)
+        .args(args)
+        .kill_on_drop(true)
+        .output()
+        .await?;
+    
+    command_output(output)
+}
+
+/// Standard output of a finished command, or its exit code and standard error if it failed
+fn command_output(output: std::process::Output) -> Result<String> {
+    if output.status.success() {
+        Ok(String::from_utf8(output.stdout)?)
+    } else {
+        Err(anyhow::anyhow!(
+            "Command failed with exit code {}: {}",
+            output.status.code().unwrap_or(-1),
+            String::from_utf8_lossy(&output.stderr)
+        ))
+    }
+}
+
+/// Stored result of an integrity check, in the cache directory
+#[derive(Serialize, Deserialize)]
+struct IntegrityCheck {
+    /// First decode error ffmpeg reported, or None if the file decoded cleanly
+    error: Option<String>,
+}
+
+/// Where the integrity check of a file with these contents is kept
+fn integrity_cache_file(file_hash: &str) -> PathBuf {
+    cache_dir().join("integrity").join(format!("{}.json", file_hash))
+}
+
+/// Verify that an audio file decodes cleanly from start to end
+/// 
+/// Runs a full decode with ffmpeg (`ffmpeg -v error -i file -f null -`) and
+/// fails with the first decode error reported, which catches truncated or
+/// corrupt downloads before they are sent for transcription. Decoding a long
+/// file is expensive, so the result is kept in the cache directory under the
+/// file's SHA-256 and reused for any file with the same contents.
+pub async fn verify_audio_integrity(input_file: &Path) -> Result<()> {
+    let cache_file = integrity_cache_file(&hash_file(input_file)?);
+    
+    let cached = fs::read_to_string(&cache_file)
+        .ok()
+        .and_then(|json| serde_json::from_str::<IntegrityCheck>(&json).ok());
+    let check = match cached {
+        Some(check) => {
+            debug!("Using cached integrity check result for {:?}", input_file);
+            check
+        }
+        None => {
+            let check = decode_audio(input_file).await?;
+            let stored = fs::create_dir_all(cache_dir().join("integrity"))
+                .map_err(anyhow::Error::from)
+                .and_then(|_| Ok(serde_json::to_string(&check)?))
+                .and_then(|json| write_atomic(&cache_file, json));
+            if let Err(e) = stored {
+                debug!("Failed to cache integrity check result in {:?}: {}", cache_file, e);
+            }
+            check
+        }
+    };
+    
+    match check.error {
+        Some(error) => Err(anyhow::anyhow!("Audio file {:?} failed integrity check: {}", input_file, error)),
+        None => Ok(()),
+    }
+}
+
+/// Decode a file with ffmpeg, returning the first decode error (a failure to run ffmpeg is an `Err`)
+async fn decode_audio(input_file: &Path) -> Result<IntegrityCheck> {
+    debug!("Verifying audio integrity: {:?}", input_file);
+    
+    let output = tokio::process::Command::new("ffmpeg")
+        .args(&[
+            "-nostdin", "-v", "error",
+            "-i", input_file.to_str().unwrap(),
+            "-f", "null", "-",
+        ])
+        .kill_on_drop(true)
+        .output()
+        .await?;
+    
+    // ffmpeg may exit successfully while still reporting decode errors
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    let first_error = stderr.lines().map(str::trim).find(|line| !line.is_empty());
+    
+    let error = match first_error {
+        Some(error) => Some(error.to_string()),
+        None if !output.status.success() => Some(format!("ffmpeg exited with code {}", output.status.code().unwrap_or(-1))),
+        None => None,
+    };
+    
+    Ok(IntegrityCheck { error })
+}
+
+/// An audio stream within a media file
+#[derive(Debug)]
+pub struct AudioStream {
+    /// Position among the file's audio streams (0-based, as used by `-map 0:a:N`)
+    pub position: usize,
+    /// Stream title tag, if any (often the participant name)
+    pub title: Option<String>,
+}
+
+/// ffprobe's JSON stream listing
+#[derive(Deserialize)]
+struct ProbeStreams {
+    #[serde(default)]
+    streams: Vec<ProbeStream>,
+}
+
+/// A single stream in ffprobe's JSON output
+#[derive(Deserialize)]
+struct ProbeStream {
+    #[serde(default)]
+    tags: HashMap<String, String>,
+}
+
+/// List the audio streams of a media file using ffprobe
+pub async fn probe_audio_streams(input_file: &Path) -> Result<Vec<AudioStream>> {
+    let output = run_media_command(
+        "ffprobe",
+        &[
+            "-v", "error",
+            "-select_streams", "a",
+            "-show_entries", "stream=index:stream_tags=title",
+            "-of", "json",
+            input_file.to_str().unwrap(),
+        ],
+    ).await?;
+    
+    let probe: ProbeStreams = serde_json::from_str(&output)?;
+    let streams: Vec<AudioStream> = probe.streams
+        .into_iter()
+        .enumerate()
+        .map(|(position, stream)| AudioStream {
+            position,
+            title: stream.tags.get("title").cloned(),
+        })
+        .collect();
+    
+    debug!("Found {} audio streams in {:?}", streams.len(), input_file);
+    Ok(streams)
+}
+
+/// Container brands used by Audible's encrypted audiobooks
+const DRM_BRANDS: &[&str] = &["aax", "aaxc"];
+
+/// Sample entry codes of encrypted tracks (FairPlay, Common Encryption)
+const DRM_CODEC_TAGS: &[&str] = &["drms", "drmi", "enca", "encv"];
+
+/// ffprobe's JSON format and stream listing, as used for DRM detection
+#[derive(Deserialize)]
+struct ProbeMedia {
+    #[serde(default)]
+    format: Option<ProbeFormat>,
+    #[serde(default)]
+    streams: Vec<ProbeCodecTag>,
+}
+
+/// The container section of ffprobe's JSON output
+#[derive(Deserialize)]
+struct ProbeFormat {
+    #[serde(default)]
+    tags: HashMap<String, String>,
+}
+
+/// A stream's codec tag in ffprobe's JSON output
+#[derive(Deserialize)]
+struct ProbeCodecTag {
+    #[serde(default)]
+    codec_tag_string: String,
+}
+
+/// Fail with a clear error if a media file is DRM-protected
+/// 
+/// Looks for Audible AAX/AAXC brands, encrypted track types (FairPlay's
+/// `drms`, CENC's `enca`/`encv`) and ffprobe's complaints about missing
+/// decryption keys. Files that can't be probed at all are let through, so
+/// the usual decode errors still apply to them.
+pub async fn check_drm(input_file: &Path) -> Result<()> {
+    let output = match tokio::process::Command::new("ffprobe")
+        .args(["-v", "warning", "-show_entries", "format_tags=major_brand:stream=codec_tag_string", "-of", "json"])
+        .arg(input_file)
+        .kill_on_drop(true)
+        .output()
+        .await
+    {
+        Ok(output) => output,
+        Err(e) => {
+            debug!("Skipping DRM check, ffprobe could not be run: {}", e);
+            return Ok(());
+        }
+    };
+    
+    let stderr = String::from_utf8_lossy(&output.stderr).to_lowercase();
+    let probe: Option<ProbeMedia> = serde_json::from_slice(&output.stdout).ok();
+    
+    let brand = probe.as_ref()
+        .and_then(|probe| probe.format.as_ref())
+        .and_then(|format| format.tags.get("major_brand"))
+        .map(|brand| brand.trim().to_lowercase());
+    let encrypted_stream = probe.as_ref().map_or(false, |probe| {
+        probe.streams.iter().any(|stream| DRM_CODEC_TAGS.contains(&stream.codec_tag_string.as_str()))
+    });
+    
+    let reason = if brand.as_deref().map_or(false, |brand| DRM_BRANDS.contains(&brand)) {
+        Some("it is an Audible AAX/AAXC audiobook")
+    } else if encrypted_stream {
+        Some("its audio track is encrypted")
+    } else if stderr.contains("activation_bytes") || stderr.contains("audible_key") || stderr.contains("decryption key") {
+        Some("it needs a decryption key to decode")
+    } else {
+        None
+    };
+    
+    match reason {
+        Some(reason) => Err(anyhow::anyhow!(
+            "{:?} is DRM-protected ({}) and cannot be transcribed; convert it with an authorized tool first",
+            input_file, reason
+        )),
+        None => Ok(()),
+    }
+}
+
+/// Extract a single audio stream of a media file as MP3
+pub async fn extract_audio_stream(input_file: &Path, position: usize, output_file: &Path) -> Result<()> {
+    let map = format!("0:a:{}", position);
+    
+    run_media_command(
+        "ffmpeg",
+        &[
+            "-nostdin", "-v", "quiet", "-y",
+            "-i", input_file.to_str().unwrap(),
+            "-map", &map,
+            "-acodec", "libmp3lame",
+            "-b:a", "128k",
+            output_file.to_str().unwrap(),
+        ],
+    ).await?;
+    
+    Ok(())
+}
+
+/// Convert audio to 16 kHz mono in a compact format, which is all Whisper needs for speech
+pub async fn transcode_audio(input_file: &Path, output_file: &Path, format: TranscodeFormat) -> Result<()> {
+    let mut args = vec![
+        "-nostdin", "-v", "error", "-y",
+        "-i", input_file.to_str().unwrap(),
+        "-vn", "-ar", "16000", "-ac", "1",
+    ];
+    args.extend_from_slice(format.codec_args());
+    args.push(output_file.to_str().unwrap());
+    
+    run_media_command("ffmpeg", &args)
+        .await
+        .map_err(|e| anyhow::anyhow!("Failed to transcode {:?}: {}", input_file, e))?;
+    
+    Ok(())
+}
+
+/// Sample rate audio is decoded at for waveform peaks, plenty to follow the loudness of speech
+const PEAKS_SAMPLE_RATE: u32 = 8000;
+
+/// Downsampled waveform of a recording, for players that draw it next to the transcript
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Peaks {
+    /// Values per second of audio
+    pub per_second: u32,
+    /// Time of the first value, in seconds
+    pub start: f64,
+    /// Loudest sample in each window, from 0 to 1
+    pub values: Vec<f32>,
+}
+
+impl Peaks {
+    /// The values covering `start..end` seconds, e.g. for one part of a split transcript
+    pub fn slice(&self, start: f64, end: f64) -> Peaks {
+        let index = |seconds: f64| (((seconds - self.start) * self.per_second as f64).floor().max(0.0) as usize).min(self.values.len());
+        let (first, last) = (index(start), index(end).max(index(start)));
+        Peaks {
+            per_second: self.per_second,
+            start: self.start + first as f64 / self.per_second as f64,
+            values: self.values[first..last].to_vec(),
+        }
+    }
+}
+
+/// Decode a file with ffmpeg and take the peak of every 1/`per_second` of a second
+/// 
+/// The audio is mixed down to mono and streamed from ffmpeg rather than held
+/// in memory, so long recordings are fine. Values are rounded to two decimals
+/// to keep the JSON small.
+pub async fn waveform_peaks(input_file: &Path, per_second: u32) -> Result<Peaks> {
+    let sample_rate = PEAKS_SAMPLE_RATE.to_string();
+    let args = [
+        "-nostdin", "-v", "error",
+        "-i", input_file.to_str().unwrap(),
+        "-vn", "-ac", "1", "-ar", &sample_rate,
+        "-f", "s16le", "-",
+    ];
+    debug!("Running command: ffmpeg {:?}", args);
+    
+    let mut child = tokio::process::Command::new("ffmpeg")
+        .args(args)
+        .stdout(std::process::Stdio::piped())
+        .stderr(std::process::Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()?;
+    let mut stdout = child.stdout.take().expect("stdout is piped");
+    let mut stderr = child.stderr.take().expect("stderr is piped");
+    
+    let mut peaks = PcmPeaks::new((PEAKS_SAMPLE_RATE / per_second.max(1)).max(1) as usize);
+    let mut errors = String::new();
+    // Drained together, so a chatty ffmpeg can't fill the stderr pipe and stall
+    let (read, _) = tokio::join!(
+        async {
+            let mut buffer = vec![0u8; 64 * 1024];
+            loop {
+                match stdout.read(&mut buffer).await? {
+                    0 => return Ok::<_, std::io::Error>(()),
+                    read => peaks.push(&buffer[..read]),
+                }
+            }
+        },
+        stderr.read_to_string(&mut errors),
+    );
+    read?;
+    
+    let status = child.wait().await?;
+    if !status.success() {
+        return Err(anyhow::anyhow!(
+            "Failed to read the waveform of {:?}: ffmpeg exited with code {}: {}",
+            input_file, status.code().unwrap_or(-1), errors.trim()
+        ));
+    }
+    
+    Ok(Peaks { per_second, start: 0.0, values: peaks.finish() })
+}
+
+/// Peaks of 16-bit little-endian mono PCM as it arrives, one per `window` samples, from 0 to 1 to two decimals
+struct PcmPeaks {
+    window: usize,
+    peak: u16,
+    samples: usize,
+    /// First byte of a sample split across two reads
+    pending: Option<u8>,
+    values: Vec<f32>,
+}
+
+impl PcmPeaks {
+    fn new(window: usize) -> Self {
+        Self { window, peak: 0, samples: 0, pending: None, values: Vec::new() }
+    }
+    
+    fn push(&mut self, mut pcm: &[u8]) {
+        if let Some(low) = self.pending.take() {
+            match pcm.split_first() {
+                Some((&high, rest)) => {
+                    self.sample(i16::from_le_bytes([low, high]));
+                    pcm = rest;
+                }
+                None => self.pending = Some(low),
+            }
+        }
+        
+        let mut samples = pcm.chunks_exact(2);
+        for sample in &mut samples {
+            self.sample(i16::from_le_bytes([sample[0], sample[1]]));
+        }
+        self.pending = self.pending.or(samples.remainder().first().copied());
+    }
+    
+    fn sample(&mut self, sample: i16) {
+        self.peak = self.peak.max(sample.unsigned_abs());
+        self.samples += 1;
+        if self.samples == self.window {
+            self.values.push(Self::value(self.peak));
+            (self.peak, self.samples) = (0, 0);
+        }
+    }
+    
+    fn value(peak: u16) -> f32 {
+        (peak as f32 / 32768.0 * 100.0).round() / 100.0
+    }
+    
+    fn finish(mut self) -> Vec<f32> {
+        // A final partial window still covers audio
+        if self.samples > 0 {
+            self.values.push(Self::value(self.peak));
+        }
+        self.values
+    }
+}
+
+/// A single chunk of a larger audio file
+pub struct ChunkSpec {
+    /// Zero-based chunk index
+    pub index: usize,
+    /// Offset of the chunk from the start of the file, in seconds
+    pub start: f64,
+    /// Chunk length in seconds (None for the final chunk, which runs to the end)
+    pub duration: Option<f64>,
+}
+
+/// Get the duration of an audio file in seconds using ffprobe
+pub async fn get_audio_duration(input_file: &Path) -> Result<f64> {
+    let duration_output = run_media_command(
+        "ffprobe",
+        &[
+            "-v", "error",
+            "-show_entries", "format=duration",
+            "-of", "default=noprint_wrappers=1:nokey=1",
+            input_file.to_str().unwrap(),
+        ],
+    ).await?;
+    
+    Ok(duration_output.trim().parse()?)
+}
+
+/// Bytes per second of the 128 kbps MP3 chunks produced by `extract_chunk`
+pub const CHUNK_BYTES_PER_SECOND: f64 = 128_000.0 / 8.0;
+
+/// Longest chunk that stays under OpenAI's 25MB upload limit at the chunk bitrate
+const MAX_CHUNK_SECONDS: f64 = (25 * 1024 * 1024) as f64 / CHUNK_BYTES_PER_SECOND;
+
+/// Plan how to split audio of the given duration into fixed-length chunks
+/// 
+/// Every chunk after the first starts `overlap` seconds early, so words cut
+/// at a boundary are heard whole by one of the two chunks. A final chunk shorter than `min_chunk_duration` is merged into the previous
+/// one when the result still fits the upload limit, since a few seconds of
+/// audio on its own tends to transcribe as noise. The plan depends only on its
+/// inputs, so the same file always produces the same chunk boundaries.
+pub fn plan_chunks(duration: f64, chunk_duration: u64, min_chunk_duration: u64, overlap: u64) -> Vec<ChunkSpec> {
+    let mut chunk_count = (duration / chunk_duration as f64).ceil() as usize;
+    
+    // Fold a tiny trailing remainder into the previous chunk
+    if chunk_count > 1 {
+        let last_duration = duration - (chunk_count - 1) as f64 * chunk_duration as f64;
+        let merged_duration = chunk_duration as f64 + last_duration + overlap as f64;
+        
+        if last_duration < min_chunk_duration as f64 && merged_duration <= MAX_CHUNK_SECONDS {
+            debug!("Merging {:.1}s final chunk into the previous chunk", last_duration);
+            chunk_count -= 1;
+        }
+    }
+    
+    (0..chunk_count)
+        .map(|i| {
+            let lead_in = if i > 0 { overlap.min(chunk_duration) as f64 } else { 0.0 };
+            ChunkSpec {
+                index: i,
+                start: i as f64 * chunk_duration as f64 - lead_in,
+                duration: if i < chunk_count - 1 { Some(chunk_duration as f64 + lead_in) } else { None },
+            }
+        })
+        .collect()
+}
+
+/// Extract a single chunk of an audio file as MP3
+/// 
+/// ffmpeg runs without blocking the runtime, so chunks transcribed side by
+/// side with --chunk-concurrency are extracted side by side too.
+pub async fn extract_chunk(input_file: &Path, chunk: &ChunkSpec, chunk_file: &Path) -> Result<()> {
+    debug!("Extracting chunk {} from {:?}", chunk.index + 1, input_file);
+    
+    // Convert values to strings before using them in args
+    let start_time_str = chunk.start.to_string();
+    let chunk_duration_str = chunk.duration.map(|d| d.to_string());
+    let input_file_str = input_file.to_str().unwrap();
+    let chunk_file_str = chunk_file.to_str().unwrap();
+    
+    let mut args = vec![
+        "-nostdin", "-v", "quiet", "-y",
+        "-i", input_file_str,
+        "-ss", &start_time_str,
+    ];
+    
+    // For all chunks except the last one, set a specific duration
+    if let Some(duration) = &chunk_duration_str {
+        args.extend_from_slice(&["-t", duration]);
+    }
+    
+    args.extend_from_slice(&[
+        "-acodec", "libmp3lame",
+        "-b:a", "128k",
+        chunk_file_str,
+    ]);
+    
+    run_media_command("ffmpeg", &args).await?;
+    Ok(())
+}
+
+/// Write a file by writing a temporary file next to it and renaming that into place
+/// 
+/// The rename is atomic on POSIX filesystems, so a crash mid-write leaves
+/// the old file (or none) rather than a truncated one. The temporary file
+/// is created like any other, so permissions are the same as a direct write.
+pub fn write_atomic(path: impl AsRef<Path>, contents: impl AsRef<[u8]>) -> Result<()> {
+    let path = path.as_ref();
+    let temp_path = partial_path(path);
+    let result = fs::write(&temp_path, contents).and_then(|_| fs::rename(&temp_path, path));
+    
+    if let Err(e) = result {
+        let _ = fs::remove_file(&temp_path);
+        return Err(anyhow::anyhow!("Failed to write {:?}: {}", path, e));
+    }
+    
+    Ok(())
+}
+
+/// Hidden temporary name next to a file, for writing it before renaming it into place
+pub fn partial_path(path: &Path) -> PathBuf {
+    let name = path.file_name().map(|name| name.to_string_lossy()).unwrap_or_default();
+    path.with_file_name(format!(".{}.partial-{}", name, std::process::id()))
+}
+
+/// Compute the SHA-256 hash of a file's contents as a hex string
+pub fn hash_file(path: &Path) -> Result<String> {
+    let mut file = fs::File::open(path)?;
+    let mut hasher = Sha256::new();
+    std::io::copy(&mut file, &mut hasher)?;
+    Ok(hex::encode(hasher.finalize()))
+}
+
+/// $SOURCE_DATE_EPOCH, the fixed time reproducible builds stamp outputs with, if set and valid
+pub fn source_date_epoch() -> Option<i64> {
+    std::env::var("SOURCE_DATE_EPOCH").ok()
+        .and_then(|epoch| epoch.trim().parse::<i64>().ok())
+}
+
+/// Time to stamp transcript outputs with: `epoch` when set, so reruns are byte-identical, or now
+pub fn output_time(epoch: Option<i64>) -> chrono::DateTime<chrono::FixedOffset> {
+    epoch
+        .and_then(|epoch| chrono::DateTime::from_timestamp(epoch, 0))
+        .map(|time| time.fixed_offset())
+        .unwrap_or_else(|| chrono::Local::now().fixed_offset())
+}
+
+/// Directory for persistent cache data ($XDG_CACHE_HOME/media-transcriber or ~/.cache/media-transcriber)
+pub fn cache_dir() -> PathBuf {
+    let base = std::env::var_os("XDG_CACHE_HOME")
+        .map(PathBuf::from)
+        .or_else(|| std::env::var_os("HOME").map(|home| PathBuf::from(home).join(".cache")))
+        .unwrap_or_else(std::env::temp_dir);
+    
+    base.join("media-transcriber")
+}
+
+/// Total size in bytes of the files under a directory (unreadable entries count as zero)
+pub fn dir_size(dir: &Path) -> u64 {
+    let Ok(entries) = fs::read_dir(dir) else {
+        return 0;
+    };
+    
+    entries
+        .filter_map(|entry| entry.ok())
+        .map(|entry| match entry.metadata() {
+            Ok(metadata) if metadata.is_dir() => dir_size(&entry.path()),
+            Ok(metadata) => metadata.len(),
+            Err(_) => 0,
+        })
+        .sum()
+}
+
+/// Whisper's languages as (name, ISO-639-1 code), the names being what verbose_json reports
+const WHISPER_LANGUAGES: &[(&str, &str)] = &[
+    ("afrikaans", "af"), ("arabic", "ar"), ("armenian", "hy"), ("azerbaijani", "az"), ("belarusian", "be"),
+    ("bosnian", "bs"), ("bulgarian", "bg"), ("catalan", "ca"), ("chinese", "zh"), ("croatian", "hr"),
+    ("czech", "cs"), ("danish", "da"), ("dutch", "nl"), ("english", "en"), ("estonian", "et"),
+    ("finnish", "fi"), ("french", "fr"), ("galician", "gl"), ("german", "de"), ("greek", "el"),
+    ("hebrew", "he"), ("hindi", "hi"), ("hungarian", "hu"), ("icelandic", "is"), ("indonesian", "id"),
+    ("italian", "it"), ("japanese", "ja"), ("kannada", "kn"), ("kazakh", "kk"), ("korean", "ko"),
+    ("latvian", "lv"), ("lithuanian", "lt"), ("macedonian", "mk"), ("malay", "ms"), ("marathi", "mr"),
+    ("maori", "mi"), ("nepali", "ne"), ("norwegian", "no"), ("persian", "fa"), ("polish", "pl"),
+    ("portuguese", "pt"), ("romanian", "ro"), ("russian", "ru"), ("serbian", "sr"), ("slovak", "sk"),
+    ("slovenian", "sl"), ("spanish", "es"), ("swahili", "sw"), ("swedish", "sv"), ("tagalog", "tl"),
+    ("tamil", "ta"), ("thai", "th"), ("turkish", "tr"), ("ukrainian", "uk"), ("urdu", "ur"),
+    ("vietnamese", "vi"), ("welsh", "cy"), ("cantonese", "yue"),
+];
+
+/// ISO-639-1 code for a detected language, which may already be a code (as some providers report)
+pub fn language_code(language: &str) -> Option<&'static str> {
+    let language = language.trim().to_lowercase();
+    WHISPER_LANGUAGES.iter()
+        .find(|(name, code)| *name == language || *code == language)
+        .map(|(_, code)| *code)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use stub_server::Reply;
+    
+    fn timeouts(response_ms: u64, read_ms: u64) -> HttpTimeouts {
+        HttpTimeouts { response: Duration::from_millis(response_ms), read: Duration::from_millis(read_ms) }
+    }
+    
+    #[tokio::test]
+    async fn retries_a_reset_connection() {
+        let (url, server) = stub_server::serve_after_resets(1, Reply::ok("text/plain", "second try")).await;
+        let retry = RetryPolicy::new(1, DEFAULT_RETRY_STATUS_CODES.to_vec()).unwrap();
+        
+        assert_eq!(fetch_bytes(&format!("{}/feed.xml", url), &retry).await.unwrap(), b"second try");
+        assert_eq!(server.await.unwrap().request_line(), "GET /feed.xml HTTP/1.1");
+    }
+    
+    #[tokio::test]
+    async fn gives_up_on_a_reset_connection_without_retries() {
+        let (url, _server) = stub_server::serve_after_resets(1, Reply::ok("text/plain", "unused")).await;
+        let retry = RetryPolicy::new(0, DEFAULT_RETRY_STATUS_CODES.to_vec()).unwrap();
+        
+        let error = fetch_bytes(&url, &retry).await.unwrap_err();
+        let error = error.downcast_ref::<reqwest::Error>().unwrap();
+        assert!(is_retryable_error(error, &retry.status_codes));
+    }
+    
+    #[tokio::test]
+    async fn does_not_retry_a_malformed_url() {
+        let retry = RetryPolicy::new(3, DEFAULT_RETRY_STATUS_CODES.to_vec()).unwrap();
+        
+        let started = std::time::Instant::now();
+        let error = fetch_bytes("http://[not a host]/feed.xml", &retry).await.unwrap_err();
+        
+        assert!(!is_retryable_error(error.downcast_ref::<reqwest::Error>().unwrap(), &retry.status_codes));
+        assert!(started.elapsed() < Duration::from_secs(1), "a build error was retried");
+    }
+    
+    #[tokio::test]
+    async fn response_timeout_fires_while_headers_are_delayed() {
+        let reply = Reply { header_delay: Duration::from_millis(500), ..Reply::ok("text/plain", "late") };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        
+        let error = send_request(reqwest::Client::new().get(&url), None, &timeouts(100, 10_000)).await.unwrap_err();
+        assert!(matches!(error.downcast_ref::<HttpTimeoutError>(), Some(HttpTimeoutError::Response { .. })));
+    }
+    
+    #[tokio::test]
+    async fn connect_timeout_leaves_stalled_headers_to_the_response_timeout() {
+        let client = build_http_client(Duration::from_millis(100)).unwrap();
+        
+        let reply = Reply { header_delay: Duration::from_millis(500), ..Reply::ok("text/plain", "late") };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        let response = send_request(client.get(&url), None, &timeouts(2_000, 2_000)).await.unwrap();
+        assert_eq!(read_body(response, &timeouts(2_000, 2_000)).await.unwrap(), b"late");
+        
+        let reply = Reply { header_delay: Duration::from_millis(500), ..Reply::ok("text/plain", "late") };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        let error = send_request(client.get(&url), None, &timeouts(200, 2_000)).await.unwrap_err();
+        assert!(matches!(error.downcast_ref::<HttpTimeoutError>(), Some(HttpTimeoutError::Response { .. })), "{:#}", error);
+    }
+    
+    #[tokio::test]
+    async fn stalled_tls_handshake_counts_against_the_connect_timeout() {
+        // Accepts the connection but never answers the ClientHello
+        let listener = tokio::net::TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let address = listener.local_addr().unwrap();
+        let _server = tokio::spawn(async move {
+            let (socket, _) = listener.accept().await.unwrap();
+            tokio::time::sleep(Duration::from_secs(10)).await;
+            drop(socket);
+        });
+        
+        let client = build_http_client(Duration::from_millis(200)).unwrap();
+        let started = Instant::now();
+        let error = send_request(client.get(format!("https://{}/", address)), None, &timeouts(10_000, 10_000)).await.unwrap_err();
+        
+        let request_error = error.downcast_ref::<reqwest::Error>().expect("a connect error, not a response timeout");
+        assert!(request_error.is_connect(), "{:#}", error);
+        assert!(started.elapsed() < Duration::from_secs(2), "waited {:?} for the handshake", started.elapsed());
+    }
+    
+    #[tokio::test]
+    async fn read_timeout_fires_on_a_stalled_body() {
+        let reply = Reply { body_delay: Duration::from_millis(500), ..Reply::ok("text/plain", "stalled body") };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        let timeouts = timeouts(10_000, 100);
+        
+        let response = send_request(reqwest::Client::new().get(&url), None, &timeouts).await.unwrap();
+        let error = read_body(response, &timeouts).await.unwrap_err();
+        assert!(matches!(error.downcast_ref::<HttpTimeoutError>(), Some(HttpTimeoutError::Read { .. })));
+    }
+    
+    #[tokio::test]
+    async fn slow_responses_within_the_limits_succeed() {
+        let reply = Reply {
+            header_delay: Duration::from_millis(100),
+            body_delay: Duration::from_millis(100),
+            ..Reply::ok("text/plain", "in time")
+        };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        let timeouts = timeouts(2_000, 2_000);
+        
+        let response = send_request(reqwest::Client::new().get(&url), None, &timeouts).await.unwrap();
+        assert_eq!(read_body(response, &timeouts).await.unwrap(), b"in time");
+    }
+    
+    #[tokio::test]
+    async fn response_timeout_starts_after_the_upload() {
+        let reply = Reply { header_delay: Duration::from_millis(900), ..Reply::ok("text/plain", "done") };
+        let (url, _server) = stub_server::serve_once(reply).await;
+        
+        // Stands in for an upload that takes longer than the response timeout
+        let upload = ProgressBar::with_draw_target(Some(10), ProgressDrawTarget::hidden());
+        let finish = upload.clone();
+        tokio::spawn(async move {
+            tokio::time::sleep(Duration::from_millis(600)).await;
+            finish.set_position(10);
+        });
+        
+        let response = send_request(reqwest::Client::new().get(&url), Some(&upload), &timeouts(400, 10_000)).await;
+        assert!(response.is_ok());
+    }
+    
+    /// (start, duration) of each planned chunk
+    fn bounds(chunks: &[ChunkSpec]) -> Vec<(f64, Option<f64>)> {
+        chunks.iter().map(|chunk| (chunk.start, chunk.duration)).collect()
+    }
+    
+    #[test]
+    fn merges_a_short_final_chunk_into_the_previous_one() {
+        // 2050s in 1000s chunks leaves a 50s remainder, under the 60s minimum
+        let chunks = plan_chunks(2050.0, 1000, 60, 5);
+        assert_eq!(bounds(&chunks), vec![(0.0, Some(1000.0)), (995.0, None)]);
+        assert_eq!(chunks[1].index, 1);
+    }
+    
+    #[test]
+    fn keeps_a_final_chunk_at_the_minimum_length() {
+        let chunks = plan_chunks(2060.0, 1000, 60, 5);
+        assert_eq!(bounds(&chunks), vec![(0.0, Some(1000.0)), (995.0, Some(1005.0)), (1995.0, None)]);
+    }
+    
+    #[test]
+    fn keeps_a_short_final_chunk_that_would_not_fit_the_upload_limit() {
+        // Merging 30s into a 1630s chunk would pass the ~1638s limit
+        assert_eq!(plan_chunks(1660.0, 1630, 60, 5).len(), 2);
+        assert_eq!(plan_chunks(1630.0, 1600, 60, 5).len(), 1);
+    }
+    
+    #[test]
+    fn plans_a_single_chunk_for_short_audio() {
+        let chunks = plan_chunks(45.0, 1000, 60, 5);
+        assert_eq!(bounds(&chunks), vec![(0.0, None)]);
+    }
+    
+    #[test]
+    fn latency_percentiles_use_the_nearest_rank() {
+        let latencies: Vec<Duration> = (1..=20).map(|ms| Duration::from_millis(ms * 10)).collect();
+        
+        assert_eq!(percentile(&latencies, 50), Some(100.0));
+        assert_eq!(percentile(&latencies, 95), Some(190.0));
+        assert_eq!(percentile(&latencies[..1], 95), Some(10.0));
+        assert_eq!(percentile(&[], 50), None);
+    }
+    
+    #[test]
+    fn adaptive_rate_spaces_requests_and_backs_off() {
+        let rate = AdaptiveRate::new(RateLimits { initial: 60.0, min: 10.0, max: 62.0 });
+        let now = Instant::now();
+        
+        // One request a second at 60 per minute
+        assert_eq!(rate.reserve(now), Duration::ZERO);
+        assert_eq!(rate.reserve(now), Duration::from_secs(1));
+        
+        rate.succeeded();
+        rate.succeeded();
+        rate.succeeded();
+        assert_eq!(rate.rate(), 62.0);
+        
+        rate.throttled();
+        assert_eq!(rate.rate(), 31.0);
+        rate.throttled();
+        rate.throttled();
+        assert_eq!(rate.rate(), 10.0);
+    }
+    
+    #[test]
+    fn waveform_peaks_take_the_loudest_sample_of_each_window() {
+        let samples: [i16; 7] = [100, -16384, 0, 32767, i16::MIN, 3277, 0];
+        let pcm: Vec<u8> = samples.iter().flat_map(|sample| sample.to_le_bytes()).collect();
+        
+        let peaks = |window: usize, reads: &[&[u8]]| {
+            let mut peaks = PcmPeaks::new(window);
+            for read in reads {
+                peaks.push(read);
+            }
+            peaks.finish()
+        };
+        
+        assert_eq!(peaks(3, &[&pcm]), vec![0.5, 1.0, 0.0]);
+        assert_eq!(peaks(7, &[&pcm]), vec![1.0]);
+        assert!(peaks(3, &[]).is_empty());
+        // Samples split between reads are put back together
+        assert_eq!(peaks(3, &[&pcm[..3], &pcm[3..8], &[], &pcm[8..]]), vec![0.5, 1.0, 0.0]);
+    }
+    
+    #[tokio::test]
+    async fn media_commands_can_be_cancelled() {
+        let started = Instant::now();
+        let run = tokio::time::timeout(Duration::from_millis(100), run_media_command("sleep", &["5"])).await;
+        
+        assert!(run.is_err());
+        assert!(started.elapsed() < Duration::from_secs(2), "waited {:?} for the command", started.elapsed());
+    }
+    
+    #[test]
+    fn peaks_slice_to_a_part_of_the_audio() {
+        let peaks = Peaks { per_second: 10, start: 0.0, values: (0..50).map(|i| i as f32 / 100.0).collect() };
+        
+        let part = peaks.slice(1.0, 2.5);
+        assert_eq!((part.start, part.values.len()), (1.0, 15));
+        assert_eq!(part.values[0], 0.1);
+        
+        // Open-ended parts run to the ends of the audio
+        assert_eq!(peaks.slice(f64::NEG_INFINITY, 0.5).values.len(), 5);
+        assert_eq!(peaks.slice(4.0, f64::INFINITY).values.len(), 10);
+    }
+    
+    #[tokio::test]
+    async fn upload_slot_is_freed_once_the_body_is_sent() {
+        let reply = Reply { header_delay: Duration::from_millis(500), ..Reply::ok("text/plain", "done") };
+        let (url, server) = stub_server::serve_once(reply).await;
+        let slots = Arc::new(Semaphore::new(1));
+        
+        let slot = slots.clone().acquire_owned().await.unwrap();
+        let (body, upload) = upload_body_holding(Some(slot), false, vec![7; 200_000], "clip.mp3");
+        assert_eq!(slots.available_permits(), 0);
+        
+        let request = tokio::spawn(async move {
+            send_request(reqwest::Client::new().post(&url).body(body), Some(&upload), &HttpTimeouts::default()).await
+        });
+        
+        // The server is still "transcribing", but the next upload may start
+        tokio::time::sleep(Duration::from_millis(250)).await;
+        assert_eq!(slots.available_permits(), 1);
+        
+        request.await.unwrap().unwrap();
+        assert_eq!(server.await.unwrap().body.len(), 200_000);
+        
+        // With nothing to send, the slot is free before the request even starts
+        let slot = slots.clone().acquire_owned().await.unwrap();
+        let (_body, _upload) = upload_body_holding(Some(slot), false, Vec::new(), "empty.mp3");
+        assert_eq!(slots.available_permits(), 1);
+    }
+    
+    /// Files and directories directly under `dir`
+    fn entries(dir: &Path) -> usize {
+        fs::read_dir(dir).unwrap().count()
+    }
+    
+    #[test]
+    fn spool_holds_data_within_the_limit_in_memory() {
+        let base = tempfile::tempdir().unwrap();
+        let mut spool = Spool::new(Some(base.path()), "capture.mp3", Some(10));
+        std::io::Write::write_all(&mut spool, b"ID3 audio").unwrap();
+        
+        match spool.finish().unwrap() {
+            Spooled::Memory(data) => assert_eq!(data, b"ID3 audio"),
+            Spooled::File { path, .. } => panic!("written to {:?}", path),
+        }
+        assert_eq!(entries(base.path()), 0);
+    }
+    
+    #[test]
+    fn spool_moves_to_a_file_past_the_limit() {
+        let base = tempfile::tempdir().unwrap();
+        let mut spool = Spool::new(Some(base.path()), "capture.mp3", Some(10));
+        std::io::Write::write_all(&mut spool, b"ID3 audio").unwrap();
+        std::io::Write::write_all(&mut spool, b" and more").unwrap();
+        
+        let spooled = spool.finish().unwrap();
+        let path = spooled.path_or("unused.mp3");
+        assert_eq!(path.file_name().unwrap(), "capture.mp3");
+        assert_eq!(fs::read(&path).unwrap(), b"ID3 audio and more");
+        assert_eq!(spooled.len(), 18);
+        
+        // The file goes with its temporary directory
+        drop(spooled);
+        assert_eq!(entries(base.path()), 0);
+    }
+    
+    #[test]
+    fn spool_without_a_limit_always_writes_a_file() {
+        let base = tempfile::tempdir().unwrap();
+        let spooled = Spool::new(Some(base.path()), "capture.mp3", None).finish().unwrap();
+        
+        assert!(matches!(spooled, Spooled::File { len: 0, .. }));
+        assert!(spooled.path_or("unused.mp3").exists());
+    }
+    
+    #[tokio::test]
+    async fn small_downloads_can_skip_the_disk() {
+        let base = tempfile::tempdir().unwrap();
+        let retry = RetryPolicy::new(0, DEFAULT_RETRY_STATUS_CODES.to_vec()).unwrap();
+        
+        let (url, _server) = stub_server::serve_once(Reply::ok("audio/mpeg", "ID3 audio")).await;
+        let mut held = download_audio(&url, Some(base.path()), "audio.mp3", 1024, Some(100), &retry).await.unwrap();
+        assert_eq!(held.take_memory().as_deref(), Some(&b"ID3 audio"[..]));
+        assert_eq!(entries(base.path()), 0);
+        
+        // A declared length over the limit goes straight to disk
+        let (url, _server) = stub_server::serve_once(Reply::ok("audio/mpeg", "ID3 audio")).await;
+        let written = download_audio(&url, Some(base.path()), "audio.mp3", 1024, Some(5), &retry).await.unwrap();
+        assert_eq!(fs::read(written.path_or("unused.mp3")).unwrap(), b"ID3 audio");
+    }
+}
+
+/// One-shot local HTTP server standing in for a provider in tests
+#[cfg(test)]
+pub mod stub_server {
+    use std::time::Duration;
+    use tokio::io::{AsyncReadExt, AsyncWriteExt};
+    use tokio::net::TcpListener;
+    use tokio::task::JoinHandle;
+    
+    /// Response the stub sends, optionally stalling before the headers or mid-body
+    pub struct Reply {
+        pub status: u16,
+        pub content_type: &'static str,
+        pub body: String,
+        pub header_delay: Duration,
+        pub body_delay: Duration,
+    }
+    
+    impl Reply {
+        /// A prompt 200 response
+        pub fn ok(content_type: &'static str, body: &str) -> Self {
+            Self {
+                status: 200,
+                content_type,
+                body: body.to_string(),
+                header_delay: Duration::ZERO,
+                body_delay: Duration::ZERO,
+            }
+        }
+    }
+    
+    /// A request as the stub received it
+    pub struct Request {
+        /// Request line and headers
+        pub head: String,
+        /// Body, de-chunked
+        pub body: Vec<u8>,
+    }
+    
+    impl Request {
+        /// First request line, e.g. "POST /v1/audio/transcriptions HTTP/1.1"
+        pub fn request_line(&self) -> &str {
+            self.head.lines().next().unwrap_or_default()
+        }
+        
+        /// Value of a header, matched case-insensitively
+        pub fn header(&self, name: &str) -> Option<&str> {
+            self.head.lines().skip(1).find_map(|line| {
+                let (key, value) = line.split_once(':')?;
+                key.eq_ignore_ascii_case(name).then(|| value.trim())
+            })
+        }
+    }
+    
+    /// Serve a single request with `reply`, returning the base URL and the request received
+    pub async fn serve_once(reply: Reply) -> (String, JoinHandle<Request>) {
+        serve_after_resets(0, reply).await
+    }
+    
+    /// Reset the first `resets` connections once their request is read, then serve one with `reply`
+    pub async fn serve_after_resets(resets: usize, reply: Reply) -> (String, JoinHandle<Request>) {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}", listener.local_addr().unwrap());
+        
+        let handle = tokio::spawn(async move {
+            for _ in 0..resets {
+                let (mut socket, _) = listener.accept().await.unwrap();
+                read_request(&mut socket).await;
+                // Closing with a zero linger sends a RST, as a dropped mobile or VPN connection does
+                socket.set_linger(Some(Duration::ZERO)).unwrap();
+            }
+            
+            let (mut socket, _) = listener.accept().await.unwrap();
+            let request = read_request(&mut socket).await;
+            write_reply(&mut socket, &reply).await;
+            
+            request
+        });
+        
+        (url, handle)
+    }
+    
+    /// Serve one request with each of `replies` in turn, returning the base URL and the requests received
+    pub async fn serve_each(replies: Vec<Reply>) -> (String, JoinHandle<Vec<Request>>) {
+        let listener = TcpListener::bind("127.0.0.1:0").await.unwrap();
+        let url = format!("http://{}", listener.local_addr().unwrap());
+        
+        let handle = tokio::spawn(async move {
+            let mut requests = Vec::new();
+            for reply in replies {
+                let (mut socket, _) = listener.accept().await.unwrap();
+                requests.push(read_request(&mut socket).await);
+                write_reply(&mut socket, &reply).await;
+            }
+            
+            requests
+        });
+        
+        (url, handle)
+    }
+    
+    /// Send `reply`, closing the connection after it
+    async fn write_reply(socket: &mut tokio::net::TcpStream, reply: &Reply) {
+        tokio::time::sleep(reply.header_delay).await;
+        let head = format!(
+            "HTTP/1.1 {} Stub\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+            reply.status, reply.content_type, reply.body.len()
+        );
+        // The client may have given up already
+        if socket.write_all(head.as_bytes()).await.is_ok() {
+            let (first, rest) = reply.body.as_bytes().split_at(reply.body.len() / 2);
+            let _ = socket.write_all(first).await;
+            let _ = socket.flush().await;
+            tokio::time::sleep(reply.body_delay).await;
+            let _ = socket.write_all(rest).await;
+        }
+    }
+    
+    /// Read the head and a Content-Length or chunked body
+    async fn read_request(socket: &mut tokio::net::TcpStream) -> Request {
+        let mut data = Vec::new();
+        let mut buffer = [0u8; 8192];
+        let head_end = loop {
+            if let Some(end) = data.windows(4).position(|window| window == b"\r\n\r\n") {
+                break end;
+            }
+            let read = socket.read(&mut buffer).await.unwrap();
+            assert!(read > 0, "connection closed before the request head");
+            data.extend_from_slice(&buffer[..read]);
+        };
+        
+        let head = String::from_utf8_lossy(&data[..head_end]).into_owned();
+        let mut body = data.split_off(head_end + 4);
+        let mut request = Request { head, body: Vec::new() };
+        
+        if let Some(length) = request.header("content-length").and_then(|length| length.parse::<usize>().ok()) {
+            while body.len() < length {
+                let read = socket.read(&mut buffer).await.unwrap();
+                assert!(read > 0, "connection closed before the request body");
+                body.extend_from_slice(&buffer[..read]);
+            }
+            request.body = body;
+        } else if request.header("transfer-encoding").is_some_and(|encoding| encoding.contains("chunked")) {
+            while !body.ends_with(b"0\r\n\r\n") {
+                let read = socket.read(&mut buffer).await.unwrap();
+                assert!(read > 0, "connection closed before the last chunk");
+                body.extend_from_slice(&buffer[..read]);
+            }
+            request.body = dechunk(&body);
+        }
+        
+        request
+    }
+    
+    /// Join the chunks of a chunked transfer-encoded body
+    fn dechunk(mut data: &[u8]) -> Vec<u8> {
+        let mut body = Vec::new();
+        loop {
+            let line_end = data.windows(2).position(|window| window == b"\r\n").unwrap();
+            let size = usize::from_str_radix(std::str::from_utf8(&data[..line_end]).unwrap().trim(), 16).unwrap();
+            if size == 0 {
+                return body;
+            }
+            body.extend_from_slice(&data[line_end + 2..line_end + 2 + size]);
+            data = &data[line_end + 4 + size..];
+        }
+    }
 }
@@ -0,0 +1,146 @@
+use anyhow::Result;
+use log::debug;
+use std::path::{Path, PathBuf};
+use tokio::process::Command;
+
+use crate::captions::Cue;
+use crate::utils;
+
+/// Names of the whisper.cpp command-line program, newest first (older builds call it `main`)
+const BINARY_NAMES: &[&str] = &["whisper-cli", "main"];
+
+/// How to get whisper.cpp when it's missing
+const INSTALL_HINT: &str = "Install whisper.cpp with 'brew install whisper-cpp' or build it from \
+                            https://github.com/ggerganov/whisper.cpp, then put whisper-cli on your PATH or pass --whisper-binary";
+
+/// Settings for running whisper.cpp on a file
+pub struct WhisperCpp<'a> {
+    /// The whisper-cli (or main) program
+    pub binary: &'a Path,
+    /// ggml model file, e.g. ggml-base.en.bin
+    pub model: &'a Path,
+    /// Where the converted audio is written (the OS temp dir if None)
+    pub temp_dir: Option<&'a Path>,
+    /// Show a spinner while whisper.cpp runs
+    pub progress: bool,
+}
+
+/// Find the whisper.cpp program: the given path, or whisper-cli/main on the PATH
+pub fn find_binary(configured: Option<&Path>) -> Result<PathBuf> {
+    if let Some(path) = configured {
+        if path.is_file() || (path.components().count() == 1 && utils::check_command(&path.to_string_lossy())) {
+            return Ok(path.to_path_buf());
+        }
+        return Err(anyhow::anyhow!("whisper.cpp program {:?} not found. {}", path, INSTALL_HINT));
+    }
+    
+    BINARY_NAMES.iter()
+        .find(|name| utils::check_command(name))
+        .map(PathBuf::from)
+        .ok_or_else(|| anyhow::anyhow!("whisper.cpp (whisper-cli) isn't installed. {}", INSTALL_HINT))
+}
+
+/// Check that a ggml model file exists before any audio is processed
+pub fn check_model(model: &Path) -> Result<()> {
+    if !model.is_file() {
+        return Err(anyhow::anyhow!(
+            "whisper.cpp model {:?} not found. Download one with whisper.cpp's \
+             models/download-ggml-model.sh (e.g. 'download-ggml-model.sh base.en') and pass its path to --whisper-model",
+            model
+        ));
+    }
+    
+    Ok(())
+}
+
+impl WhisperCpp<'_> {
+    /// Transcribe a file, returning the segments whisper.cpp prints
+    /// 
+    /// whisper.cpp only reads 16 kHz WAV, so the audio is converted with
+    /// ffmpeg first. A `None` language lets whisper.cpp detect it (its own
+    /// default is English), and `translate` produces English like the
+    /// translations endpoint.
+    pub async fn transcribe(
+        &self,
+        audio_file: &Path,
+        language: Option<&str>,
+        prompt: Option<&str>,
+        temperature: f32,
+        translate: bool,
+    ) -> Result<Vec<Cue>> {
+        let temp_dir = utils::create_temp_dir(self.temp_dir)?;
+        let wav_file = temp_dir.path().join("audio.wav");
+        utils::run_media_command(
+            "ffmpeg",
+            &[
+                "-nostdin", "-v", "quiet", "-y",
+                "-i", audio_file.to_str().unwrap(),
+                "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le",
+                wav_file.to_str().unwrap(),
+            ],
+        ).await?;
+        
+        let mut command = Command::new(self.binary);
+        command.arg("-m").arg(self.model)
+               .arg("-f").arg(&wav_file)
+               .args(["-l", language.unwrap_or("auto")])
+               .args(["-tp", &temperature.to_string()])
+               .arg("-np");
+        
+        if translate {
+            command.arg("-tr");
+        }
+        
+        if let Some(prompt) = prompt {
+            command.args(["--prompt", prompt]);
+        }
+        
+        // Killed if the transcription is cancelled (e.g. by Ctrl-C in a batch)
+        command.kill_on_drop(true);
+        debug!("Running whisper.cpp on {:?}", audio_file);
+        
+        let progress = utils::spinner(
+            self.progress,
+            format!("Transcribing {} with whisper.cpp", audio_file.file_name().and_then(|name| name.to_str()).unwrap_or("audio")),
+        );
+        let output = command.output().await;
+        progress.finish_and_clear();
+        let output = output.map_err(|e| anyhow::anyhow!("Failed to run whisper.cpp {:?}: {}. {}", self.binary, e, INSTALL_HINT))?;
+        
+        if !output.status.success() {
+            return Err(anyhow::anyhow!(
+                "whisper.cpp failed: {}",
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        
+        Ok(parse_output(&String::from_utf8_lossy(&output.stdout)))
+    }
+}
+
+/// Parse whisper.cpp's `[00:00:00.000 --> 00:00:04.000]  text` lines into segments
+fn parse_output(stdout: &str) -> Vec<Cue> {
+    stdout.lines()
+        .filter_map(|line| {
+            let (times, text) = line.trim().strip_prefix('[')?.split_once(']')?;
+            let (start, end) = times.split_once("-->")?;
+            
+            Some(Cue {
+                start: parse_timestamp(start.trim())?,
+                end: parse_timestamp(end.trim())?,
+                text: text.trim().to_string(),
+            })
+        })
+        .filter(|cue| !cue.text.is_empty())
+        .collect()
+}
+
+/// Parse an `HH:MM:SS.mmm` timestamp into seconds
+fn parse_timestamp(value: &str) -> Option<f64> {
+    let mut parts = value.split(':');
+    let hours: f64 = parts.next()?.parse().ok()?;
+    let minutes: f64 = parts.next()?.parse().ok()?;
+    let seconds: f64 = parts.next()?.parse().ok()?;
+    
+    Some(hours * 3600.0 + minutes * 60.0 + seconds)
+}
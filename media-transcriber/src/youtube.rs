@@ -5,7 +5,6 @@ use serde::Deserialize;
 use std::fs;
 use std::path::{Path, PathBuf};
 use std::process::Command;
-use tempfile::tempdir;
 
 use crate::config::Config;
 use crate::transcription::TranscriptionService;
@@ -33,8 +32,8 @@ impl<'a> YouTubeProcessor<'a> {
         Self { config }
     }
     
-    /// Process a YouTube URL (video, channel, or playlist)
-    pub async fn process(&self, url: &str) -> Result<()> {
+    /// Process a YouTube URL (video, channel, or playlist), returning the transcript files written
+    pub async fn process(&self, url: &str) -> Result<Vec<PathBuf>> {
         info!("Processing YouTube URL: {}", url);
         
         // Check if yt-dlp is installed
@@ -46,12 +45,10 @@ impl<'a> YouTubeProcessor<'a> {
         
         // Determine if this is a single video or a channel/playlist
         if self.is_single_video(url) {
-            self.process_single_video(url).await?;
+            self.process_single_video(url).await
         } else {
-            self.process_channel_or_playlist(url).await?;
+            self.process_channel_or_playlist(url).await
         }
-        
-        Ok(())
     }
     
     /// Check if URL is a single video
@@ -74,7 +71,7 @@ impl<'a> YouTubeProcessor<'a> {
     }
     
     /// Process a single YouTube video
-    async fn process_single_video(&self, url: &str) -> Result<()> {
+    async fn process_single_video(&self, url: &str) -> Result<Vec<PathBuf>> {
         info!("Processing single YouTube video: {}", url);
         
         // Get video info
@@ -87,13 +84,11 @@ impl<'a> YouTubeProcessor<'a> {
         self.save_video_info(&video_info, url, &video_dir)?;
         
         // Download and transcribe video
-        self.download_and_transcribe_video(url, &video_dir).await?;
-        
-        Ok(())
+        self.download_and_transcribe_video(url, &video_dir).await
     }
     
     /// Process a YouTube channel or playlist
-    async fn process_channel_or_playlist(&self, url: &str) -> Result<()> {
+    async fn process_channel_or_playlist(&self, url: &str) -> Result<Vec<PathBuf>> {
         info!("Processing YouTube channel or playlist: {}", url);
         
         // Get channel/playlist info
@@ -121,6 +116,8 @@ impl<'a> YouTubeProcessor<'a> {
         };
         
         // Process each video
+        let mut outputs = Vec::new();
+        
         for (i, video_url) in videos_to_process.iter().enumerate() {
             info!("Processing video {}/{}: {}", i + 1, videos_to_process.len(), video_url);
             
@@ -135,8 +132,9 @@ impl<'a> YouTubeProcessor<'a> {
                     self.save_video_info(&video_info, video_url, &video_dir)?;
                     
                     // Download and transcribe video
-                    if let Err(e) = self.download_and_transcribe_video(video_url, &video_dir).await {
-                        error!("Failed to process video: {}", e);
+                    match self.download_and_transcribe_video(video_url, &video_dir).await {
+                        Ok(files) => outputs.extend(files),
+                        Err(e) => error!("Failed to process video: {}", e),
                     }
                 }
                 Err(e) => {
@@ -145,7 +143,7 @@ impl<'a> YouTubeProcessor<'a> {
             }
         }
         
-        Ok(())
+        Ok(outputs)
     }
     
     /// Get video information using yt-dlp
@@ -309,11 +307,11 @@ impl<'a> YouTubeProcessor<'a> {
     }
     
     /// Download and transcribe a YouTube video
-    async fn download_and_transcribe_video(&self, url: &str, video_dir: &Path) -> Result<()> {
+    async fn download_and_transcribe_video(&self, url: &str, video_dir: &Path) -> Result<Vec<PathBuf>> {
         debug!("Downloading and transcribing video: {}", url);
         
         // Create temporary directory
-        let temp_dir = tempdir()?;
+        let temp_dir = utils::create_temp_dir(self.config.temp_dir.as_deref())?;
         let audio_file = temp_dir.path().join("audio.mp3");
         
         // Download audio using yt-dlp
@@ -338,10 +336,10 @@ impl<'a> YouTubeProcessor<'a> {
         let transcript_file = video_dir.join("transcript.txt");
         let transcription_service = TranscriptionService::new(self.config);
         
-        transcription_service.transcribe_file(&audio_file, &transcript_file).await
+        let outputs = transcription_service.transcribe_file(&audio_file, &transcript_file).await
             .context("Failed to transcribe video audio")?;
         
         info!("Successfully transcribed video: {}", url);
-        Ok(())
+        Ok(outputs)
     }
 }